@@ -15,37 +15,88 @@ type rConfig struct {
 	Msg string `json:"msg"`
 }
 
+type rReloadConfig struct {
+	Res             int                   `json:"res"`
+	Msg             string                `json:"msg"`
+	Errors          []string              `json:"errors,omitempty"`          // 설정 파일의 잘못된 항목
+	Changes         []schema.ConfigChange `json:"changes"`                   // 바뀐 항목. 실패한 경우에는 바뀌려 했던 항목
+	RestartRequired []string              `json:"restartrequired,omitempty"` // 서버를 다시 시작해야 적용되는 항목
+}
+
 const (
-	configOK               = 0
-	configBadRequest       = -10
-	configServerError      = -20
-	configPermissionDenied = -30
-	configBadConfgFile     = -40
+	configOK           = 0
+	configBadRequest   = -10
+	configServerError  = -20
+	configForbidden    = -30
+	configBadConfgFile = -40
 )
 
-// ensureAdminOrBlock 함수는 운영자인지 확인하고 만일 운영자가 아니라면 해당 유저를 블럭 시킨다.
-func ensureAdminOrBlock(env *Environ) (bool, *rConfig) {
-	if !env.Me.IsAdmin() {
-		if err := env.Me.Block("config handler called with no permission"); err != nil {
-			log.Panic(err)
-		}
-		return false, &rConfig{configPermissionDenied, "permission denied."}
-	}
-	return true, nil
-}
-
 // reloadConfigHandler 함수는 config 파일을 다시 읽는다.
+// 파일이 잘못되었다면 현재 설정을 그대로 유지하고 잘못된 항목과 바뀌려 했던 항목을 돌려준다.
+// schema.PermConfigReload 권한이 필요하다.
 func reloadConfigHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
 	var req qConfig
 	Unmarshal(r, &req)
 
-	// 운영자인지 확인하고 운영자가 아니리면 블럭 처리
-	if ok, res := ensureAdminOrBlock(env); !ok {
+	result, err := schema.ReloadConfig("admin")
+	if err != nil {
+		log.Warnf("config reload rejected. err=%v", err)
+		audit(r, env.Me, schema.AuditConfigReload, schema.AuditResultFailure, nil, err.Error())
+		res := rReloadConfig{Res: configBadConfgFile, Msg: "invalid config file.", Changes: result.Changes}
+		if cerr, ok := err.(*schema.ConfigError); ok {
+			res.Errors = cerr.Errors
+		}
 		return res
 	}
 
-	// 설정 파일을 다시 읽는다.
-	schema.LoadConfig()
+	audit(r, env.Me, schema.AuditConfigReload, schema.AuditResultSuccess, nil, result.Summary())
+	return rReloadConfig{configOK, "success", nil, result.Changes, result.RestartRequired}
+}
+
+type rAdminConfig struct {
+	Res     int                 `json:"res"`
+	Msg     string              `json:"msg"`
+	Sources []string            `json:"sources"` // 설정을 만들 때 읽은 파일과 환경 변수(읽은 순서)
+	Items   []schema.ConfigItem `json:"items"`   // 현재 적용된 설정 항목. 비밀 항목의 값은 가려진다.
+}
+
+// adminConfigHandler 함수는 파일과 환경 변수를 모두 반영한 현재 설정을 돌려준다.
+// 비밀번호 등 비밀 항목은 값이 설정되었는지 여부만 알 수 있다. schema.PermConfigRead 권한이 필요하다.
+func adminConfigHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	return rAdminConfig{configOK, "success", env.Conf.Sources(), env.Conf.Items()}
+}
+
+type qResetTwoFactor struct {
+	UID int64 `json:"uid"`
+}
+
+// adminResetTwoFactorHandler 함수는 인증 앱과 복구 코드를 모두 잃어버린 사용자의 2단계 인증을 끈다.
+// schema.PermUserTwoFactor 권한이 필요하다.
+func adminResetTwoFactorHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	var req qResetTwoFactor
+	Unmarshal(r, &req)
+
+	user, err := schema.LoadUserFromUID(req.UID)
+	if err == schema.ErrNotFound {
+		return rConfig{configBadRequest, "user not found."}
+	} else if err != nil {
+		log.Debug(err)
+		return rConfig{configServerError, "database select failed."}
+	}
+	// 자신보다 권한이 많은 사용자(고객 지원 담당자에게는 운영자)의 2단계 인증은 끌 수 없다.
+	if ok, err := env.Me.CanManage(user); err != nil {
+		log.Debug(err)
+		return rConfig{configServerError, "database select failed."}
+	} else if !ok {
+		return rConfig{configForbidden, "not enough privilege."}
+	}
+
+	if err := schema.DisableTOTP(user.UID); err != nil {
+		log.Debug(err)
+		return rConfig{configServerError, "database delete failed."}
+	}
+
+	adminLog(r, env, user, schema.AuditTwoFactorReset, "")
 
 	return rConfig{configOK, "success"}
 }
@@ -10,6 +10,28 @@ import (
 	"jsproj.com/koo/server/auth/schema"
 )
 
+// activationMailData 구조체는 인증 메일 템플릿에 넘기는 값이다.
+type activationMailData struct {
+	ID            string
+	ActivationKey string
+	ExpireHour    int64
+}
+
+// sendActivationMail 함수는 사용자에게 이메일 인증 링크를 보낸다.
+func sendActivationMail(user *schema.User, env *Environ) error {
+	return schema.EnqueueMail(activationMail(user, env))
+}
+
+// activationMail 함수는 사용자에게 보낼 이메일 인증 메일을 만든다.
+func activationMail(user *schema.User, env *Environ) *schema.OutboxMail {
+	data := activationMailData{
+		ID:            user.ID,
+		ActivationKey: user.ActivationKey,
+		ExpireHour:    env.Conf.ActivationExpire() / 3600,
+	}
+	return schema.NewMailWithData(user, "activation_mail_title.tmpl", "activation_mail.tmpl", data)
+}
+
 // activationHandler 함수는 가입시 유저에게 보낸 메일의 링크를 클릭하면 활성화 처리한다.
 // 따라서 로그인 전에 토큰 발급 없이 불릴 수 있어야 한다.
 func activationHandler(
@@ -23,7 +45,12 @@ func activationHandler(
 
 	// 얻어온 activationKey를 가진 유저를 데이터베이스에서 찾아온다.
 	user, err := schema.LoadUserFromActivateionKey(activationKey)
-	if err != nil || user == nil {
+	if err != nil && err != schema.ErrNotFound {
+		log.Debug(err)
+		rAction{actionInternalServerError, "database select failed."}.mustSend(r, w)
+		return nil
+	}
+	if err == schema.ErrNotFound {
 		body := fmt.Sprintf(
 			"{\"res\":%d,\"msg\":\"%s\"}",
 			actionPageNotFound,
@@ -54,11 +81,32 @@ func activationHandler(
 		return nil
 	}
 
+	// 인증키가 만료 되었다면 /activation/resend로 새 링크를 받도록 안내한다.
+	if user.IsActivationExpired() {
+		body := fmt.Sprintf(
+			"{\"res\":%d,\"msg\":\"%s\"}",
+			actionPageNotFound,
+			"activation key expired.")
+		log.WithFields(log.Fields{
+			"ip":   GetIP(r),
+			"url":  r.URL.Path,
+			"body": body,
+		}).Debug("RES")
+
+		audit(r, nil, schema.AuditActivation, schema.AuditResultFailure, user, "expired")
+
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusGone)
+		utils.JoinTemplate(w, env.Conf.TemplatePath("activation_expired.tmpl"), user)
+		return nil
+	}
+
 	// activationKey로 유저를 찾았으므로 해당 유저를 정상 가입 상태로 만든다.
 	user.Status = schema.UserStatusNormal
-	if _, err := env.DB.Auth.Update(user); err != nil {
+	if err := env.DB.Users.UpdateUser(user); err != nil {
 		log.Panic(err)
 	}
+	audit(r, nil, schema.AuditActivation, schema.AuditResultSuccess, user, "")
 
 	// 유저에게 html 템플릿을 이용하여 성공 메시지를 보여준다.
 	tmpl := env.Conf.TemplatePath("activation_ok.tmpl")
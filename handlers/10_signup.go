@@ -3,7 +3,7 @@ package handlers
 import (
 	"net/http"
 
-	"jsproj.com/koo/gosari/crypto"
+	log "github.com/Sirupsen/logrus"
 	"jsproj.com/koo/gosari/utils"
 	"jsproj.com/koo/server/auth/schema"
 )
@@ -14,8 +14,9 @@ type qSignup struct {
 }
 
 type rSignup struct {
-	Res int    `json:"res"`
-	Msg string `json:"msg"`
+	Res    int         `json:"res"`
+	Msg    string      `json:"msg"`
+	Fields fieldErrors `json:"fields,omitempty"` // 항목별 오류 사유
 }
 
 const (
@@ -39,7 +40,7 @@ func signupError(res int) rSignup {
 	if !ok {
 		msg = signupErrors[defaultError]
 	}
-	return rSignup{res, msg}
+	return rSignup{res, msg, nil}
 }
 
 // signupHandler 함수는 사용자의 가입을 처리한다.
@@ -56,57 +57,59 @@ func signupHandler(
 		return signupError(signupBadIDRequest)
 	}
 
-	// 비밀번호 형식 검사
-	if !schema.IsValidPasswordFormat(req.Password) {
-		return signupError(signupBadPasswordRequest)
+	// 비밀번호 정책 검사
+	if reasons := schema.CheckPasswordPolicy(req.ID, req.Password); len(reasons) > 0 {
+		res := signupError(signupBadPasswordRequest)
+		res.Fields = fieldErrors{"password": reasons}
+		return res
 	}
 
-	// Salt를 앞쪽에 붙인 비밀번호를 만든다.
-	salt := crypto.NewSalt(schema.SaltMaxSize)
-	password := string(crypto.SecurePassword(salt, []byte(req.Password)))
+	// 비밀번호를 현재 기본 방식으로 해시한다.
+	password, err := schema.HashPassword(req.Password)
+	if err != nil {
+		return signupError(signupServerError)
+	}
 
 	// config 파일 설정에 [activation] 섹션의 use가 true로 되어 있는 경우 사용자에게 이메일을
 	// 보낸다. activation을 사용하지 않는 경우에는 바로 일반 유저로 가입 시킨다.
-	var initialStatus int
-	var activeKey string
+	// 사용자의 가입 정보를 수집하여 구조체로 만든다.
+	user := &schema.User{
+		ID:          req.ID,
+		Info:        "{}",
+		Status:      schema.UserStatusNormal, // New account can use immediately.
+		Password:    password,
+		PasswordTmp: "",
+		Created:     utils.ServerTime(),
+		Type:        schema.UserTypeNormal,
+	}
 	if env.Conf.IsUseActivation() {
 		// Need email activation.
-		initialStatus = schema.UserStatusDeactivated
-		activeKey, _ = crypto.NewUUID()
-	} else {
-		// New account can use immediately.
-		initialStatus = schema.UserStatusNormal
-		activeKey = ""
+		user.Status = schema.UserStatusDeactivated
+		if err := user.SetActivationKey(); err != nil {
+			return signupError(signupServerError)
+		}
 	}
 
-	// 사용자의 가입 정보를 수집하여 구조체로 만든다.
-	user := &schema.User{
-		ID:            req.ID,
-		Info:          "{}",
-		Status:        initialStatus,
-		Password:      password,
-		PasswordTmp:   "",
-		Created:       utils.ServerTime(),
-		ActivationKey: activeKey,
-		Type:          schema.UserTypeNormal,
+	// 인증 메일은 사용자와 같은 트랜잭션으로 대기열에 넣어 메일 없이 가입되는 일이 없게 한다.
+	var newMail func(u *schema.User) *schema.OutboxMail
+	if env.Conf.IsUseActivation() {
+		newMail = func(u *schema.User) *schema.OutboxMail {
+			return activationMail(u, env)
+		}
 	}
 
 	// 사용자를 데이터베이스에 저장한다.
-	err := env.DB.Auth.Insert(user)
+	err = schema.SignupUser(user, newMail)
 	if env.DB.IsDuplicated(err) {
 		// 아이디가 중복됨
 		return signupError(signupIDDuplicated)
 	} else if err != nil {
 		// 기타 데이터베이스 에러 발생
+		log.Debug(err)
 		return signupError(signupServerError)
 	}
 
-	if env.Conf.IsUseActivation() {
-		// 인증 메일을 발송한다.
-		if err := schema.SendMail(user, "activation_mail_title.tmpl", "activation_mail.tmpl"); err != nil {
-			return signupError(signupServerError)
-		}
-	}
+	audit(r, nil, schema.AuditSignup, schema.AuditResultSuccess, user, "")
 
-	return rSignup{signupOK, "success"}
+	return rSignup{signupOK, "success", nil}
 }
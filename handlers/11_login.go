@@ -1,45 +1,55 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
-	//"time"
+	"strconv"
+	"strings"
 
-	//log "github.com/Sirupsen/logrus"
-	"jsproj.com/koo/gosari/crypto"
+	log "github.com/Sirupsen/logrus"
 	"jsproj.com/koo/server/auth/schema"
 )
 
 type qLogin struct {
 	ID       string `json:"id"`
 	Password string `json:"password"`
+	Device   string `json:"device"` // 세션 목록에 표시될 기기 이름
 }
 
 type rLogin struct {
-	Res       int    `json:"res"`
-	Msg       string `json:"msg"`
-	Token     string `json:"token"`
-	TempLogin bool   `json:"templogin"`
+	Res          int    `json:"res"`
+	Msg          string `json:"msg"`
+	Token        string `json:"token"`
+	TempLogin    bool   `json:"templogin"` // true이면 비밀번호를 바꾸기 전까지 /changepass만 부를 수 있다.
+	RefreshToken string `json:"refreshtoken"`
+	ExpiresIn    int64  `json:"expiresin"`            // 액세스 토큰 유효 기간(초)
+	RetryAfter   int64  `json:"retryafter,omitempty"` // loginThrottledError인 경우 다시 시도할 수 있을 때까지 남은 시간(초)
+	Challenge    string `json:"challenge,omitempty"`  // loginSecondFactorRequired인 경우 /login/2fa에 보낼 도전 토큰
 }
 
 const (
-	loginOK                 = 0
-	loginBadIDRequest       = -1110
-	loginBadPasswordRequest = -1120
-	loginServerError        = -1130
-	loginNoUserError        = -1140 // 사용자가 데이터베이스에 없음
-	loginNotActivatedError  = -1150 // 아직 메일 인증을 완료하지 않음
-	loginBlockUserError     = -1160 // 사용자가 블럭됨
-	loginTokenIssueError    = -1199 // 토큰이 만료되었거나 아직 발급되지 않음
+	loginOK                   = 0
+	loginBadIDRequest         = -1110
+	loginBadPasswordRequest   = -1120
+	loginServerError          = -1130
+	loginNoUserError          = -1140 // 사용자가 데이터베이스에 없음
+	loginNotActivatedError    = -1150 // 아직 메일 인증을 완료하지 않음
+	loginBlockUserError       = -1160 // 사용자가 블럭됨
+	loginThrottledError       = -1170 // 로그인 실패가 많아 잠시 잠김
+	loginSecondFactorRequired = -1180 // 비밀번호는 맞았으며 2단계 인증 코드가 필요함
+	loginTokenIssueError      = -1199 // 토큰이 만료되었거나 아직 발급되지 않음
 )
 
 var loginErrors = map[int]string{
 	defaultError: "Error occured during login.",
 
-	loginBadIDRequest:      "Invalid email format.",
-	loginNoUserError:       "Incorrect ID or Password.",
-	loginBlockUserError:    "System has blocked your account. Please contact the support team for more information.",
-	loginNotActivatedError: "Account not yet activated. Please check your email.",
-	loginTokenIssueError:   "Error occured during issue token.",
+	loginBadIDRequest:         "Invalid email format.",
+	loginNoUserError:          "Incorrect ID or Password.",
+	loginBlockUserError:       "System has blocked your account. Please contact the support team for more information.",
+	loginNotActivatedError:    "Account not yet activated. Please check your email or request a new activation email.",
+	loginThrottledError:       "Too many failed login attempts. Please try again later.",
+	loginSecondFactorRequired: "Second factor required. Please enter the code from your authenticator app.",
+	loginTokenIssueError:      "Error occured during issue token.",
 }
 
 func loginError(res int) rLogin {
@@ -47,71 +57,209 @@ func loginError(res int) rLogin {
 	if !ok {
 		msg = loginErrors[defaultError]
 	}
-	return rLogin{res, msg, "", false}
+	return rLogin{Res: res, Msg: msg}
+}
+
+// loginThrottled 함수는 로그인이 잠겼을 때의 응답을 만든다.
+func loginThrottled(w http.ResponseWriter, retryAfter int64) rLogin {
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	res := loginError(loginThrottledError)
+	res.RetryAfter = retryAfter
+	return res
+}
+
+// loginFailure 함수는 로그인 실패를 감사 기록으로 남기고 실패 응답을 만든다.
+func loginFailure(r *http.Request, id string, res int) rLogin {
+	auditID(r, schema.AuditLoginFailure, schema.AuditResultFailure, id, "res="+strconv.Itoa(res))
+	return loginError(res)
+}
+
+// loginThrottleKeys 함수는 로그인 실패 횟수를 셀 아이디별, IP별 키를 만든다.
+func loginThrottleKeys(id string, ip string) (string, string) {
+	return "login:id:" + strings.ToLower(id), "login:ip:" + ip
 }
 
 // loginHandler 함수는 사용자의 로그인을 처리한다.
+// 아이디별, IP별로 로그인 실패 횟수를 세어 허용 횟수를 넘으면 점점 길게 로그인을 잠근다.
 func loginHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
 
 	var req qLogin
-	var token string
 	Unmarshal(r, &req)
 
-	isTempLogin := false // 임시 로그인인 경우 바로 비밀번호를 변경해야 한다.
-
-	//time.Sleep(3 * time.Second)
-
 	// 아이디 형식 검사
 	if !schema.IsValidIDFormat(req.ID) {
 		return loginError(loginBadIDRequest)
 	}
 
+	// 잠겨 있다면 비밀번호를 확인하지 않고 바로 거부한다.
+	throttle := schema.Throttle()
+	idKey, ipKey := loginThrottleKeys(req.ID, GetIP(r))
+	idRetry, err := throttle.RetryAfter(idKey)
+	if err != nil {
+		log.Debug(err)
+		return loginError(loginServerError)
+	}
+	ipRetry, err := throttle.RetryAfter(ipKey)
+	if err != nil {
+		log.Debug(err)
+		return loginError(loginServerError)
+	}
+	// 잠겨 있는 동안의 요청은 감사 기록을 남기지 않는다. 잠긴 시점은 아래 fail에서 기록된다.
+	if idRetry > 0 || ipRetry > 0 {
+		if ipRetry > idRetry {
+			return loginThrottled(w, ipRetry)
+		}
+		return loginThrottled(w, idRetry)
+	}
+
+	// 로그인 실패 횟수를 늘린다. 이번 실패로 잠겼다면 잠김 응답을 보낸다.
+	fail := func(res int) rLogin {
+		idLock, err := throttle.Fail(idKey, env.Conf.LoginThrottle())
+		if err != nil {
+			log.Debug(err)
+		}
+		ipLock, err := throttle.Fail(ipKey, env.Conf.LoginIPThrottle())
+		if err != nil {
+			log.Debug(err)
+		}
+		if idLock > 0 || ipLock > 0 {
+			log.WithFields(log.Fields{
+				"id":     req.ID,
+				"ip":     GetIP(r),
+				"idlock": idLock,
+				"iplock": ipLock,
+			}).Warn("LOGIN_THROTTLED")
+			// 이번 실패로 잠겼다면 실패 기록 대신 잠김 기록을 남긴다.
+			detail := fmt.Sprintf("res=%d idlock=%d iplock=%d", res, idLock, ipLock)
+			auditID(r, schema.AuditLoginFailure, schema.AuditResultDenied, req.ID, detail)
+			return loginError(res)
+		}
+		return loginFailure(r, req.ID, res)
+	}
+
 	// 비밀번호 형식 검사
 	if !schema.IsValidPasswordFormat(req.Password) {
 		// 비밀번호 형식이 틀렸지만 loginNoUserError로 발생시키는 이유는
 		// 보안상의 이유로 ID가 존재하는지 여부를 확인할 수 없게 하기 위해서이다.
-		return loginError(loginNoUserError)
+		return fail(loginNoUserError)
 	}
 
 	// 데이터베이스에서 해당 유저를 불러온다.
 	user, err := schema.LoadUserFromID(req.ID)
-	if err != nil {
-		return loginError(loginNoUserError)
+	if err == schema.ErrNotFound {
+		return fail(loginNoUserError)
+	} else if err != nil {
+		// 데이터베이스 오류는 사용자의 실패가 아니므로 실패 횟수를 늘리지 않는다.
+		log.Debug(err)
+		return loginError(loginServerError)
 	}
 
 	// 아직 이메일 인증을 하지 않아서 로그인 불가능.
 	if user.IsDeactivated() {
-		return loginError(loginNotActivatedError)
+		return loginFailure(r, req.ID, loginNotActivatedError)
+	}
+
+	// 탈퇴 후 유예 기간이 지났다면 곧 삭제될 사용자이므로 없는 사용자로 취급한다.
+	if user.IsWithdraw() && !user.IsRestorable() {
+		return fail(loginNoUserError)
 	}
 
 	// 정상 유저 상태가 아니다.(블럭 혹은 기타 사유로) 로그인을 금지 시킨다.
-	if !user.IsNormal() {
-		return loginError(loginBlockUserError)
-	}
-
-	// 비밀번호를 비교한다.
-	salt := []byte(user.Password[:schema.SaltMaxSize])
-	reqPass := string(crypto.SecurePassword(salt, []byte(req.Password)))
-	if user.Password != reqPass {
-		// 비밀번호가 틀리면 임시 비밀번호를 체크한다.
-		if user.PasswordTmp != "" {
-			salt = []byte(user.PasswordTmp[:schema.SaltMaxSize])
-			reqPass = string(crypto.SecurePassword(salt, []byte(req.Password)))
-			if user.PasswordTmp == reqPass {
-				isTempLogin = true
-			} else {
-				return loginError(loginNoUserError)
-			}
-		} else {
+	// 유예 기간 중인 탈퇴 사용자는 로그인에 성공하면 탈퇴가 취소된다.
+	if !user.IsNormal() && !user.IsRestorable() {
+		return loginFailure(r, req.ID, loginBlockUserError)
+	}
+
+	// 비밀번호를 비교한다. 저장된 해시의 방식에 맞게 검증된다.
+	ok, rehash, err := schema.VerifyPassword(user.Password, req.Password)
+	if err != nil {
+		log.Debug(err)
+	}
+	if !ok {
+		return fail(loginNoUserError)
+	}
+	if rehash {
+		// 이전 방식이나 파라미터로 저장된 비밀번호는 현재 기본 방식으로 다시 해시한다.
+		// 실패하더라도 다음 로그인 시에 다시 시도하면 되므로 로그인은 계속 진행한다.
+		if err := user.SetPassword(req.Password); err != nil {
+			log.Warnf("password rehash failed. id=%s, err=%v", user.ID, err)
+		} else if err := env.DB.Users.UpdateUser(user); err != nil {
+			log.Warnf("password rehash update failed. id=%s, err=%v", user.ID, err)
+		}
+	}
+
+	// 로그인에 성공하면 아이디의 실패 기록을 지운다. IP의 실패 기록은 다른 계정을 대상으로 한
+	// 시도일 수 있으므로 지우지 않는다.
+	if err := throttle.Reset(idKey); err != nil {
+		log.Debug(err)
+	}
+
+	// 2단계 인증을 사용하는 사용자는 토큰 대신 도전 토큰을 받고 /login/2fa로 코드를 확인해야 한다.
+	enabled, err := schema.IsTOTPEnabled(user.UID)
+	if err != nil {
+		log.Debug(err)
+		return loginError(loginServerError)
+	}
+	if enabled {
+		challenge, err := schema.IssueChallengeToken(user)
+		if err != nil {
+			return loginError(loginTokenIssueError)
+		}
+		res := loginError(loginSecondFactorRequired)
+		res.Challenge = challenge
+		return res
+	}
+
+	return loginSuccess(r, user, req.Device, env)
+}
+
+// loginSuccess 함수는 인증을 마친 사용자의 세션을 만들고 토큰을 발급하여 로그인 성공 응답을 만든다.
+func loginSuccess(r *http.Request, user *schema.User, device string, env *Environ) rLogin {
+	// 유예 기간 중인 탈퇴 사용자가 로그인 하면 탈퇴를 취소한다.
+	if user.IsWithdraw() {
+		if err := user.Restore(); err != nil {
+			log.Debug(err)
+			return loginError(loginServerError)
+		}
+		if user.IsWithdraw() {
+			// 로그인 도중에 유예 기간이 지났다.
 			return loginError(loginNoUserError)
 		}
+		audit(r, user, schema.AuditWithdrawRestore, schema.AuditResultSuccess, user, "")
+	}
+
+	// 로그인 한 기기를 세션으로 기록한다. 사용자는 /sessions에서 세션을 확인하고 폐기할 수 있다.
+	session, err := schema.CreateSession(user, device, r.UserAgent(), GetIP(r))
+	if err != nil {
+		log.Debug(err)
+		return loginError(loginServerError)
 	}
 
 	// jwt 토큰을 발급하여 클라이언트에게 일려준다.
-	token, err = schema.IssueToken(user)
+	token, err := schema.IssueToken(user, session.SID)
 	if err != nil {
 		return loginError(loginTokenIssueError)
 	}
 
-	return rLogin{loginOK, "success", token, isTempLogin}
+	audit(r, user, schema.AuditLoginSuccess, schema.AuditResultSuccess, user, "sid="+session.SID)
+
+	// 비밀번호 변경이 강제된 사용자는 비밀번호 변경만 가능한 토큰을 받으며
+	// 리프레시 토큰은 발급하지 않는다. 비밀번호를 바꾼 뒤 다시 로그인 해야 한다.
+	if user.MustChangePass {
+		return rLogin{Res: loginOK, Msg: "success", Token: token, TempLogin: true, ExpiresIn: env.Conf.AccessTokenExpire()}
+	}
+
+	// 액세스 토큰이 만료되면 다시 로그인 하지 않고 갱신할 수 있도록 리프레시 토큰을 발급한다.
+	refreshToken, err := schema.IssueRefreshToken(user, device, session.SID)
+	if err != nil {
+		return loginError(loginTokenIssueError)
+	}
+
+	return rLogin{
+		Res:          loginOK,
+		Msg:          "success",
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    env.Conf.AccessTokenExpire(),
+	}
 }
@@ -2,9 +2,14 @@ package handlers
 
 import (
 	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"jsproj.com/koo/server/auth/schema"
 )
 
 type qLogout struct {
+	All    bool  `json:"all"`    // true이면 모든 기기에서 로그아웃 한다.
+	Before int64 `json:"before"` // All인 경우 이 시각까지 발급된 토큰과 만들어진 세션을 폐기한다. 0이면 현재 시각.
 }
 
 type rLogout struct {
@@ -18,11 +23,60 @@ const (
 	logoutServerError = -1220
 )
 
+var logoutErrors = map[int]string{
+	defaultError: "Error occured during logout.",
+
+	logoutBadRequest: "Invalid logout request.",
+}
+
+func logoutError(res int) rLogout {
+	msg, ok := logoutErrors[res]
+	if !ok {
+		msg = logoutErrors[defaultError]
+	}
+	return rLogout{res, msg}
+}
+
 // logoutHandler 함수는 사용자를 로그아웃 처리한다.
+// 로그아웃한 세션은 폐기되어 해당 세션의 토큰은 만료 시각 전이라도 더이상 사용할 수 없다.
 func logoutHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
 	var req qLogout
 	Unmarshal(r, &req)
 
-	// TODO : JWT는 로그 아웃 시에 무엇을 해야 하나 확인 필요
+	if !req.All {
+		// 현재 요청에 사용된 토큰과 세션만 폐기한다.
+		if err := schema.RevokeToken(env.Token); err != nil {
+			log.Debug(err)
+			return logoutError(logoutServerError)
+		}
+		if err := schema.RevokeSession(env.Me.UID, env.Token.SID); err != nil {
+			log.Debug(err)
+			return logoutError(logoutServerError)
+		}
+		return rLogout{logoutOK, "success"}
+	}
+
+	// 모든 기기에서 로그아웃 한다. 미래 시각을 지정하면 새로 로그인 할 수 없게 되므로
+	// 현재 시각 이후는 허용하지 않는다.
+	// 요청의 시각은 초 단위이며 그 초에 발급된 토큰까지 폐기한다.
+	now := schema.ServerTimeMilli()
+	if req.Before < 0 {
+		return logoutError(logoutBadRequest)
+	}
+	before := (req.Before + 1) * 1000
+	if req.Before == 0 || before > now {
+		before = now
+	}
+
+	if err := schema.RevokeTokensIssuedBefore(env.Me.UID, before); err != nil {
+		log.Debug(err)
+		return logoutError(logoutServerError)
+	}
+	// 지정한 시각 이후에 로그인한 세션은 남겨 둔다.
+	if err := schema.RevokeSessionsCreatedBefore(env.Me.UID, before); err != nil {
+		log.Debug(err)
+		return logoutError(logoutServerError)
+	}
+
 	return rLogout{logoutOK, "success"}
 }
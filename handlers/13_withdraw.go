@@ -2,34 +2,73 @@ package handlers
 
 import (
 	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"jsproj.com/koo/server/auth/schema"
 )
 
 // Withdraw request
 type qWithdraw struct {
+	Password string `json:"password"` // 현재 비밀번호
 }
 
 // Withdraw response
 type rWithdraw struct {
-	Res int    `json:"res"`
-	Msg string `json:"msg"`
+	Res     int    `json:"res"`
+	Msg     string `json:"msg"`
+	PurgeAt int64  `json:"purgeat,omitempty"` // 이 시각 이후에 계정과 데이터가 영구 삭제된다.
 }
 
 const (
-	withdrawOK          = 0
-	withdrawBadRequest  = -1310
-	withdrawServerError = -1320
+	withdrawOK                 = 0
+	withdrawBadRequest         = -1310
+	withdrawServerError        = -1320
+	withdrawBadPasswordRequest = -1330
 )
 
+var withdrawErrors = map[int]string{
+	defaultError: "Error occured during withdraw.",
+
+	withdrawBadRequest:         "Invalid withdraw request.",
+	withdrawBadPasswordRequest: "Incorrect password.",
+}
+
+func withdrawError(res int) rWithdraw {
+	msg, ok := withdrawErrors[res]
+	if !ok {
+		msg = withdrawErrors[defaultError]
+	}
+	return rWithdraw{Res: res, Msg: msg}
+}
+
 // withdrawHandler 함수는 사용자를 탈퇴 시킨다.
+// 탈퇴한 사용자는 유예 기간 동안 다시 로그인하여 탈퇴를 취소할 수 있으며, 유예 기간이 지나면
+// 사용자와 사용자의 데이터는 영구 삭제된다.
 func withdrawHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
 	var req qWithdraw
 	Unmarshal(r, &req)
 
-	// 현재는 바로 DB에서 해당 사용자를 삭제한다.
-	_, err := env.DB.Auth.Delete(env.Me)
+	if req.Password == "" {
+		return withdrawError(withdrawBadRequest)
+	}
+	ok, _, err := schema.VerifyPassword(env.Me.Password, req.Password)
 	if err != nil {
-		return rWithdraw{withdrawServerError, "database delete failed."}
+		log.Debug(err)
+	}
+	if !ok {
+		audit(r, env.Me, schema.AuditWithdraw, schema.AuditResultFailure, env.Me, "incorrect password")
+		return withdrawError(withdrawBadPasswordRequest)
 	}
 
-	return rWithdraw{withdrawOK, "success"}
+	if err := env.Me.Withdraw(); err != nil {
+		log.Debug(err)
+		return withdrawError(withdrawServerError)
+	}
+	audit(r, env.Me, schema.AuditWithdraw, schema.AuditResultSuccess, env.Me, "")
+
+	return rWithdraw{
+		Res:     withdrawOK,
+		Msg:     "success",
+		PurgeAt: env.Me.Withdrawn + env.Conf.WithdrawGrace(),
+	}
 }
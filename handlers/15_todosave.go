@@ -55,7 +55,7 @@ func todoSaveHandler(w http.ResponseWriter, r *http.Request, env *Environ) inter
 		Status:    schema.TodoStatusNormal}
 
 	if isNew := todo.TID == 0; isNew == true {
-		if err := env.DB.Auth.Insert(&todo); err != nil {
+		if err := env.DB.Todos.InsertTodo(&todo); err != nil {
 			log.Debug(err)
 			return todoSaveError(todoSaveDatabaseError)
 		}
@@ -72,7 +72,7 @@ func todoSaveHandler(w http.ResponseWriter, r *http.Request, env *Environ) inter
 			return todoSaveError(todoSaveNoPermission)
 		}
 
-		if _, err := env.DB.Auth.Update(&todo); err != nil {
+		if err := env.DB.Todos.UpdateTodo(&todo); err != nil {
 			log.Debug(err)
 			return todoSaveError(todoSaveDatabaseError)
 		}
@@ -2,9 +2,10 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
-	"jsproj.com/koo/gosari/crypto"
 	"jsproj.com/koo/server/auth/schema"
 )
 
@@ -13,8 +14,9 @@ type qFindPass struct {
 }
 
 type rFindPass struct {
-	Res int    `json:"res"`
-	Msg string `json:"msg"`
+	Res        int    `json:"res"`
+	Msg        string `json:"msg"`
+	RetryAfter int64  `json:"retryafter,omitempty"` // findPassThrottled인 경우 다시 시도할 수 있을 때까지 남은 시간(초)
 }
 
 const (
@@ -22,6 +24,7 @@ const (
 	findPassBadIDRequest = -1710
 	findPassNoUserError  = -1720
 	findPassServerError  = -1730
+	findPassThrottled    = -1740 // 재설정 요청이 많아 잠시 잠김
 )
 
 var findPassErrors = map[int]string{
@@ -29,6 +32,7 @@ var findPassErrors = map[int]string{
 
 	findPassBadIDRequest: "Invalid email format.",
 	findPassNoUserError:  "Incorrect ID.",
+	findPassThrottled:    "Too many requests. Please try again later.",
 }
 
 func findPassError(res int) rFindPass {
@@ -36,16 +40,29 @@ func findPassError(res int) rFindPass {
 	if !ok {
 		msg = findPassErrors[defaultError]
 	}
-	return rFindPass{res, msg}
+	return rFindPass{Res: res, Msg: msg}
 }
 
-// findPassHandler 함수는 사용자의 비밀번호를 초기화 한다.
+// findPassMailData 구조체는 비밀번호 재설정 메일 템플릿에 넘기는 값이다.
+type findPassMailData struct {
+	ID           string
+	Token        string
+	ExpireMinute int64
+}
+
+// findPassThrottleKeys 함수는 재설정 요청 횟수를 셀 아이디별, IP별 키를 만든다.
+func findPassThrottleKeys(id string, ip string) (string, string) {
+	return "findpass:id:" + strings.ToLower(id), "findpass:ip:" + ip
+}
+
+// findPassHandler 함수는 사용자에게 비밀번호 재설정 링크를 메일로 보낸다.
+// 비밀번호는 사용자가 링크를 통해 새 비밀번호를 입력할 때까지 바뀌지 않는다.
 func findPassHandler(
 	w http.ResponseWriter,
 	r *http.Request,
 	env *Environ,
 ) interface{} {
-	var req qSignup
+	var req qFindPass
 	Unmarshal(r, &req)
 
 	// 아이디 형식 검사
@@ -53,33 +70,61 @@ func findPassHandler(
 		return findPassError(findPassBadIDRequest)
 	}
 
-	// Salt를 앞쪽에 붙인 임시 비밀번호를 만든다.
-	tmpPass, _ := crypto.NewUUID()
-	tmpPass = tmpPass[0:8]
-	salt := crypto.NewSalt(schema.SaltMaxSize)
-	password := string(crypto.SecurePassword(salt, []byte(tmpPass)))
+	// 메일 폭탄을 막기 위해 아이디별, IP별로 재설정 요청 횟수를 제한한다.
+	// 존재하지 않는 아이디에 대한 요청도 횟수에 포함된다.
+	throttle := schema.Throttle()
+	idKey, ipKey := findPassThrottleKeys(req.ID, GetIP(r))
+	for _, key := range []string{idKey, ipKey} {
+		retry, err := throttle.RetryAfter(key)
+		if err != nil {
+			log.Debug(err)
+			return findPassError(findPassServerError)
+		}
+		if retry > 0 {
+			w.Header().Set("Retry-After", strconv.FormatInt(retry, 10))
+			res := findPassError(findPassThrottled)
+			res.RetryAfter = retry
+			return res
+		}
+	}
+	if _, err := throttle.Fail(idKey, env.Conf.PasswordResetThrottle()); err != nil {
+		log.Debug(err)
+	}
+	if _, err := throttle.Fail(ipKey, env.Conf.LoginIPThrottle()); err != nil {
+		log.Debug(err)
+	}
 
+	// 사용자가 없거나 정상 상태가 아니더라도 성공으로 응답한다.
+	// 보안상의 이유로 ID가 존재하는지 여부를 확인할 수 없게 하기 위해서이다.
 	user, err := schema.LoadUserFromID(req.ID)
-	if err != nil {
+	if err == schema.ErrNotFound {
+		return rFindPass{Res: findPassOK, Msg: "success"}
+	} else if err != nil {
 		log.Debug(err)
-		return findPassError(findPassNoUserError)
+		return findPassError(findPassServerError)
+	}
+	if !user.IsNormal() {
+		log.Debugf("password reset requested for abnormal user. id=%s, status=%d", user.ID, user.Status)
+		return rFindPass{Res: findPassOK, Msg: "success"}
 	}
 
-	// 사용자의 임시 비밀번호를 업데이트 한다.
-	user.PasswordTmp = password
-
-	if _, err = env.DB.Auth.Update(user); err != nil {
+	token, err := schema.CreatePasswordReset(user)
+	if err != nil {
 		// 기타 데이터베이스 에러 발생
 		log.Debug(err)
 		return findPassError(findPassServerError)
 	}
 
-	// 보안상 좋지 않지만 이메일에 비밀번호 평문을 넣어야 하므로 임시로 tmpPass를 사용함. 이 이후에 user를 DB에 쓰는 일이 없도록 해야 함
-	user.PasswordTmp = tmpPass
-	if err := schema.SendMail(user, "findpass_mail_title.tmpl", "findpass_mail.tmpl"); err != nil {
+	data := findPassMailData{
+		ID:           user.ID,
+		Token:        token,
+		ExpireMinute: env.Conf.PasswordResetExpire() / 60,
+	}
+	if err := schema.SendMailWithData(user, "findpass_mail_title.tmpl", "findpass_mail.tmpl", data); err != nil {
 		log.Debug(err)
 		return findPassError(findPassServerError)
 	}
 
-	return rFindPass{findPassOK, "success"}
+	audit(r, nil, schema.AuditPasswordResetReq, schema.AuditResultSuccess, user, "")
+	return rFindPass{Res: findPassOK, Msg: "success"}
 }
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"jsproj.com/koo/server/auth/schema"
+)
+
+type qTokenRefresh struct {
+	RefreshToken string `json:"refreshtoken"`
+}
+
+type rTokenRefresh struct {
+	Res          int    `json:"res"`
+	Msg          string `json:"msg"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshtoken"`
+	ExpiresIn    int64  `json:"expiresin"` // 액세스 토큰 유효 기간(초)
+}
+
+const (
+	tokenRefreshOK              = 0
+	tokenRefreshBadRequest      = -1810
+	tokenRefreshServerError     = -1820
+	tokenRefreshInvalidToken    = -1830 // 존재하지 않거나 만료된 리프레시 토큰
+	tokenRefreshReusedToken     = -1840 // 이미 사용된 리프레시 토큰(세션 전체가 폐기됨)
+	tokenRefreshBlockUserError  = -1850 // 사용자가 블럭 되었거나 정상 상태가 아님
+	tokenRefreshTokenIssueError = -1899
+)
+
+var tokenRefreshErrors = map[int]string{
+	defaultError: "Error occured during refresh token.",
+
+	tokenRefreshBadRequest:      "Invalid refresh token format.",
+	tokenRefreshInvalidToken:    "Refresh token is invalid or expired. Please login again.",
+	tokenRefreshReusedToken:     "Refresh token has already been used. Please login again.",
+	tokenRefreshBlockUserError:  "System has blocked your account. Please contact the support team for more information.",
+	tokenRefreshTokenIssueError: "Error occured during issue token.",
+}
+
+func tokenRefreshError(res int) rTokenRefresh {
+	msg, ok := tokenRefreshErrors[res]
+	if !ok {
+		msg = tokenRefreshErrors[defaultError]
+	}
+	return rTokenRefresh{res, msg, "", "", 0}
+}
+
+// tokenRefreshHandler 함수는 리프레시 토큰으로 새 액세스 토큰을 발급한다.
+// 사용된 리프레시 토큰은 폐기되고 새 리프레시 토큰이 함께 발급된다.
+func tokenRefreshHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	var req qTokenRefresh
+	Unmarshal(r, &req)
+
+	if req.RefreshToken == "" {
+		return tokenRefreshError(tokenRefreshBadRequest)
+	}
+
+	user, sid, refreshToken, err := schema.RotateRefreshToken(req.RefreshToken)
+	switch err {
+	case nil:
+	case schema.ErrRefreshTokenInvalid, schema.ErrRefreshTokenExpired:
+		return tokenRefreshError(tokenRefreshInvalidToken)
+	case schema.ErrRefreshTokenReused:
+		return tokenRefreshError(tokenRefreshReusedToken)
+	default:
+		log.Debug(err)
+		return tokenRefreshError(tokenRefreshServerError)
+	}
+
+	// 로그인 이후에 블럭 되었거나 탈퇴한 사용자는 토큰을 갱신할 수 없다.
+	if !user.IsNormal() {
+		if err := schema.RevokeSessionsOfUser(user.UID, ""); err != nil {
+			log.Debug(err)
+		}
+		return tokenRefreshError(tokenRefreshBlockUserError)
+	}
+
+	// 리프레시 토큰이 발급된 이후에 비밀번호 변경이 강제된 사용자는 다시 로그인 해야 한다.
+	if user.MustChangePass {
+		if err := schema.RevokeSessionsOfUser(user.UID, ""); err != nil {
+			log.Debug(err)
+		}
+		return tokenRefreshError(tokenRefreshInvalidToken)
+	}
+
+	token, err := schema.IssueToken(user, sid)
+	if err != nil {
+		return tokenRefreshError(tokenRefreshTokenIssueError)
+	}
+
+	return rTokenRefresh{tokenRefreshOK, "success", token, refreshToken, env.Conf.AccessTokenExpire()}
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"net/http"
+
+	"jsproj.com/koo/server/auth/schema"
+)
+
+type qJWKS struct {
+}
+
+// jwksHandler 함수는 토큰 검증에 사용하는 공개키 목록을 JWK Set 형식으로 반환한다.
+// 다른 talkcrew 서비스들은 이 목록과 토큰의 kid 헤더를 이용하여 직접 토큰을 검증할 수 있다.
+func jwksHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	var req qJWKS
+	Unmarshal(r, &req)
+
+	// 키가 교체되면 바로 반영될 수 있도록 짧게 캐시한다.
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	return schema.JWKS()
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"jsproj.com/koo/gosari/utils"
+	"jsproj.com/koo/server/auth/schema"
+)
+
+type qResetPass struct {
+	Password string `json:"password"`
+}
+
+type rResetPass struct {
+	Res    int         `json:"res"`
+	Msg    string      `json:"msg"`
+	Fields fieldErrors `json:"fields,omitempty"` // 항목별 오류 사유
+}
+
+const (
+	resetPassOK                 = 0
+	resetPassBadPasswordRequest = -2010
+	resetPassInvalidToken       = -2020 // 토큰이 없거나, 이미 사용되었거나, 만료됨
+	resetPassServerError        = -2030
+)
+
+var resetPassErrors = map[int]string{
+	defaultError: "Error occured during reset password.",
+
+	resetPassBadPasswordRequest: "Invalid password format.",
+	resetPassInvalidToken:       "This link has expired or has already been used.",
+}
+
+func resetPassError(res int) rResetPass {
+	msg, ok := resetPassErrors[res]
+	if !ok {
+		msg = resetPassErrors[defaultError]
+	}
+	return rResetPass{res, msg, nil}
+}
+
+// resetPassPage 구조체는 비밀번호 재설정 html 템플릿에 넘기는 값이다.
+type resetPassPage struct {
+	Token string
+}
+
+// resetPassHandler 함수는 비밀번호 재설정 메일의 링크를 처리한다.
+// GET으로 불리면 새 비밀번호를 입력하는 페이지를 보여주고, POST로 불리면 비밀번호를 바꾼다.
+// 비밀번호가 바뀌면 기존에 로그인 되어 있던 모든 세션은 로그아웃 된다.
+func resetPassHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+	env *Environ,
+) interface{} {
+	token := mux.Vars(r)["token"]
+
+	if r.Method == "GET" {
+		reqLog(r)
+		w.Header().Set("Content-Type", "text/html")
+		if _, err := schema.FindPasswordReset(token); err != nil {
+			utils.JoinTemplate(w, env.Conf.TemplatePath("resetpass_expired.tmpl"), nil)
+			return nil
+		}
+		utils.JoinTemplate(w, env.Conf.TemplatePath("resetpass_form.tmpl"), resetPassPage{token})
+		return nil
+	}
+
+	var req qResetPass
+	Unmarshal(r, &req)
+
+	// 비밀번호 정책 검사. 토큰을 사용 처리하기 전에 검사해야 다시 입력할 수 있다.
+	pr, err := schema.FindPasswordReset(token)
+	if err != nil {
+		return resetPassError(resetPassInvalidToken)
+	}
+	user, err := schema.LoadUserFromUID(pr.UID)
+	if err == schema.ErrNotFound {
+		return resetPassError(resetPassInvalidToken)
+	} else if err != nil {
+		log.Debug(err)
+		return resetPassError(resetPassServerError)
+	}
+	reasons, err := schema.ValidateNewPassword(user, req.Password)
+	if err != nil {
+		log.Debug(err)
+		return resetPassError(resetPassServerError)
+	}
+	if len(reasons) > 0 {
+		res := resetPassError(resetPassBadPasswordRequest)
+		res.Fields = fieldErrors{"password": reasons}
+		return res
+	}
+
+	// 토큰을 사용 처리하면서 비밀번호를 바꾸고 로그인 되어 있던 모든 세션을 로그아웃 시킨다.
+	err = schema.ResetPassword(pr, user, req.Password)
+	if err == schema.ErrPasswordResetInvalid {
+		return resetPassError(resetPassInvalidToken)
+	} else if err != nil {
+		log.Debug(err)
+		return resetPassError(resetPassServerError)
+	}
+
+	audit(r, nil, schema.AuditPasswordReset, schema.AuditResultSuccess, user, "")
+	return rResetPass{resetPassOK, "success", nil}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"jsproj.com/koo/server/auth/schema"
+)
+
+type qChangePass struct {
+	Password    string `json:"password"`    // 현재 비밀번호
+	NewPassword string `json:"newpassword"` // 새 비밀번호
+}
+
+type rChangePass struct {
+	Res    int         `json:"res"`
+	Msg    string      `json:"msg"`
+	Fields fieldErrors `json:"fields,omitempty"` // 항목별 오류 사유
+}
+
+const (
+	changePassOK                    = 0
+	changePassBadPasswordRequest    = -2110
+	changePassBadNewPasswordRequest = -2120
+	changePassServerError           = -2130
+	changePassSamePasswordError     = -2140 // 새 비밀번호가 현재 비밀번호와 같음
+)
+
+var changePassErrors = map[int]string{
+	defaultError: "Error occured during change password.",
+
+	changePassBadPasswordRequest:    "Incorrect password.",
+	changePassBadNewPasswordRequest: "Invalid new password format.",
+	changePassSamePasswordError:     "New password must be different from the current password.",
+}
+
+func changePassError(res int) rChangePass {
+	msg, ok := changePassErrors[res]
+	if !ok {
+		msg = changePassErrors[defaultError]
+	}
+	return rChangePass{res, msg, nil}
+}
+
+// changePassHandler 함수는 현재 비밀번호를 확인한 뒤 사용자의 비밀번호를 바꾼다.
+// 비밀번호 변경이 강제된 사용자도 부를 수 있으며, 비밀번호가 바뀌면 강제 상태가 해제된다.
+// 기존에 발급된 토큰은 모두 폐기되므로 새 비밀번호로 다시 로그인 해야 한다.
+func changePassHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	var req qChangePass
+	Unmarshal(r, &req)
+
+	ok, _, err := schema.VerifyPassword(env.Me.Password, req.Password)
+	if err != nil {
+		log.Debug(err)
+	}
+	if !ok {
+		audit(r, env.Me, schema.AuditPasswordChange, schema.AuditResultFailure, env.Me, "incorrect password")
+		return changePassError(changePassBadPasswordRequest)
+	}
+
+	if req.NewPassword == req.Password {
+		return changePassError(changePassSamePasswordError)
+	}
+
+	// 비밀번호 정책 및 재사용 검사
+	reasons, err := schema.ValidateNewPassword(env.Me, req.NewPassword)
+	if err != nil {
+		log.Debug(err)
+		return changePassError(changePassServerError)
+	}
+	if len(reasons) > 0 {
+		res := changePassError(changePassBadNewPasswordRequest)
+		res.Fields = fieldErrors{"newpassword": reasons}
+		return res
+	}
+
+	// 비밀번호를 바꾸고 다른 기기를 포함한 모든 세션을 로그아웃 시킨다.
+	if err := schema.ChangePassword(env.Me, req.NewPassword); err != nil {
+		log.Debug(err)
+		return changePassError(changePassServerError)
+	}
+
+	audit(r, env.Me, schema.AuditPasswordChange, schema.AuditResultSuccess, env.Me, "")
+	return rChangePass{changePassOK, "success", nil}
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/skip2/go-qrcode"
+	"jsproj.com/koo/server/auth/schema"
+)
+
+const (
+	twoFactorOK                 = 0
+	twoFactorBadRequest         = -2210
+	twoFactorServerError        = -2220
+	twoFactorAlreadyEnabled     = -2230 // 이미 2단계 인증을 사용 중
+	twoFactorNotEnrolled        = -2240 // 2단계 인증 등록을 시작하지 않았거나 사용하지 않음
+	twoFactorInvalidCode        = -2250 // 코드 혹은 복구 코드가 틀림
+	twoFactorBadPasswordRequest = -2260 // 비밀번호가 틀림
+	twoFactorInvalidChallenge   = -2270 // 도전 토큰이 없거나 만료됨
+	twoFactorThrottledError     = -2280 // 실패가 많아 잠시 잠김
+)
+
+var twoFactorErrors = map[int]string{
+	defaultError: "Error occured during two-factor authentication.",
+
+	twoFactorBadRequest:         "Invalid request.",
+	twoFactorAlreadyEnabled:     "Two-factor authentication is already enabled.",
+	twoFactorNotEnrolled:        "Two-factor authentication is not enabled.",
+	twoFactorInvalidCode:        "Invalid authentication code.",
+	twoFactorBadPasswordRequest: "Incorrect password.",
+	twoFactorInvalidChallenge:   "Login session has expired. Please login again.",
+	twoFactorThrottledError:     "Too many failed attempts. Please try again later.",
+}
+
+type rTwoFactor struct {
+	Res int    `json:"res"`
+	Msg string `json:"msg"`
+}
+
+func twoFactorError(res int) rTwoFactor {
+	msg, ok := twoFactorErrors[res]
+	if !ok {
+		msg = twoFactorErrors[defaultError]
+	}
+	return rTwoFactor{res, msg}
+}
+
+// twoFactorThrottleKey 함수는 2단계 인증 코드 실패 횟수를 셀 키를 만든다.
+func twoFactorThrottleKey(uid int64) string {
+	return fmt.Sprintf("2fa:uid:%d", uid)
+}
+
+// verifySecondFactor 함수는 인증 앱의 코드 혹은 복구 코드를 확인한다.
+// 실패 횟수를 세어 허용 횟수를 넘으면 잠시 잠근다. 성공하면 twoFactorOK를 리턴한다.
+func verifySecondFactor(user *schema.User, code string, recoveryCode string, env *Environ) int {
+	throttle := schema.Throttle()
+	key := twoFactorThrottleKey(user.UID)
+
+	if retry, err := throttle.RetryAfter(key); err != nil {
+		log.Debug(err)
+		return twoFactorServerError
+	} else if retry > 0 {
+		return twoFactorThrottledError
+	}
+
+	var err error
+	switch {
+	case code != "":
+		err = schema.VerifyTOTP(user, code)
+	case recoveryCode != "":
+		err = schema.UseRecoveryCode(user, recoveryCode)
+	default:
+		return twoFactorBadRequest
+	}
+
+	switch err {
+	case nil:
+		if err := throttle.Reset(key); err != nil {
+			log.Debug(err)
+		}
+		return twoFactorOK
+	case schema.ErrTOTPNotEnrolled:
+		return twoFactorNotEnrolled
+	case schema.ErrTOTPInvalidCode, schema.ErrRecoveryCodeInvalid:
+		if _, err := throttle.Fail(key, env.Conf.LoginThrottle()); err != nil {
+			log.Debug(err)
+		}
+		return twoFactorInvalidCode
+	default:
+		log.Debug(err)
+		return twoFactorServerError
+	}
+}
+
+type qTwoFactorEnroll struct {
+}
+
+type rTwoFactorEnroll struct {
+	Res    int    `json:"res"`
+	Msg    string `json:"msg"`
+	Secret string `json:"secret"` // 인증 앱에 직접 입력할 수 있는 base32 비밀키
+	URI    string `json:"uri"`    // otpauth:// URI
+	QRPNG  string `json:"qrpng"`  // URI를 담은 QR 코드 PNG 이미지(base64)
+}
+
+// twoFactorEnrollHandler 함수는 2단계 인증 등록을 시작한다. 인증 앱에 등록할 비밀키와 QR 코드를
+// 돌려주며, /2fa/confirm으로 첫 코드를 확인하기 전까지는 2단계 인증이 켜지지 않는다.
+func twoFactorEnrollHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	var req qTwoFactorEnroll
+	Unmarshal(r, &req)
+
+	t, err := schema.BeginTOTPEnrollment(env.Me)
+	if err == schema.ErrTOTPAlreadyEnabled {
+		return twoFactorError(twoFactorAlreadyEnabled)
+	} else if err != nil {
+		log.Debug(err)
+		return twoFactorError(twoFactorServerError)
+	}
+
+	uri := schema.TOTPURI(env.Me, t)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		log.Debug(err)
+		return twoFactorError(twoFactorServerError)
+	}
+
+	return rTwoFactorEnroll{twoFactorOK, "success", t.Secret, uri, base64.StdEncoding.EncodeToString(png)}
+}
+
+type qTwoFactorConfirm struct {
+	Code string `json:"code"`
+}
+
+type rTwoFactorConfirm struct {
+	Res           int      `json:"res"`
+	Msg           string   `json:"msg"`
+	RecoveryCodes []string `json:"recoverycodes"` // 한 번만 보여줄 수 있는 복구 코드 목록
+}
+
+// twoFactorConfirmHandler 함수는 인증 앱의 첫 코드를 확인하여 2단계 인증을 켜고 복구 코드를 발급한다.
+// 탈취된 세션으로 코드를 대입하지 못하도록 verifySecondFactor와 같은 키로 실패 횟수를 센다.
+func twoFactorConfirmHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	var req qTwoFactorConfirm
+	Unmarshal(r, &req)
+
+	if req.Code == "" {
+		return twoFactorError(twoFactorBadRequest)
+	}
+
+	throttle := schema.Throttle()
+	key := twoFactorThrottleKey(env.Me.UID)
+	if retry, err := throttle.RetryAfter(key); err != nil {
+		log.Debug(err)
+		return twoFactorError(twoFactorServerError)
+	} else if retry > 0 {
+		return twoFactorError(twoFactorThrottledError)
+	}
+
+	codes, err := schema.ConfirmTOTPEnrollment(env.Me, req.Code)
+	switch err {
+	case nil:
+		if err := throttle.Reset(key); err != nil {
+			log.Debug(err)
+		}
+	case schema.ErrTOTPNotEnrolled:
+		return twoFactorError(twoFactorNotEnrolled)
+	case schema.ErrTOTPAlreadyEnabled:
+		return twoFactorError(twoFactorAlreadyEnabled)
+	case schema.ErrTOTPInvalidCode:
+		if _, err := throttle.Fail(key, env.Conf.LoginThrottle()); err != nil {
+			log.Debug(err)
+		}
+		return twoFactorError(twoFactorInvalidCode)
+	default:
+		log.Debug(err)
+		return twoFactorError(twoFactorServerError)
+	}
+
+	log.WithFields(log.Fields{"id": env.Me.ID}).Info("2FA_ENABLED")
+	return rTwoFactorConfirm{twoFactorOK, "success", codes}
+}
+
+type qTwoFactorDisable struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoverycode"`
+}
+
+// twoFactorDisableHandler 함수는 비밀번호와 2단계 인증 코드(혹은 복구 코드)를 확인한 뒤 2단계 인증을 끈다.
+func twoFactorDisableHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	var req qTwoFactorDisable
+	Unmarshal(r, &req)
+
+	ok, _, err := schema.VerifyPassword(env.Me.Password, req.Password)
+	if err != nil {
+		log.Debug(err)
+	}
+	if !ok {
+		return twoFactorError(twoFactorBadPasswordRequest)
+	}
+
+	if res := verifySecondFactor(env.Me, req.Code, req.RecoveryCode, env); res != twoFactorOK {
+		return twoFactorError(res)
+	}
+
+	if err := schema.DisableTOTP(env.Me.UID); err != nil {
+		log.Debug(err)
+		return twoFactorError(twoFactorServerError)
+	}
+
+	log.WithFields(log.Fields{"id": env.Me.ID}).Info("2FA_DISABLED")
+	return rTwoFactor{twoFactorOK, "success"}
+}
+
+type qLoginSecondFactor struct {
+	Challenge    string `json:"challenge"`    // /login에서 받은 도전 토큰
+	Code         string `json:"code"`         // 인증 앱의 코드
+	RecoveryCode string `json:"recoverycode"` // 인증 앱 대신 사용할 복구 코드
+	Device       string `json:"device"`       // 리프레시 토큰을 발급할 기기 이름
+}
+
+// loginSecondFactorHandler 함수는 /login에서 받은 도전 토큰과 2단계 인증 코드를 확인하여 로그인을
+// 마친다. 성공하면 /login과 같은 형식으로 토큰을 돌려준다.
+func loginSecondFactorHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	var req qLoginSecondFactor
+	Unmarshal(r, &req)
+
+	claims, err := schema.ParseTokenString(req.Challenge)
+	if err != nil || claims.Scope != schema.ScopeSecondFactor {
+		log.Debug(err)
+		return twoFactorError(twoFactorInvalidChallenge)
+	}
+
+	user, err := schema.LoadUserFromUID(claims.UID)
+	if err == schema.ErrNotFound {
+		return twoFactorError(twoFactorInvalidChallenge)
+	} else if err != nil {
+		log.Debug(err)
+		return twoFactorError(twoFactorServerError)
+	}
+	if !user.IsNormal() && !user.IsRestorable() {
+		return loginError(loginBlockUserError)
+	}
+
+	if res := verifySecondFactor(user, req.Code, req.RecoveryCode, env); res != twoFactorOK {
+		// 잠겨 있는 동안의 요청은 감사 기록을 남기지 않는다.
+		if res != twoFactorThrottledError {
+			audit(r, nil, schema.AuditLoginFailure, schema.AuditResultFailure, user, "2fa res="+strconv.Itoa(res))
+		}
+		return twoFactorError(res)
+	}
+
+	// 도전 토큰은 한 번만 사용할 수 있다.
+	if err := schema.RevokeToken(claims); err != nil {
+		log.Debug(err)
+		return twoFactorError(twoFactorServerError)
+	}
+
+	return loginSuccess(r, user, req.Device, env)
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"jsproj.com/koo/server/auth/schema"
+)
+
+type qSessionList struct {
+}
+
+type qSessionRevoke struct {
+	SID string `json:"sid"`
+}
+
+type sessionItem struct {
+	*schema.Session
+	Current bool `json:"current"` // 현재 요청에 사용된 세션이면 true
+}
+
+type rSessionList struct {
+	Res      int            `json:"res"`
+	Msg      string         `json:"msg"`
+	Sessions []*sessionItem `json:"sessions"`
+}
+
+type rSessionRevoke struct {
+	Res int    `json:"res"`
+	Msg string `json:"msg"`
+}
+
+const (
+	sessionOK           = 0
+	sessionBadRequest   = -2310
+	sessionServerError  = -2320
+	sessionNotFound     = -2330 // 세션이 없거나 이미 폐기됨
+	sessionCurrentError = -2340 // 현재 세션은 /logout으로 폐기해야 함
+)
+
+var sessionErrors = map[int]string{
+	defaultError: "Error occured during session request.",
+
+	sessionBadRequest:   "Invalid session request.",
+	sessionNotFound:     "Session not found or already revoked.",
+	sessionCurrentError: "Use logout to end the current session.",
+}
+
+func sessionError(res int) rSessionRevoke {
+	msg, ok := sessionErrors[res]
+	if !ok {
+		msg = sessionErrors[defaultError]
+	}
+	return rSessionRevoke{res, msg}
+}
+
+// sessionListHandler 함수는 로그인 되어 있는 사용자의 세션(기기) 목록을 반환한다.
+func sessionListHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	var req qSessionList
+	Unmarshal(r, &req)
+
+	sl, err := schema.LoadSessionList(env.Me.UID)
+	if err != nil {
+		log.Debug(err)
+		e := sessionError(sessionServerError)
+		return rSessionList{e.Res, e.Msg, nil}
+	}
+
+	items := make([]*sessionItem, 0, len(sl))
+	for _, s := range sl {
+		items = append(items, &sessionItem{s, s.SID == env.Token.SID})
+	}
+	return rSessionList{sessionOK, "success", items}
+}
+
+// sessionRevokeHandler 함수는 사용자의 다른 세션 하나를 폐기한다.
+// 해당 세션의 액세스 토큰과 리프레시 토큰은 더이상 사용할 수 없다.
+func sessionRevokeHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	var req qSessionRevoke
+	Unmarshal(r, &req)
+
+	if req.SID == "" || len(req.SID) > schema.SessionIDMaxSize {
+		return sessionError(sessionBadRequest)
+	}
+	if req.SID == env.Token.SID {
+		return sessionError(sessionCurrentError)
+	}
+
+	switch err := schema.RevokeSession(env.Me.UID, req.SID); err {
+	case nil:
+	case schema.ErrSessionRevoked:
+		return sessionError(sessionNotFound)
+	default:
+		log.Debug(err)
+		return sessionError(sessionServerError)
+	}
+
+	return rSessionRevoke{sessionOK, "success"}
+}
+
+// sessionRevokeOthersHandler 함수는 현재 세션을 제외한 사용자의 모든 세션을 폐기한다.
+func sessionRevokeOthersHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	var req qSessionList
+	Unmarshal(r, &req)
+
+	if err := schema.RevokeSessionsOfUser(env.Me.UID, env.Token.SID); err != nil {
+		log.Debug(err)
+		return sessionError(sessionServerError)
+	}
+
+	return rSessionRevoke{sessionOK, "success"}
+}
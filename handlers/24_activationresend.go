@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"jsproj.com/koo/server/auth/schema"
+)
+
+type qActivationResend struct {
+	ID string `json:"id"`
+}
+
+type rActivationResend struct {
+	Res        int    `json:"res"`
+	Msg        string `json:"msg"`
+	RetryAfter int64  `json:"retryafter,omitempty"` // activationResendThrottled인 경우 다시 시도할 수 있을 때까지 남은 시간(초)
+}
+
+const (
+	activationResendOK           = 0
+	activationResendBadIDRequest = -2410
+	activationResendServerError  = -2420
+	activationResendThrottled    = -2430 // 재발송 요청이 많아 잠시 잠김
+)
+
+var activationResendErrors = map[int]string{
+	defaultError: "Error occured during resend activation email.",
+
+	activationResendBadIDRequest: "Invalid email format.",
+	activationResendThrottled:    "Too many requests. Please try again later.",
+}
+
+func activationResendError(res int) rActivationResend {
+	msg, ok := activationResendErrors[res]
+	if !ok {
+		msg = activationResendErrors[defaultError]
+	}
+	return rActivationResend{Res: res, Msg: msg}
+}
+
+// activationResendThrottleKeys 함수는 재발송 횟수를 셀 아이디별, IP별 키를 만든다.
+func activationResendThrottleKeys(id string, ip string) (string, string) {
+	return "activation:id:" + strings.ToLower(id), "activation:ip:" + ip
+}
+
+// activationResendHandler 함수는 아직 이메일 인증을 하지 않은 사용자에게 새 인증 링크를 보낸다.
+// 이전에 보낸 링크는 더이상 사용할 수 없다.
+func activationResendHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+	env *Environ,
+) interface{} {
+	var req qActivationResend
+	Unmarshal(r, &req)
+
+	// 아이디 형식 검사
+	if !schema.IsValidIDFormat(req.ID) {
+		return activationResendError(activationResendBadIDRequest)
+	}
+
+	// 메일 폭탄을 막기 위해 아이디별, IP별로 재발송 횟수를 제한한다.
+	// 존재하지 않는 아이디에 대한 요청도 횟수에 포함된다.
+	throttle := schema.Throttle()
+	idKey, ipKey := activationResendThrottleKeys(req.ID, GetIP(r))
+	for _, key := range []string{idKey, ipKey} {
+		retry, err := throttle.RetryAfter(key)
+		if err != nil {
+			log.Debug(err)
+			return activationResendError(activationResendServerError)
+		}
+		if retry > 0 {
+			w.Header().Set("Retry-After", strconv.FormatInt(retry, 10))
+			res := activationResendError(activationResendThrottled)
+			res.RetryAfter = retry
+			return res
+		}
+	}
+	if _, err := throttle.Fail(idKey, env.Conf.ActivationResendThrottle()); err != nil {
+		log.Debug(err)
+	}
+	if _, err := throttle.Fail(ipKey, env.Conf.LoginIPThrottle()); err != nil {
+		log.Debug(err)
+	}
+
+	// 사용자가 없거나 이미 인증을 마쳤더라도 성공으로 응답한다.
+	// 보안상의 이유로 ID가 존재하는지 여부를 확인할 수 없게 하기 위해서이다.
+	user, err := schema.LoadUserFromID(req.ID)
+	if err == schema.ErrNotFound {
+		return rActivationResend{Res: activationResendOK, Msg: "success"}
+	} else if err != nil {
+		log.Debug(err)
+		return activationResendError(activationResendServerError)
+	}
+	if !env.Conf.IsUseActivation() || !user.IsDeactivated() {
+		log.Debugf("activation resend requested for activated user. id=%s, status=%d", user.ID, user.Status)
+		return rActivationResend{Res: activationResendOK, Msg: "success"}
+	}
+
+	if err := user.SetActivationKey(); err != nil {
+		log.Debug(err)
+		return activationResendError(activationResendServerError)
+	}
+	if err := env.DB.Users.UpdateUser(user); err != nil {
+		log.Debug(err)
+		return activationResendError(activationResendServerError)
+	}
+
+	if err := sendActivationMail(user, env); err != nil {
+		log.Debug(err)
+		return activationResendError(activationResendServerError)
+	}
+
+	return rActivationResend{Res: activationResendOK, Msg: "success"}
+}
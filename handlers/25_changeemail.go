@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"net/http"
+	"net/mail"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"jsproj.com/koo/gosari/utils"
+	"jsproj.com/koo/server/auth/schema"
+)
+
+type qChangeEmail struct {
+	Password string `json:"password"` // 현재 비밀번호
+	NewID    string `json:"newid"`    // 새 이메일 주소
+}
+
+type rChangeEmail struct {
+	Res int    `json:"res"`
+	Msg string `json:"msg"`
+}
+
+const (
+	changeEmailOK                 = 0
+	changeEmailBadPasswordRequest = -2510
+	changeEmailBadIDRequest       = -2520
+	changeEmailServerError        = -2530
+	changeEmailSameIDError        = -2540 // 새 이메일이 현재 이메일과 같음
+	changeEmailIDDuplicated       = -2550 // 새 이메일을 다른 사용자가 사용 중
+)
+
+var changeEmailErrors = map[int]string{
+	defaultError: "Error occured during change email.",
+
+	changeEmailBadPasswordRequest: "Incorrect password.",
+	changeEmailBadIDRequest:       "Invalid email format.",
+	changeEmailSameIDError:        "New email must be different from the current email.",
+	changeEmailIDDuplicated:       "Email already exists.",
+}
+
+func changeEmailError(res int) rChangeEmail {
+	msg, ok := changeEmailErrors[res]
+	if !ok {
+		msg = changeEmailErrors[defaultError]
+	}
+	return rChangeEmail{res, msg}
+}
+
+// changeEmailMailData 구조체는 이메일 변경 메일 템플릿에 넘기는 값이다.
+type changeEmailMailData struct {
+	ID           string // 현재 이메일
+	NewID        string // 새 이메일
+	Token        string // 확인 토큰(새 이메일로 보내는 메일에만 사용)
+	ExpireMinute int64
+}
+
+// changeEmailHandler 함수는 현재 비밀번호를 확인한 뒤 새 이메일 주소로 변경 확인 링크를 보낸다.
+// 이전 주소로는 변경 요청이 있었다는 안내 메일을 보낸다. 사용자의 ID는 새 주소에서 링크를 확인한
+// 뒤에만 바뀐다.
+func changeEmailHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	var req qChangeEmail
+	Unmarshal(r, &req)
+
+	ok, _, err := schema.VerifyPassword(env.Me.Password, req.Password)
+	if err != nil {
+		log.Debug(err)
+	}
+	if !ok {
+		return changeEmailError(changeEmailBadPasswordRequest)
+	}
+
+	// 아이디 형식 검사
+	if !schema.IsValidIDFormat(req.NewID) {
+		return changeEmailError(changeEmailBadIDRequest)
+	}
+	if strings.EqualFold(req.NewID, env.Me.ID) {
+		return changeEmailError(changeEmailSameIDError)
+	}
+
+	// 이미 사용 중인 이메일이면 미리 알려준다. 확인 전에 다른 사용자가 가입할 수도 있으므로
+	// 확인 시에 한 번 더 검사한다.
+	if _, err := schema.LoadUserFromID(req.NewID); err == nil {
+		return changeEmailError(changeEmailIDDuplicated)
+	} else if err != schema.ErrNotFound {
+		log.Debug(err)
+		return changeEmailError(changeEmailServerError)
+	}
+
+	token, err := schema.CreateEmailChange(env.Me, req.NewID)
+	if err != nil {
+		log.Debug(err)
+		return changeEmailError(changeEmailServerError)
+	}
+
+	data := changeEmailMailData{
+		ID:           env.Me.ID,
+		NewID:        req.NewID,
+		Token:        token,
+		ExpireMinute: env.Conf.EmailChangeExpire() / 60,
+	}
+	to := mail.Address{Name: strings.Split(req.NewID, "@")[0], Address: req.NewID}
+	if err := schema.SendMailTo(to, "changeemail_mail_title.tmpl", "changeemail_mail.tmpl", data); err != nil {
+		log.Debug(err)
+		return changeEmailError(changeEmailServerError)
+	}
+
+	// 계정을 탈취한 사람이 이메일을 바꾸려는 경우 원래 사용자가 알 수 있도록 이전 주소로 안내한다.
+	data.Token = ""
+	if err := schema.SendMailWithData(env.Me, "changeemail_notice_title.tmpl", "changeemail_notice.tmpl", data); err != nil {
+		log.Debug(err)
+	}
+
+	return rChangeEmail{changeEmailOK, "success"}
+}
+
+// confirmEmailPage 구조체는 이메일 변경 확인 html 템플릿에 넘기는 값이다.
+type confirmEmailPage struct {
+	NewID string
+}
+
+// confirmEmailHandler 함수는 새 이메일 주소로 보낸 확인 링크를 처리하여 사용자의 ID를 바꾼다.
+// 따라서 로그인 전에 토큰 발급 없이 불릴 수 있어야 한다. 메일 보안 검사 등이 링크를 미리 열어도
+// 바뀌지 않도록 GET으로 불리면 확인 페이지만 보여주고, 그 페이지에서 POST로 불려야 ID를 바꾼다.
+func confirmEmailHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	reqLog(r)
+	token := mux.Vars(r)["token"]
+	w.Header().Set("Content-Type", "text/html")
+
+	ec, err := schema.FindEmailChange(token)
+	if err != nil {
+		log.Debug(err)
+		utils.JoinTemplate(w, env.Conf.TemplatePath("changeemail_expired.tmpl"), nil)
+		return nil
+	}
+	if r.Method == "GET" {
+		utils.JoinTemplate(w, env.Conf.TemplatePath("changeemail_form.tmpl"), confirmEmailPage{ec.NewID})
+		return nil
+	}
+
+	user, oldID, err := schema.ConfirmEmailChange(ec)
+	switch err {
+	case nil:
+	case schema.ErrEmailChangeInvalid, schema.ErrEmailDuplicated:
+		log.Debug(err)
+		utils.JoinTemplate(w, env.Conf.TemplatePath("changeemail_expired.tmpl"), nil)
+		return nil
+	default:
+		log.Debug(err)
+		rAction{actionInternalServerError, "email change failed."}.mustSend(r, w)
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"uid":   user.UID,
+		"oldid": oldID,
+		"newid": user.ID,
+	}).Info("EMAIL_CHANGED")
+	audit(r, nil, schema.AuditEmailChange, schema.AuditResultSuccess, user, "old="+oldID)
+
+	utils.JoinTemplate(w, env.Conf.TemplatePath("changeemail_ok.tmpl"), user)
+	return nil
+}
@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"jsproj.com/koo/server/auth/schema"
+)
+
+type qAdminUserList struct {
+	Query  string `json:"query"`  // 아이디(이메일)에 포함된 문자열
+	Status *int   `json:"status"` // 사용자 상태. 없으면 모든 상태
+	Type   *int   `json:"type"`   // 사용자 종류. 없으면 모든 종류
+	Page   int    `json:"page"`   // 1부터 시작하는 페이지 번호
+	Size   int    `json:"size"`   // 한 페이지의 사용자 수
+}
+
+type qAdminUser struct {
+	UID    int64  `json:"uid"`
+	Reason string `json:"reason"` // 블럭 사유(블럭할 때만 사용)
+	Role   string `json:"role"`   // 역할 이름(역할을 부여하거나 회수할 때만 사용)
+}
+
+type rAdminUserList struct {
+	Res   int            `json:"res"`
+	Msg   string         `json:"msg"`
+	Users []*schema.User `json:"users"`
+	Total int64          `json:"total"` // 조건에 맞는 전체 사용자 수
+	Page  int            `json:"page"`
+}
+
+type rAdminUser struct {
+	Res   int          `json:"res"`
+	Msg   string       `json:"msg"`
+	User  *schema.User `json:"user,omitempty"`
+	Roles []string     `json:"roles,omitempty"` // 사용자에게 부여된 역할
+}
+
+const (
+	adminUserOK             = 0
+	adminUserBadRequest     = -2610
+	adminUserServerError    = -2620
+	adminUserNotFound       = -2630
+	adminUserSelfError      = -2640 // 운영자가 자기 자신을 블럭하거나 역할을 회수할 수 없음
+	adminUserBadStatusError = -2650 // 요청을 처리할 수 없는 사용자 상태
+	adminUserMailError      = -2660
+	adminUserRoleNotFound   = -2670
+	adminUserPrivilegeError = -2680 // 운영자보다 권한이 많은 사용자나 운영자가 가지지 않은 권한의 역할
+)
+
+var adminUserErrors = map[int]string{
+	defaultError: "Error occured during user management.",
+
+	adminUserBadRequest:     "Invalid user management request.",
+	adminUserNotFound:       "User not found.",
+	adminUserSelfError:      "Can not change your own account.",
+	adminUserBadStatusError: "Invalid user status for this request.",
+	adminUserMailError:      "Error occured during send mail.",
+	adminUserRoleNotFound:   "Role not found.",
+	adminUserPrivilegeError: "Not enough privilege for this request.",
+}
+
+func adminUserError(res int) rAdminUser {
+	msg, ok := adminUserErrors[res]
+	if !ok {
+		msg = adminUserErrors[defaultError]
+	}
+	return rAdminUser{Res: res, Msg: msg}
+}
+
+// loadAdminTarget 함수는 요청의 uid로 대상 사용자를 읽는다. 실패하면 보낼 응답을 리턴한다.
+// 권한 확인은 라우트에 지정된 권한으로 processAction에서 처리된다.
+func loadAdminTarget(r *http.Request, env *Environ) (*schema.User, *qAdminUser, interface{}) {
+	var req qAdminUser
+	Unmarshal(r, &req)
+
+	if req.UID <= 0 {
+		return nil, nil, adminUserError(adminUserBadRequest)
+	}
+	user, err := schema.LoadUserFromUID(req.UID)
+	if err == schema.ErrNotFound {
+		return nil, nil, adminUserError(adminUserNotFound)
+	} else if err != nil {
+		log.Debug(err)
+		return nil, nil, adminUserError(adminUserServerError)
+	}
+	return user, &req, nil
+}
+
+// loadManagedTarget 함수는 loadAdminTarget과 같지만 운영자가 대상 사용자를 관리할 수 있는지도 확인한다.
+// 사용자의 상태나 역할을 바꾸는 요청에 사용한다.
+func loadManagedTarget(r *http.Request, env *Environ) (*schema.User, *qAdminUser, interface{}) {
+	user, req, res := loadAdminTarget(r, env)
+	if res != nil {
+		return nil, nil, res
+	}
+	ok, err := env.Me.CanManage(user)
+	if err != nil {
+		log.Debug(err)
+		return nil, nil, adminUserError(adminUserServerError)
+	}
+	if !ok {
+		return nil, nil, adminUserError(adminUserPrivilegeError)
+	}
+	return user, req, nil
+}
+
+// adminLog 함수는 운영자의 사용자 관리 작업을 로그와 감사 기록으로 남긴다.
+func adminLog(r *http.Request, env *Environ, user *schema.User, event string, detail string) {
+	log.WithFields(log.Fields{
+		"id":     user.ID,
+		"admin":  env.Me.ID,
+		"detail": detail,
+	}).Warn(event)
+	audit(r, env.Me, event, schema.AuditResultSuccess, user, detail)
+}
+
+// adminUserListHandler 함수는 조건에 맞는 사용자 목록을 페이지 단위로 반환한다.
+// schema.PermUserRead 권한이 필요하다.
+func adminUserListHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	var req qAdminUserList
+	Unmarshal(r, &req)
+
+	if req.Page < 1 {
+		req.Page = 1
+	}
+	filter := schema.UserFilter{Query: req.Query, Status: req.Status, Type: req.Type}
+	users, total, err := schema.SearchUsers(filter, req.Page, req.Size)
+	if err != nil {
+		log.Debug(err)
+		res := adminUserError(adminUserServerError)
+		return rAdminUserList{Res: res.Res, Msg: res.Msg}
+	}
+
+	return rAdminUserList{adminUserOK, "success", users, total, req.Page}
+}
+
+// adminUserHandler 함수는 사용자 한 명의 정보와 역할을 반환한다. schema.PermUserRead 권한이 필요하다.
+func adminUserHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	user, _, res := loadAdminTarget(r, env)
+	if res != nil {
+		return res
+	}
+	return adminRoleResponse(user)
+}
+
+// adminBlockUserHandler 함수는 사용자를 블럭하고 사유를 저장한다. schema.PermUserBlock 권한이 필요하다.
+func adminBlockUserHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	user, req, res := loadManagedTarget(r, env)
+	if res != nil {
+		return res
+	}
+	if user.UID == env.Me.UID {
+		return adminUserError(adminUserSelfError)
+	}
+	if req.Reason == "" {
+		return adminUserError(adminUserBadRequest)
+	}
+	if !user.IsNormal() && !user.IsBlocked() {
+		return adminUserError(adminUserBadStatusError)
+	}
+
+	if err := user.Block(req.Reason); err != nil {
+		log.Debug(err)
+		return adminUserError(adminUserServerError)
+	}
+	// 블럭된 사용자는 다시 로그인 할 수 없으므로 모든 세션을 정리한다.
+	if err := schema.RevokeSessionsOfUser(user.UID, ""); err != nil {
+		log.Debug(err)
+	}
+	adminLog(r, env, user, schema.AuditBlock, user.BlockReason)
+
+	return rAdminUser{Res: adminUserOK, Msg: "success", User: user}
+}
+
+// adminUnblockUserHandler 함수는 블럭된 사용자를 정상 사용자로 되돌린다.
+// schema.PermUserUnblock 권한이 필요하다.
+func adminUnblockUserHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	user, _, res := loadManagedTarget(r, env)
+	if res != nil {
+		return res
+	}
+	if !user.IsBlocked() {
+		return adminUserError(adminUserBadStatusError)
+	}
+
+	if err := user.UnBlock(); err != nil {
+		log.Debug(err)
+		return adminUserError(adminUserServerError)
+	}
+	adminLog(r, env, user, schema.AuditUnblock, "")
+
+	return rAdminUser{Res: adminUserOK, Msg: "success", User: user}
+}
+
+// adminRoleResponse 함수는 사용자의 정보와 역할 목록으로 응답을 만든다.
+func adminRoleResponse(user *schema.User) interface{} {
+	roles, err := schema.LoadUserRoles(user)
+	if err != nil {
+		log.Debug(err)
+		return adminUserError(adminUserServerError)
+	}
+	return rAdminUser{adminUserOK, "success", user, roles}
+}
+
+// checkRolePrivilege 함수는 운영자가 role 역할을 부여하거나 회수할 수 있는지 확인한다.
+// 할 수 없으면 보낼 응답을 리턴한다.
+func checkRolePrivilege(env *Environ, role string) interface{} {
+	ok, err := env.Me.CanGrantRole(role)
+	switch {
+	case err == schema.ErrRoleNotFound:
+		return adminUserError(adminUserRoleNotFound)
+	case err != nil:
+		log.Debug(err)
+		return adminUserError(adminUserServerError)
+	case !ok:
+		return adminUserError(adminUserPrivilegeError)
+	}
+	return nil
+}
+
+// grantRole 함수는 사용자에게 역할을 부여한다. 운영자가 가지지 않은 권한의 역할은 부여할 수 없다.
+func grantRole(r *http.Request, env *Environ, user *schema.User, role string) interface{} {
+	if res := checkRolePrivilege(env, role); res != nil {
+		return res
+	}
+	switch err := schema.GrantRole(user.UID, role); err {
+	case nil:
+	case schema.ErrRoleNotFound:
+		return adminUserError(adminUserRoleNotFound)
+	default:
+		log.Debug(err)
+		return adminUserError(adminUserServerError)
+	}
+	adminLog(r, env, user, schema.AuditRoleGrant, role)
+	return adminRoleResponse(user)
+}
+
+// revokeRole 함수는 사용자의 역할을 회수한다. 자기 자신의 역할이나 운영자가 가지지 않은 권한의 역할은
+// 회수할 수 없다. RoleAdmin을 회수하면 이전 방식의 UserTypeAdmin도 함께 해제된다.
+func revokeRole(r *http.Request, env *Environ, user *schema.User, role string) interface{} {
+	if user.UID == env.Me.UID {
+		return adminUserError(adminUserSelfError)
+	}
+	if res := checkRolePrivilege(env, role); res != nil {
+		return res
+	}
+	if err := schema.RevokeRole(user.UID, role); err != nil {
+		log.Debug(err)
+		return adminUserError(adminUserServerError)
+	}
+	if role == schema.RoleAdmin && user.IsAdmin() {
+		if err := user.SetType(schema.UserTypeNormal); err != nil {
+			log.Debug(err)
+			return adminUserError(adminUserServerError)
+		}
+	}
+	adminLog(r, env, user, schema.AuditRoleRevoke, role)
+	return adminRoleResponse(user)
+}
+
+// adminGrantRoleHandler 함수는 사용자에게 역할을 부여한다. schema.PermUserRole 권한이 필요하다.
+func adminGrantRoleHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	user, req, res := loadManagedTarget(r, env)
+	if res != nil {
+		return res
+	}
+	if req.Role == "" {
+		return adminUserError(adminUserBadRequest)
+	}
+	return grantRole(r, env, user, req.Role)
+}
+
+// adminRevokeRoleHandler 함수는 사용자의 역할을 회수한다. schema.PermUserRole 권한이 필요하다.
+func adminRevokeRoleHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	user, req, res := loadManagedTarget(r, env)
+	if res != nil {
+		return res
+	}
+	if req.Role == "" {
+		return adminUserError(adminUserBadRequest)
+	}
+	return revokeRole(r, env, user, req.Role)
+}
+
+// adminPromoteUserHandler 함수는 사용자에게 RoleAdmin 역할을 부여한다. schema.PermUserRole 권한이 필요하다.
+func adminPromoteUserHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	user, _, res := loadManagedTarget(r, env)
+	if res != nil {
+		return res
+	}
+	return grantRole(r, env, user, schema.RoleAdmin)
+}
+
+// adminDemoteUserHandler 함수는 사용자의 RoleAdmin 역할을 회수한다. schema.PermUserRole 권한이 필요하다.
+func adminDemoteUserHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	user, _, res := loadManagedTarget(r, env)
+	if res != nil {
+		return res
+	}
+	return revokeRole(r, env, user, schema.RoleAdmin)
+}
+
+// adminResetPassUserHandler 함수는 사용자의 비밀번호 변경을 강제하고 재설정 링크를 메일로 보낸다.
+// 사용자는 모든 기기에서 로그아웃 되며 비밀번호를 바꾸기 전까지는 /changepass만 부를 수 있다.
+// schema.PermUserResetPassword 권한이 필요하다.
+func adminResetPassUserHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	user, _, res := loadManagedTarget(r, env)
+	if res != nil {
+		return res
+	}
+	if !user.IsNormal() {
+		return adminUserError(adminUserBadStatusError)
+	}
+
+	token, err := user.ForcePasswordReset()
+	if err != nil {
+		log.Debug(err)
+		return adminUserError(adminUserServerError)
+	}
+	adminLog(r, env, user, schema.AuditForcePasswordReset, "")
+
+	data := findPassMailData{
+		ID:           user.ID,
+		Token:        token,
+		ExpireMinute: env.Conf.PasswordResetExpire() / 60,
+	}
+	if err := schema.SendMailWithData(user, "forcereset_mail_title.tmpl", "forcereset_mail.tmpl", data); err != nil {
+		log.Debug(err)
+		return adminUserError(adminUserMailError)
+	}
+
+	return rAdminUser{Res: adminUserOK, Msg: "success", User: user}
+}
+
+// adminActivateUserHandler 함수는 이메일 인증 전인 사용자를 직접 인증 처리한다.
+// schema.PermUserActivate 권한이 필요하다.
+func adminActivateUserHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	user, _, res := loadManagedTarget(r, env)
+	if res != nil {
+		return res
+	}
+	if !user.IsDeactivated() {
+		return adminUserError(adminUserBadStatusError)
+	}
+
+	if err := user.Activate(); err != nil {
+		log.Debug(err)
+		return adminUserError(adminUserServerError)
+	}
+	adminLog(r, env, user, schema.AuditAdminActivation, "")
+
+	return rAdminUser{Res: adminUserOK, Msg: "success", User: user}
+}
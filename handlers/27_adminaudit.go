@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"jsproj.com/koo/server/auth/schema"
+)
+
+type qAdminAudit struct {
+	Event     string `json:"event"` // 사건 종류. 끝이 .이면 접두어로 검색한다(예: admin.)
+	Result    string `json:"result"`
+	ActorUID  int64  `json:"actoruid"`
+	TargetUID int64  `json:"targetuid"`
+	TargetID  string `json:"targetid"`
+	IP        string `json:"ip"`
+	Since     int64  `json:"since"` // 이 시각 이후(포함)
+	Until     int64  `json:"until"` // 이 시각 이전(포함)
+	Page      int    `json:"page"`  // 1부터 시작하는 페이지 번호
+	Size      int    `json:"size"`  // 한 페이지의 기록 수
+}
+
+type rAdminAudit struct {
+	Res     int                  `json:"res"`
+	Msg     string               `json:"msg"`
+	Entries []*schema.AuditEntry `json:"entries"`
+	Total   int64                `json:"total"` // 조건에 맞는 전체 기록 수
+	Page    int                  `json:"page"`
+}
+
+const (
+	adminAuditOK          = 0
+	adminAuditBadRequest  = -2710
+	adminAuditServerError = -2720
+)
+
+var adminAuditErrors = map[int]string{
+	defaultError: "Error occured during audit query.",
+
+	adminAuditBadRequest: "Invalid audit query.",
+}
+
+func adminAuditError(res int) rAdminAudit {
+	msg, ok := adminAuditErrors[res]
+	if !ok {
+		msg = adminAuditErrors[defaultError]
+	}
+	return rAdminAudit{Res: res, Msg: msg}
+}
+
+func (q qAdminAudit) filter() schema.AuditFilter {
+	return schema.AuditFilter{
+		Event:     q.Event,
+		Result:    q.Result,
+		ActorUID:  q.ActorUID,
+		TargetUID: q.TargetUID,
+		TargetID:  q.TargetID,
+		IP:        q.IP,
+		Since:     q.Since,
+		Until:     q.Until,
+	}
+}
+
+// adminAuditHandler 함수는 조건에 맞는 감사 기록을 최근 순서로 페이지 단위로 반환한다.
+// schema.PermAuditRead 권한이 필요하다.
+func adminAuditHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	var req qAdminAudit
+	Unmarshal(r, &req)
+
+	if req.Since != 0 && req.Until != 0 && req.Since > req.Until {
+		return adminAuditError(adminAuditBadRequest)
+	}
+	if req.Page < 1 {
+		req.Page = 1
+	}
+
+	entries, total, err := schema.SearchAudit(req.filter(), req.Page, req.Size)
+	if err != nil {
+		log.Debug(err)
+		return adminAuditError(adminAuditServerError)
+	}
+
+	return rAdminAudit{adminAuditOK, "success", entries, total, req.Page}
+}
+
+// adminAuditExportHandler 함수는 조건에 맞는 감사 기록을 오래된 순서로 JSON Lines 형식으로 내보낸다.
+// 페이지 조건은 사용하지 않는다. schema.PermAuditRead 권한이 필요하다.
+func adminAuditExportHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	var req qAdminAudit
+	Unmarshal(r, &req)
+
+	if req.Since != 0 && req.Until != 0 && req.Since > req.Until {
+		return adminAuditError(adminAuditBadRequest)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", "attachment; filename=audit.jsonl")
+	n, err := schema.ExportAudit(req.filter(), w)
+	if err != nil {
+		// 이미 일부를 보냈을 수 있으므로 json 응답을 보내지 않고 로그만 남긴다.
+		log.Errorf("audit export failed. exported=%d, err=%v", n, err)
+		return nil
+	}
+	log.WithFields(log.Fields{
+		"admin": env.Me.ID,
+		"count": n,
+	}).Info("AUDIT_EXPORT")
+
+	return nil
+}
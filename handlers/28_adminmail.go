@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"jsproj.com/koo/server/auth/schema"
+)
+
+type qAdminMail struct {
+	Status *int `json:"status"` // 메일 상태(schema.OutboxStatusXXX). 없으면 모든 상태
+	Page   int  `json:"page"`   // 1부터 시작하는 페이지 번호
+	Size   int  `json:"size"`   // 한 페이지의 메일 수
+}
+
+type rAdminMail struct {
+	Res   int                  `json:"res"`
+	Msg   string               `json:"msg"`
+	Mails []*schema.OutboxMail `json:"mails"`
+	Total int64                `json:"total"` // 조건에 맞는 전체 메일 수
+	Page  int                  `json:"page"`
+	Count schema.OutboxCount   `json:"count"` // 상태별 메일 수
+}
+
+const (
+	adminMailOK          = 0
+	adminMailBadRequest  = -2810
+	adminMailServerError = -2820
+)
+
+var adminMailErrors = map[int]string{
+	defaultError: "Error occured during mail queue query.",
+
+	adminMailBadRequest: "Invalid mail status.",
+}
+
+func adminMailError(res int) rAdminMail {
+	msg, ok := adminMailErrors[res]
+	if !ok {
+		msg = adminMailErrors[defaultError]
+	}
+	return rAdminMail{Res: res, Msg: msg}
+}
+
+// adminMailHandler 함수는 메일 발송 대기열을 최근 순서로 페이지 단위로 반환한다. 보내지 못하고 기다리는
+// 메일과 dead 상태가 된 메일의 실패 사유를 확인할 수 있다. 메일 본문은 반환하지 않는다.
+// schema.PermMailRead 권한이 필요하다.
+func adminMailHandler(w http.ResponseWriter, r *http.Request, env *Environ) interface{} {
+	var req qAdminMail
+	Unmarshal(r, &req)
+
+	if req.Status != nil {
+		switch *req.Status {
+		case schema.OutboxStatusPending, schema.OutboxStatusSent, schema.OutboxStatusDead:
+		default:
+			return adminMailError(adminMailBadRequest)
+		}
+	}
+	if req.Page < 1 {
+		req.Page = 1
+	}
+
+	mails, total, err := schema.SearchOutbox(req.Status, req.Page, req.Size)
+	if err != nil {
+		log.Debug(err)
+		return adminMailError(adminMailServerError)
+	}
+	count, err := schema.CountOutbox()
+	if err != nil {
+		log.Debug(err)
+		return adminMailError(adminMailServerError)
+	}
+
+	return rAdminMail{adminMailOK, "success", mails, total, req.Page, count}
+}
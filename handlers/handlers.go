@@ -2,10 +2,12 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
-	"net"
 	"net/http"
+	"strconv"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
@@ -15,22 +17,67 @@ import (
 // Environ 구조체는 각 유저의 패킷이 왔을 때 해당 요청을 보낸 유저 구조체와 설정파일 그리고 DB를
 // 사용할 수 있도록 하는 문맥이다.
 type Environ struct {
-	Me   *schema.User      // 현재 처리 중인 사용자
-	Conf *schema.Configure // 서버 설정 파일(schema.Config() 로도 접근 가능하다)
-	DB   *schema.Databases // 데이터페이스 풀(schema.Database() 로도 접근 가능하다)
+	Me    *schema.User        // 현재 처리 중인 사용자
+	Token *schema.TokenClaims // 현재 처리 중인 요청의 토큰 정보(로그인이 필요한 함수에서만 사용된다)
+	Conf  *schema.Configure   // 서버 설정 파일(schema.Config() 로도 접근 가능하다)
+	DB    *schema.Databases   // 데이터페이스 풀(schema.Database() 로도 접근 가능하다)
 }
 
 type actionFunc func(http.ResponseWriter, *http.Request, *Environ) interface{}
 
 // GetIP 함수는 요청이 들어온 클라이언트의 IP 주소를 찾는다.
-// Nginx등으로 포워딩 된경우 X-FORWARDED-FOR 헤더를 통해 실제 클라이언트의 IP 주소를 찾고
-// 만일 해당 해더가 없는 경우에는 http.Request.RemoteAddr에서 포트번호를 떼고 IP 주소만 얻어온다.
+// Nginx등 신뢰하는 프록시로 포워딩 된 경우에만 X-Forwarded-For, Forwarded 헤더를 통해 실제 클라이언트의
+// IP 주소를 찾고, 그렇지 않은 경우에는 http.Request.RemoteAddr에서 포트번호를 떼고 IP 주소만 얻어온다.
+// 자세한 규칙은 schema.ClientIP를 참고한다. clientIP 미들웨어를 거친 요청은 미리 찾아 둔 값을 사용한다.
 func GetIP(r *http.Request) string {
-	if ipProxy := r.Header.Get("X-FORWARDED-FOR"); len(ipProxy) > 0 {
-		return ipProxy
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
 	}
-	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-	return ip
+	return schema.ClientIP(r)
+}
+
+// clientIPKey 는 요청의 context에 클라이언트 IP 주소를 저장하는 키이다.
+type clientIPKey struct{}
+
+// clientIP 함수는 요청마다 클라이언트의 IP 주소를 한 번만 찾아 context에 저장하는 미들웨어이다.
+// 같은 요청을 처리하는 동안 설정이 다시 읽히더라도 로그, 감사 기록, 접근 제한에 같은 주소가 사용된다.
+func clientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey{}, schema.ClientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// audit 함수는 요청한 사용자(actor)가 대상 사용자(target)에게 한 일을 감사 기록으로 남긴다.
+// 로그인 전의 요청이면 actor는 nil이며, 대상 사용자가 없으면 target은 nil이다.
+func audit(r *http.Request, actor *schema.User, event string, result string, target *schema.User, detail string) {
+	e := &schema.AuditEntry{
+		Event:     event,
+		Result:    result,
+		IP:        GetIP(r),
+		UserAgent: r.UserAgent(),
+		Detail:    detail,
+	}
+	if actor != nil {
+		e.ActorUID = actor.UID
+	}
+	if target != nil {
+		e.TargetUID = target.UID
+		e.TargetID = target.ID
+	}
+	schema.WriteAudit(e)
+}
+
+// auditID 함수는 audit과 같지만 사용자가 없을 수도 있는 요청(로그인 실패 등)을 아이디로 기록한다.
+func auditID(r *http.Request, event string, result string, id string, detail string) {
+	schema.WriteAudit(&schema.AuditEntry{
+		Event:     event,
+		Result:    result,
+		TargetID:  id,
+		IP:        GetIP(r),
+		UserAgent: r.UserAgent(),
+		Detail:    detail,
+	})
 }
 
 // Unmarshal 함수는 요청이 들어온 body를 이용하여 원하는 구조체로 언마샬링 한다.
@@ -61,6 +108,10 @@ func Marshal(r *http.Request, w http.ResponseWriter, m interface{}) error {
 	return nil
 }
 
+// fieldErrors 는 요청의 각 항목별 오류 사유 목록이다. 응답의 fields 항목으로 전달된다.
+// 예) {"password": ["too_short", "too_common"]}
+type fieldErrors map[string][]string
+
 type rAction struct {
 	Res int    `json:"res"`
 	Msg string `json:"msg"`
@@ -78,6 +129,7 @@ const (
 	actionOK                  = 200
 	actionBadRequest          = -400
 	actionUnauthorized        = -401
+	actionForbidden           = -403
 	actionPageNotFound        = -404
 	actionInternalServerError = -500
 )
@@ -104,28 +156,62 @@ func reqLog(r *http.Request) []byte {
 	return body
 }
 
+// actionSpec 구조체는 핸들러 함수를 등록할 때 지정하는 처리 조건이다.
+type actionSpec struct {
+	loginRequired bool     // 로그인 된 후에만 부를 수 있는 함수인지 여부
+	scopes        []string // 허용하는 토큰 scope. 비어 있으면 schema.ScopeAll만 허용한다.
+	permission    string   // 필요한 권한(schema.PermXXX). 비어 있으면 권한을 확인하지 않는다.
+	allowListOnly bool     // [server] 섹션의 whitelist에 포함된 IP에서만 부를 수 있는 함수인지 여부
+}
+
+// allowScope 함수는 해당 토큰 scope로 함수를 부를 수 있는지 여부를 리턴한다.
+func (spec actionSpec) allowScope(scope string) bool {
+	if len(spec.scopes) == 0 {
+		return scope == schema.ScopeAll
+	}
+	for _, s := range spec.scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
 func processAction(
-	isLoginRequired bool,
+	spec actionSpec,
 	f actionFunc,
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		if origin := r.Header.Get("Origin"); origin != "" {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-			w.Header().Set("Access-Control-Allow-Headers",
-				"Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+		// CORS 헤더와 사전 요청(preflight)은 cors 미들웨어에서 처리된다.
+		if r.Method == "OPTIONS" {
+			return
 		}
 
-		if r.Method == "OPTIONS" {
+		// 화이트리스트 밖에서는 로그인 여부와 관계 없이 거부한다.
+		if spec.allowListOnly && !schema.Config().IsAllowIP(GetIP(r)) {
+			log.WithFields(log.Fields{
+				"ip":  GetIP(r),
+				"url": r.URL.Path,
+			}).Warn("IP_NOT_ALLOWED")
+			reqLog(r)
+			auditID(r, schema.AuditPermissionDenied, schema.AuditResultDenied, "", "ip not allowed "+r.URL.Path)
+			rAction{actionForbidden, "ip not allowed."}.mustSend(r, w)
 			return
 		}
 
 		var err error
 		var me *schema.User
+		var claims *schema.TokenClaims
 
-		if isLoginRequired {
-			me, err = schema.LoadUserFromRequest(r)
+		if spec.loginRequired {
+			me, claims, err = schema.LoadUserFromRequest(r)
+			if schema.IsTokenExpired(err) {
+				// 클라이언트가 리프레시 토큰으로 갱신할 수 있도록 만료를 따로 알려준다.
+				reqLog(r)
+				rAction{actionUnauthorized, "token expired."}.mustSend(r, w)
+				return
+			}
 			if err != nil {
 				reqLog(r)
 				rAction{actionUnauthorized, "login required."}.mustSend(r, w)
@@ -136,12 +222,66 @@ func processAction(
 				rAction{actionUnauthorized, "blocked."}.mustSend(r, w)
 				return
 			}
+			// 토큰이 발급된 이후에 비밀번호 변경이 강제된 경우에도 제한된 토큰으로 취급한다.
+			scope := claims.Scope
+			if me.MustChangePass {
+				scope = schema.ScopeChangePassword
+			}
+			if !spec.allowScope(scope) {
+				reqLog(r)
+				if scope == schema.ScopeChangePassword {
+					rAction{actionForbidden, "password change required."}.mustSend(r, w)
+				} else {
+					rAction{actionForbidden, "insufficient token scope."}.mustSend(r, w)
+				}
+				return
+			}
+			// 로그아웃 등으로 세션이 폐기된 토큰은 만료 전이라도 받아들이지 않는다.
+			session, err := schema.LoadActiveSession(me.UID, claims.SID)
+			if err == schema.ErrSessionRevoked {
+				reqLog(r)
+				rAction{actionUnauthorized, "session revoked."}.mustSend(r, w)
+				return
+			} else if err != nil {
+				// 데이터베이스 오류를 폐기로 알리면 클라이언트가 유효한 토큰을 버리게 된다.
+				log.Debug(err)
+				reqLog(r)
+				rAction{actionInternalServerError, "session check failed."}.mustSend(r, w)
+				return
+			}
+			if err := schema.TouchSession(session); err != nil {
+				log.WithFields(log.Fields{"sid": session.SID, "err": err}).Warn("SESSION_TOUCH_FAILED")
+			}
+			// 운영 api는 역할에 부여된 권한을 확인한다. 권한이 없는 요청은 기록만 하고 거부한다.
+			if spec.permission != "" {
+				ok, err := me.HasPermission(spec.permission)
+				if err != nil {
+					log.Debug(err)
+					reqLog(r)
+					rAction{actionInternalServerError, "permission check failed."}.mustSend(r, w)
+					return
+				}
+				if !ok {
+					log.WithFields(log.Fields{
+						"id":         me.ID,
+						"ip":         GetIP(r),
+						"url":        r.URL.Path,
+						"permission": spec.permission,
+					}).Warn("PERMISSION_DENIED")
+					audit(r, me, schema.AuditPermissionDenied, schema.AuditResultDenied, nil,
+						spec.permission+" "+r.URL.Path)
+					reqLog(r)
+					rAction{actionForbidden, "permission denied."}.mustSend(r, w)
+					return
+				}
+			}
 		}
 
 		env := &Environ{
-			Me:   me,
-			Conf: schema.Config(),
-			DB:   schema.Database()}
+			Me:    me,
+			Token: claims,
+			Conf:  schema.Config(),
+			DB:    schema.Database()}
 
 		res := f(w, r, env)
 		if res == nil {
@@ -157,24 +297,150 @@ func processAction(
 
 // Action function is a middleware of http handler function for non login required handlers.
 func nonAction(f actionFunc) http.HandlerFunc {
-	return processAction(false, f)
+	return processAction(actionSpec{loginRequired: false}, f)
 }
 
 // Action function is a middleware of http handler function for login requred handlers.
 func action(f actionFunc) http.HandlerFunc {
-	return processAction(true, f)
+	return processAction(actionSpec{loginRequired: true}, f)
+}
+
+// changePassAction function is a middleware of http handler function for login required handlers
+// which also accept restricted tokens of users who must change their password.
+func changePassAction(f actionFunc) http.HandlerFunc {
+	return processAction(actionSpec{
+		loginRequired: true,
+		scopes:        []string{schema.ScopeAll, schema.ScopeChangePassword},
+	}, f)
+}
+
+// adminAction function is a middleware of http handler function for login required handlers
+// which also require the permission given by the user's roles. The handler can be called only from
+// the ip addresses in the whitelist.
+func adminAction(permission string, f actionFunc) http.HandlerFunc {
+	return processAction(actionSpec{loginRequired: true, permission: permission, allowListOnly: true}, f)
+}
+
+// cors 함수는 [cors] 섹션의 설정에 따라 다른 출처(Origin)의 요청을 처리하는 미들웨어이다.
+// 허용된 출처에만 Access-Control-Allow-Origin 헤더를 보내며, 사전 요청(preflight)은 라우터까지
+// 보내지 않고 여기서 바로 응답한다. 허용되지 않은 출처의 사전 요청은 403으로 거부한다.
+func cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		conf := schema.Config()
+		preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+
+		// 출처에 따라 응답이 달라지므로 캐시가 출처별로 나뉘도록 한다.
+		w.Header().Add("Vary", "Origin")
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if !conf.IsAllowOrigin(origin) {
+			if preflight {
+				log.WithFields(log.Fields{
+					"origin": origin,
+					"url":    r.URL.Path,
+				}).Debug("CORS_ORIGIN_DENIED")
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			// 일반 요청은 처리하되 CORS 헤더를 보내지 않아 브라우저가 응답을 읽지 못하게 한다.
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if conf.IsCORSCredentials() {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		methods := conf.CORSMethods()
+		method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+		allowed := false
+		for _, m := range methods {
+			if m == method {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(conf.CORSHeaders(), ", "))
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(conf.CORSMaxAge()))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// denyList 함수는 [server] 섹션의 denylist에 포함된 IP의 요청을 모든 라우트에서 거부하는 미들웨어이다.
+func denyList(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if schema.Config().IsDeniedIP(GetIP(r)) {
+			log.WithFields(log.Fields{
+				"ip":  GetIP(r),
+				"url": r.URL.Path,
+			}).Warn("IP_DENIED")
+			reqLog(r)
+			rAction{actionForbidden, "ip denied."}.mustSend(r, w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // MustInit function is register Action and NonAction handler functions.
-func MustInit() *mux.Router {
+// The returned handler resolves the client ip once and rejects requests from the deny list before routing.
+func MustInit() http.Handler {
 	r := mux.NewRouter()
 	// nonAction 함수(로그인 하지 않은 상태에서 불리는 함수)
 	r.HandleFunc("/activation/{code:[a-z0-9]{8}-[a-z0-9]{4}-[1-5][a-z0-9]{3}-[a-z0-9]{4}-[a-z0-9]{12}}", nonAction(activationHandler)).Methods("GET")
+	r.HandleFunc("/activation/resend", nonAction(activationResendHandler)).Methods("POST")
 	r.HandleFunc("/signup", nonAction(signupHandler))
+	r.HandleFunc("/changeemail/{token:[A-Za-z0-9_-]{43}}", nonAction(confirmEmailHandler)).Methods("GET", "POST")
+	r.HandleFunc("/token/refresh", nonAction(tokenRefreshHandler)).Methods("POST")
+	r.HandleFunc("/.well-known/jwks.json", nonAction(jwksHandler)).Methods("GET")
 
 	// action 함수(로그인 된 후에만 부를 수 있는 함수)
-	r.HandleFunc("/reloadconfig", action(reloadConfigHandler)).Methods("GET")
-	r.HandleFunc("/logout", action(logoutHandler)).Methods("POST")
+	r.HandleFunc("/reloadconfig", adminAction(schema.PermConfigReload, reloadConfigHandler)).Methods("GET")
+	r.HandleFunc("/admin/config", adminAction(schema.PermConfigRead, adminConfigHandler)).Methods("GET")
+	r.HandleFunc("/logout", changePassAction(logoutHandler)).Methods("POST")
+	r.HandleFunc("/changepass", changePassAction(changePassHandler)).Methods("POST")
+	r.HandleFunc("/changeemail", action(changeEmailHandler)).Methods("POST")
+	r.HandleFunc("/sessions", action(sessionListHandler)).Methods("GET")
+	r.HandleFunc("/sessions/revoke", action(sessionRevokeHandler)).Methods("POST")
+	r.HandleFunc("/sessions/revokeothers", action(sessionRevokeOthersHandler)).Methods("POST")
+	r.HandleFunc("/2fa/enroll", action(twoFactorEnrollHandler)).Methods("POST")
+	r.HandleFunc("/2fa/confirm", action(twoFactorConfirmHandler)).Methods("POST")
+	r.HandleFunc("/2fa/disable", action(twoFactorDisableHandler)).Methods("POST")
+	r.HandleFunc("/admin/2fa/reset", adminAction(schema.PermUserTwoFactor, adminResetTwoFactorHandler)).Methods("POST")
+	r.HandleFunc("/admin/users", adminAction(schema.PermUserRead, adminUserListHandler)).Methods("POST")
+	r.HandleFunc("/admin/users/get", adminAction(schema.PermUserRead, adminUserHandler)).Methods("POST")
+	r.HandleFunc("/admin/users/block", adminAction(schema.PermUserBlock, adminBlockUserHandler)).Methods("POST")
+	r.HandleFunc("/admin/users/unblock", adminAction(schema.PermUserUnblock, adminUnblockUserHandler)).Methods("POST")
+	r.HandleFunc("/admin/users/promote", adminAction(schema.PermUserRole, adminPromoteUserHandler)).Methods("POST")
+	r.HandleFunc("/admin/users/demote", adminAction(schema.PermUserRole, adminDemoteUserHandler)).Methods("POST")
+	r.HandleFunc("/admin/users/roles/grant", adminAction(schema.PermUserRole, adminGrantRoleHandler)).Methods("POST")
+	r.HandleFunc("/admin/users/roles/revoke", adminAction(schema.PermUserRole, adminRevokeRoleHandler)).Methods("POST")
+	r.HandleFunc("/admin/users/resetpass", adminAction(schema.PermUserResetPassword, adminResetPassUserHandler)).Methods("POST")
+	r.HandleFunc("/admin/users/activate", adminAction(schema.PermUserActivate, adminActivateUserHandler)).Methods("POST")
+	r.HandleFunc("/admin/audit", adminAction(schema.PermAuditRead, adminAuditHandler)).Methods("POST")
+	r.HandleFunc("/admin/audit/export", adminAction(schema.PermAuditRead, adminAuditExportHandler)).Methods("POST")
+	r.HandleFunc("/admin/mail", adminAction(schema.PermMailRead, adminMailHandler)).Methods("POST")
 	r.HandleFunc("/withdraw", action(withdrawHandler)).Methods("POST")
 
 	// 테스트용 함수
@@ -182,13 +448,15 @@ func MustInit() *mux.Router {
 
 	// angularjs용 함수
 	r.HandleFunc("/login", nonAction(loginHandler))
+	r.HandleFunc("/login/2fa", nonAction(loginSecondFactorHandler)).Methods("POST")
 	r.HandleFunc("/todolist", action(todolistHandler))
 	r.HandleFunc("/todosave", action(todoSaveHandler))
 	r.HandleFunc("/todoremove", action(todoRemoveHandler))
 	r.HandleFunc("/findpass", nonAction(findPassHandler))
+	r.HandleFunc("/resetpass/{token:[A-Za-z0-9_-]{43}}", nonAction(resetPassHandler)).Methods("GET", "POST")
 
 	// 번역 js 파일
 	//r.HandleFunc("/translate/{lang}", nonAction(translateHandler))
 
-	return r
+	return clientIP(denyList(cors(r)))
 }
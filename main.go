@@ -4,6 +4,8 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
 
@@ -19,10 +21,17 @@ const (
 	configFilePath = "auth.cfg"
 )
 
+var (
+	migrateCommand = flag.String("migrate", "", "run database migration and exit (up, down, status)")
+	migrateTarget  = flag.Int("target", -1, "schema version for -migrate up/down (default: latest for up, previous for down)")
+)
+
 func init() {
 	log.SetFormatter(&logstash.LogstashFormatter{Type: serverName})
-	schema.MustInit(configFilePath)
-	conf := schema.Config()
+}
+
+// setLogLevel 함수는 테스트 서버 여부에 따라 로그 출력과 레벨을 정한다.
+func setLogLevel(conf *schema.Configure) {
 	if conf.IsTestServer() {
 		log.SetOutput(os.Stdout)
 		log.SetLevel(log.DebugLevel)
@@ -33,6 +42,21 @@ func init() {
 }
 
 func main() {
+	flag.Parse()
+	if *migrateCommand != "" {
+		if err := schema.Migrate(configFilePath, *migrateCommand, *migrateTarget, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "migrate error. err=%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	schema.MustInit(configFilePath)
+	setLogLevel(schema.Config())
+	schema.OnConfigChange(func(old *schema.Configure, c *schema.Configure) (func(), error) {
+		return func() { setLogLevel(c) }, nil
+	}, "server")
+
 	log.Infof("%s version %s start", serverName, serverVersion)
 	router := handlers.MustInit()
 	bindAddr := schema.Config().Server.Bind
@@ -0,0 +1,229 @@
+package schema
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/gorp.v1"
+	"jsproj.com/koo/gosari/utils"
+)
+
+// AuditEntry 객체는 보안 감사 기록 스키마 객체이다. 여기에서 정의된 형태로 데이터베이스 테이블이
+// 작성된다. 따라서 이 코드를 바꾸는 경우 반드시 DB 마이그레이션이 필요하다.
+//
+// 감사 기록은 추가만 할 수 있으며 수정하거나 지우는 함수는 제공하지 않는다. 사용자가 영구 삭제
+// 되더라도 감사 기록은 남는다.
+type AuditEntry struct {
+	AID       int64  `db:"aid" json:"aid"`
+	Created   int64  `db:"created" json:"created"`     // 기록 시각
+	Event     string `db:"event" json:"event"`         // 사건 종류(AuditXXX)
+	Result    string `db:"result" json:"result"`       // 처리 결과(AuditResultXXX)
+	ActorUID  int64  `db:"actoruid" json:"actoruid"`   // 요청한 사용자. 로그인 전이면 0
+	TargetUID int64  `db:"targetuid" json:"targetuid"` // 대상 사용자. 없으면 0
+	TargetID  string `db:"targetid" json:"targetid"`   // 대상 아이디(로그인 실패처럼 사용자가 없을 수도 있는 경우)
+	IP        string `db:"ip" json:"ip"`
+	UserAgent string `db:"useragent" json:"useragent"`
+	Detail    string `db:"detail" json:"detail"` // 추가 정보(블럭 사유, 역할 이름 등)
+}
+
+// 감사 기록의 사건 종류
+const (
+	AuditSignup             = "signup"
+	AuditActivation         = "activation"
+	AuditLoginSuccess       = "login.success"
+	AuditLoginFailure       = "login.failure"
+	AuditPasswordResetReq   = "password.reset.request"
+	AuditPasswordReset      = "password.reset"
+	AuditPasswordChange     = "password.change"
+	AuditEmailChange        = "email.change"
+	AuditWithdraw           = "withdraw"
+	AuditWithdrawRestore    = "withdraw.restore"
+	AuditConfigReload       = "config.reload"
+	AuditBlock              = "admin.block"
+	AuditUnblock            = "admin.unblock"
+	AuditRoleGrant          = "admin.role.grant"
+	AuditRoleRevoke         = "admin.role.revoke"
+	AuditForcePasswordReset = "admin.resetpass"
+	AuditAdminActivation    = "admin.activate"
+	AuditTwoFactorReset     = "admin.2fa.reset"
+	AuditPermissionDenied   = "permission.denied"
+)
+
+// 감사 기록의 처리 결과
+const (
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+	AuditResultDenied  = "denied"
+)
+
+// AuditEntry 스키마 상수 정의. 이곳에서 사용되는 상수는 데이터베이스에 반영되므로 값을 변경하면 안된다.
+// 불가피하게 값을 변경해야 할 경우는 기존 데이터베이스가 마이그레이션 되어야 한다.
+const (
+	AuditEventMaxSize  = 50
+	AuditResultMaxSize = 20
+	AuditDetailMaxSize = 255
+
+	AuditListDefaultSize = 50  // 한 페이지의 기본 기록 수
+	AuditListMaxSize     = 500 // 한 페이지의 최대 기록 수
+	auditExportBatchSize = 1000
+)
+
+// WriteAudit 함수는 감사 기록을 남긴다. 운영 서버의 로그 레벨과 관계 없이 데이터베이스에 저장된다.
+// 기록에 실패하더라도 요청은 계속 처리되어야 하므로 오류를 리턴하지 않고 로그만 남긴다.
+func WriteAudit(e *AuditEntry) {
+	db := Database()
+	e.AID = 0
+	e.Created = utils.ServerTime()
+	e.TargetID = truncate(e.TargetID, IDMaxSize)
+	e.IP = truncate(e.IP, IPMaxSize)
+	e.UserAgent = truncate(e.UserAgent, UserAgentMaxSize)
+	e.Detail = truncate(e.Detail, AuditDetailMaxSize)
+	if err := db.Auth.Insert(e); err != nil {
+		log.WithFields(log.Fields{
+			"event":     e.Event,
+			"result":    e.Result,
+			"actoruid":  e.ActorUID,
+			"targetuid": e.TargetUID,
+			"targetid":  e.TargetID,
+			"err":       err,
+		}).Error("AUDIT_WRITE_FAILED")
+	}
+}
+
+// AuditFilter 구조체는 감사 기록을 검색할 때의 조건이다. 0이거나 빈 문자열인 조건은 사용하지 않는다.
+type AuditFilter struct {
+	Event     string // 사건 종류. 끝이 .이면 해당 접두어로 시작하는 사건(예: admin.)
+	Result    string
+	ActorUID  int64
+	TargetUID int64
+	TargetID  string
+	IP        string
+	Since     int64 // 이 시각 이후(포함)
+	Until     int64 // 이 시각 이전(포함)
+}
+
+// where 함수는 검색 조건으로 where 절과 인자를 만든다.
+func (f AuditFilter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if f.Event != "" {
+		if strings.HasSuffix(f.Event, ".") {
+			conds = append(conds, "event like ?")
+			args = append(args, escapeLike(f.Event)+"%")
+		} else {
+			conds = append(conds, "event=?")
+			args = append(args, f.Event)
+		}
+	}
+	if f.Result != "" {
+		conds = append(conds, "result=?")
+		args = append(args, f.Result)
+	}
+	if f.ActorUID != 0 {
+		conds = append(conds, "actoruid=?")
+		args = append(args, f.ActorUID)
+	}
+	if f.TargetUID != 0 {
+		conds = append(conds, "targetuid=?")
+		args = append(args, f.TargetUID)
+	}
+	if f.TargetID != "" {
+		conds = append(conds, "targetid=?")
+		args = append(args, f.TargetID)
+	}
+	if f.IP != "" {
+		conds = append(conds, "ip=?")
+		args = append(args, f.IP)
+	}
+	if f.Since != 0 {
+		conds = append(conds, "created>=?")
+		args = append(args, f.Since)
+	}
+	if f.Until != 0 {
+		conds = append(conds, "created<=?")
+		args = append(args, f.Until)
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " where " + strings.Join(conds, " and "), args
+}
+
+// SearchAudit 함수는 조건에 맞는 감사 기록을 최근 순서로 읽는다.
+// page는 1부터 시작하며 조건에 맞는 전체 기록 수를 함께 리턴한다.
+func SearchAudit(filter AuditFilter, page int, size int) ([]*AuditEntry, int64, error) {
+	db := Database()
+
+	if page < 1 {
+		page = 1
+	}
+	if size <= 0 {
+		size = AuditListDefaultSize
+	}
+	if size > AuditListMaxSize {
+		size = AuditListMaxSize
+	}
+
+	where, args := filter.where()
+	total, err := db.Auth.SelectInt("select count(*) from audit"+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entries []*AuditEntry
+	args = append(args, size, (page-1)*size)
+	_, err = db.Auth.Select(&entries, "select * from audit"+where+" order by aid desc limit ? offset ?", args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// ExportAudit 함수는 조건에 맞는 감사 기록을 오래된 순서로 한 줄에 하나씩 json 형식(JSON Lines)으로
+// w에 쓴다. 기록이 많더라도 메모리를 많이 사용하지 않도록 나누어 읽는다.
+func ExportAudit(filter AuditFilter, w io.Writer) (int, error) {
+	db := Database()
+	enc := json.NewEncoder(w)
+
+	where, args := filter.where()
+	if where == "" {
+		where = " where aid>?"
+	} else {
+		where += " and aid>?"
+	}
+
+	var last int64
+	count := 0
+	for {
+		var entries []*AuditEntry
+		batchArgs := append(append([]interface{}{}, args...), last, auditExportBatchSize)
+		_, err := db.Auth.Select(&entries, "select * from audit"+where+" order by aid limit ?", batchArgs...)
+		if err != nil {
+			return count, err
+		}
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return count, err
+			}
+			last = e.AID
+			count++
+		}
+		if len(entries) < auditExportBatchSize {
+			return count, nil
+		}
+	}
+}
+
+func createAuditTable(dbmap *gorp.DbMap) {
+	gob.Register(&AuditEntry{})
+	table := dbmap.AddTableWithName(AuditEntry{}, "audit").SetKeys(true, "AID")
+	table.ColMap("Event").SetMaxSize(AuditEventMaxSize)
+	table.ColMap("Result").SetMaxSize(AuditResultMaxSize)
+	table.ColMap("TargetID").SetMaxSize(IDMaxSize)
+	table.ColMap("IP").SetMaxSize(IPMaxSize)
+	table.ColMap("UserAgent").SetMaxSize(UserAgentMaxSize)
+	table.ColMap("Detail").SetMaxSize(AuditDetailMaxSize)
+}
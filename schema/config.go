@@ -1,81 +1,306 @@
 package schema
 
 import (
+	"fmt"
+	"net"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 
-	"code.google.com/p/gcfg"
 	log "github.com/Sirupsen/logrus"
 )
 
 // Configure 구조체는 설정 파일 스키마이다.
+// 설정 파일을 읽는 순서와 환경 변수로 덮어쓰는 방법은 readConfigLayers를 참고한다.
+// secret:"true" 태그가 붙은 항목은 로그와 응답에 값을 남기지 않는다.
 type Configure struct {
+	Config struct {
+		Profile string `json:"profile"` // 추가로 읽을 프로필 이름(test, staging, prod 등)
+		Include string `json:"include"` // 이 파일 다음에 읽을 파일 목록
+	} `json:"config"`
 	Server struct {
 		Bind      string `json:"bind"`
 		Test      string `json:"test"`
 		Whitelist string `json:"whitelist"`
-		AppID     string `json:"appid"`
+		Denylist  string `json:"denylist"`
+		AppID     string `json:"appid" secret:"true"`
+		// 프록시 헤더(X-Forwarded-For, Forwarded)를 믿을 수 있는 프록시의 IP 혹은 CIDR 목록
+		TrustedProxies string `json:"trustedproxies"`
+		RealIP         string `json:"realip"`
+		// 설정 파일이 바뀌었는지 확인하는 간격(초). 0이면 SIGHUP과 /reloadconfig로만 다시 읽는다.
+		ConfigWatchSecond int `json:"configwatchsecond"`
 	} `json:"server"`
 	Redis struct {
 		Host string `json:"host"`
 		Port int    `json:"port"`
 	} `json:"redis"`
 	Database struct {
-		Auth string `json:"auth"`
+		Driver      string `json:"driver"`
+		Auth        string `json:"auth" secret:"true"`
+		AutoMigrate string `json:"automigrate"`
 	} `json:"database"`
 	SMTP struct {
 		Server   string `json:"server"`
 		Port     int    `json:"port"`
 		User     string `json:"user"`
 		UserName string `json:"username"`
-		Password string `json:"password"`
+		Password string `json:"password" secret:"true"`
 	} `json:"smtp"`
+	Mail struct {
+		Transport      string `json:"transport"`
+		SendmailPath   string `json:"sendmailpath"`
+		SpoolDir       string `json:"spooldir"`
+		Workers        int    `json:"workers"`
+		MaxAttempts    int    `json:"maxattempts"`
+		RetrySecond    int64  `json:"retrysecond"`
+		MaxRetrySecond int64  `json:"maxretrysecond"`
+		PollSecond     int64  `json:"pollsecond"`
+		KeepDay        int    `json:"keepday"`
+	} `json:"mail"`
+	JWT struct {
+		ActiveKID    string `json:"activekid"`
+		Algorithm    string `json:"algorithm"`
+		Issuer       string `json:"issuer"`
+		Audience     string `json:"audience"`
+		LeewaySecond int    `json:"leewaysecond"`
+	} `json:"jwt"`
+	JWTKey map[string]*struct {
+		PrivateKeyFile string `json:"privatekeyfile"`
+		PublicKeyFile  string `json:"publickeyfile"`
+	} `json:"jwtkey"`
+	Token struct {
+		AccessExpireMinute int    `json:"accessexpireminute"`
+		RefreshExpireDay   int    `json:"refreshexpireday"`
+		RevocationStore    string `json:"revocationstore"`
+	} `json:"token"`
+	Password struct {
+		Hasher            string `json:"hasher"`
+		ResetExpireMinute int    `json:"resetexpireminute"`
+		ResetAttempts     int64  `json:"resetattempts"`
+	} `json:"password"`
+	PasswordPolicy struct {
+		MinLength          int    `json:"minlength"`
+		MaxLength          int    `json:"maxlength"`
+		MinCharClasses     int    `json:"mincharclasses"`
+		RejectEmail        string `json:"rejectemail"`
+		CommonPasswordFile string `json:"commonpasswordfile"`
+		HistorySize        int    `json:"historysize"`
+	} `json:"passwordpolicy"`
+	Throttle struct {
+		Store           string `json:"store"`
+		LoginAttempts   int64  `json:"loginattempts"`
+		LoginIPAttempts int64  `json:"loginipattempts"`
+		BaseLockSecond  int64  `json:"baselocksecond"`
+		MaxLockSecond   int64  `json:"maxlocksecond"`
+		WindowSecond    int64  `json:"windowsecond"`
+	} `json:"throttle"`
+	TOTP struct {
+		Issuer string `json:"issuer"`
+	} `json:"totp"`
+	ChangeEmail struct {
+		ExpireMinute int `json:"expireminute"`
+	} `json:"changeemail"`
+	Withdraw struct {
+		GraceDay            int `json:"graceday"`
+		PurgeIntervalMinute int `json:"purgeintervalminute"`
+	} `json:"withdraw"`
+	CORS struct {
+		Origins      string `json:"origins"`
+		Methods      string `json:"methods"`
+		Headers      string `json:"headers"`
+		Credentials  string `json:"credentials"`
+		MaxAgeSecond int    `json:"maxagesecond"`
+	} `json:"cors"`
 	Activation struct {
-		Use string `json:"use"`
+		Use            string `json:"use"`
+		ExpireHour     int    `json:"expirehour"`
+		ResendAttempts int64  `json:"resendattempts"`
 	} `json:"activation"`
 	Resources struct {
 		PrivateKeyFile string `json:"privatekeyfile"`
 		PublicKeyFile  string `json:"publickeyfile"`
 		TemplatePath   string `json:"templatepath"`
-		StaticPath   string `json:"staticpath"`
+		StaticPath     string `json:"staticpath"`
 	} `json:"resources"`
+
+	sources []string // 읽은 파일과 환경 변수
+
+	// [server] 섹션의 IP 목록. 요청마다 읽지 않도록 LoadConfig에서 한 번 읽어 둔다.
+	whitelist      []*net.IPNet
+	denylist       []*net.IPNet
+	trustedProxies []*net.IPNet
 }
 
+const (
+	defaultJWTAlgorithm       = "RS256"              // [jwt] 섹션의 algorithm 기본값
+	defaultPasswordHasher     = "argon2id"           // [password] 섹션의 hasher 기본값
+	defaultResetExpireMinute  = 30                   // [password] 섹션의 resetexpireminute 기본값
+	defaultResetAttempts      = 3                    // [password] 섹션의 resetattempts 기본값
+	defaultLoginAttempts      = 5                    // [throttle] 섹션의 loginattempts 기본값
+	defaultLoginIPAttempts    = 20                   // [throttle] 섹션의 loginipattempts 기본값
+	defaultBaseLockSecond     = 1                    // [throttle] 섹션의 baselocksecond 기본값
+	defaultMaxLockSecond      = 900                  // [throttle] 섹션의 maxlocksecond 기본값
+	defaultThrottleWindow     = 3600                 // [throttle] 섹션의 windowsecond 기본값
+	defaultTOTPIssuer         = "talkcrew"           // [totp] 섹션의 issuer 기본값
+	defaultActivationExpire   = 48                   // [activation] 섹션의 expirehour 기본값
+	defaultResendAttempts     = 3                    // [activation] 섹션의 resendattempts 기본값
+	defaultEmailChangeExpire  = 60                   // [changeemail] 섹션의 expireminute 기본값
+	defaultWithdrawGraceDay   = 14                   // [withdraw] 섹션의 graceday 기본값
+	defaultPurgeInterval      = 60                   // [withdraw] 섹션의 purgeintervalminute 기본값
+	defaultAccessExpireMinute = 15                   // [token] 섹션의 accessexpireminute 기본값
+	defaultRefreshExpireDay   = 30                   // [token] 섹션의 refreshexpireday 기본값
+	defaultCORSMethods        = "GET, POST, OPTIONS" // [cors] 섹션의 methods 기본값
+	defaultCORSMaxAge         = 600                  // [cors] 섹션의 maxagesecond 기본값
+)
+
+// [mail] 섹션의 기본값
+const (
+	defaultMailSendmailPath   = "/usr/sbin/sendmail"
+	defaultMailSpoolDir       = "./mailspool"
+	defaultMailWorkers        = 2
+	defaultMailMaxAttempts    = 8
+	defaultMailRetrySecond    = 30
+	defaultMailMaxRetrySecond = 3600
+	defaultMailPollSecond     = 5
+	defaultMailKeepDay        = 7
+)
+
+// defaultCORSHeaders 는 [cors] 섹션의 headers 기본값이다.
+const defaultCORSHeaders = "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization"
+
 var (
-	conf     Configure
+	current  atomic.Value // *Configure
 	conffile string
 )
 
 func mustInitConfig(confFileName string) {
 	conffile = confFileName
-	LoadConfig()
+	c, err := LoadConfig(conffile)
+	if err != nil {
+		log.Fatalf("read config error. file=%s, err=%v", conffile, err)
+	}
+	current.Store(c)
 	log.Info("config file loaded.")
 }
 
-// LoadConfig 함수는 설정을 읽는다.
-func LoadConfig() {
-	err := gcfg.ReadFileInto(&conf, conffile)
+// LoadConfig 함수는 설정 파일과 include, 프로필, 환경 변수를 새 구조체로 읽고 검사한다.
+// 현재 설정은 바뀌지 않는다. 파일은 읽었지만 검사에 실패한 경우에도 무엇이 바뀌려 했는지 비교할 수
+// 있도록 읽은 설정을 함께 리턴한다.
+func LoadConfig(filename string) (*Configure, error) {
+	c, err := readConfigLayers(filename)
 	if err != nil {
-		log.Fatalf("read config error. file=%s, err=%v", conffile, err)
+		return nil, &ConfigError{File: filename, Errors: []string{err.Error()}}
+	}
+	if errs := c.Validate(); len(errs) > 0 {
+		return c, &ConfigError{File: filename, Errors: errs}
 	}
+	c.parseIPLists()
+	return c, nil
+}
+
+// parseIPLists 함수는 [server] 섹션의 IP 목록을 읽어 둔다. 목록의 형식은 Validate에서 검사된다.
+func (c *Configure) parseIPLists() {
+	c.whitelist, _ = ParseCIDRList(c.Server.Whitelist)
+	c.denylist, _ = ParseCIDRList(c.Server.Denylist)
+	c.trustedProxies, _ = ParseCIDRList(c.Server.TrustedProxies)
 }
 
 // Config 함수는 현재 설정 구조체를 리턴한다.
+// 설정을 다시 읽으면 새 구조체로 교체되므로, 한 요청을 처리하는 동안에는 처음 얻은 구조체를 계속 사용해야
+// 일관된 값을 얻을 수 있다. 리턴된 구조체는 수정해서는 안된다.
 func Config() *Configure {
-	return &conf
+	return current.Load().(*Configure)
+}
+
+// Validate 함수는 설정 값을 검사하여 잘못된 항목의 목록을 리턴한다. 문제가 없으면 빈 목록을 리턴한다.
+func (c *Configure) Validate() []string {
+	var errs []string
+	invalid := func(section, item string, format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf("[%s] %s: ", section, item)+fmt.Sprintf(format, args...))
+	}
+
+	if c.Server.Bind == "" {
+		invalid("server", "bind", "required")
+	}
+	for _, item := range []struct{ name, list string }{
+		{"whitelist", c.Server.Whitelist},
+		{"denylist", c.Server.Denylist},
+		{"trustedproxies", c.Server.TrustedProxies},
+	} {
+		if _, err := ParseCIDRList(item.list); err != nil {
+			invalid("server", item.name, "%v", err)
+		}
+	}
+	switch c.DatabaseDriver() {
+	case DriverMySQL, DriverPostgres, DriverSQLite:
+		if c.Database.Auth == "" {
+			invalid("database", "auth", "required")
+		}
+	case DriverMemory:
+	default:
+		invalid("database", "driver", "unknown driver %q", c.Database.Driver)
+	}
+
+	if _, ok := mailTransports[c.MailTransport()]; !ok {
+		invalid("mail", "transport", "unknown transport %q", c.Mail.Transport)
+	}
+
+	switch c.JWTAlgorithm() {
+	case "RS256", "RS384", "RS512":
+	default:
+		invalid("jwt", "algorithm", "unsupported algorithm %q", c.JWTAlgorithm())
+	}
+	if len(c.JWTKey) > 0 {
+		if _, ok := c.JWTKey[c.JWT.ActiveKID]; !ok {
+			invalid("jwt", "activekid", "no [jwtkey %q] section", c.JWT.ActiveKID)
+		}
+	}
+
+	name := c.PasswordHasher()
+	if findPasswordHasher(func(h PasswordHasher) bool { return h.Name() == name }) == nil {
+		invalid("password", "hasher", "unknown hasher %q", name)
+	}
+	if c.PasswordMinLength() > c.PasswordMaxLength() {
+		invalid("passwordpolicy", "minlength", "greater than maxlength(%d)", c.PasswordMaxLength())
+	}
+
+	// 모든 출처에 자격 증명을 허용하면 어느 사이트든 사용자의 쿠키로 api를 부를 수 있다.
+	if c.IsCORSCredentials() {
+		for _, allowed := range splitList(c.CORS.Origins) {
+			if allowed == "*" {
+				invalid("cors", "origins", "* can not be used with credentials=true")
+				break
+			}
+		}
+	}
+
+	switch strings.ToLower(c.Throttle.Store) {
+	case "", "redis", "memory":
+	default:
+		invalid("throttle", "store", "unknown store %q", c.Throttle.Store)
+	}
+	switch strings.ToLower(c.Token.RevocationStore) {
+	case "", "redis", "memory":
+	default:
+		invalid("token", "revocationstore", "unknown store %q", c.Token.RevocationStore)
+	}
+
+	return errs
 }
 
 // IsAllowIP 함수는 해당 IP가 화이트리스트에 포함 되었는지 여부를 리턴한다.
-// 화이트 리스트에 IP를 추가 하려면 [server] 섹션의 whitelist 항목에 추가한다.
+// 화이트 리스트에 IP 혹은 CIDR(예: 10.0.0.0/8, 2001:db8::/32)을 추가 하려면 [server] 섹션의
+// whitelist 항목에 쉼표로 구분하여 추가한다. 목록의 형식이 잘못된 설정은 LoadConfig에서 거부된다.
 // 주의할 점은 인자로 넣는 IP가 nginx등으로 포워딩 되어 들어오는 경우 서버의 IP로 바뀔 수 있기 때문에
 // http.Request.RemoteAddr을 사용하지 말고 handlers.GetIP(r) 함수를 사용해야 한다.
-func (c *Configure) IsAllowIP(remoteAddr string) bool {
-	for _, element := range strings.Split(c.Server.Whitelist, ",") {
-		if strings.Trim(element, " ") == strings.Split(remoteAddr, ":")[0] {
-			return true
-		}
-	}
-	return false
+func (c *Configure) IsAllowIP(ip string) bool {
+	return IPInList(ip, c.whitelist)
+}
+
+// IsDeniedIP 함수는 해당 IP가 차단 목록에 포함 되었는지 여부를 리턴한다.
+// 차단 목록은 [server] 섹션의 denylist 항목에 whitelist와 같은 형식으로 설정하며 모든 요청에 적용된다.
+func (c *Configure) IsDeniedIP(ip string) bool {
+	return IPInList(ip, c.denylist)
 }
 
 // TemplatePath 함수는 인자로 주어진 파일명으로부터 템플릿 파일의 전체 경로를 얻어온다.
@@ -113,7 +338,417 @@ func (c *Configure) IsTestServer() bool {
 	return strings.EqualFold(c.Server.Test, "true")
 }
 
+// IsUseRealIP 함수는 신뢰하는 프록시가 보낸 X-Real-IP 헤더를 클라이언트 IP로 사용할지 여부를 반환한다.
+// [server] 섹션의 realip 항목에서 설정한다.
+func (c *Configure) IsUseRealIP() bool {
+	return strings.EqualFold(c.Server.RealIP, "true")
+}
+
+// DatabaseDriver 함수는 사용할 데이터베이스 종류(DriverXXX)를 반환한다.
+// 종류는 [database] 섹션의 driver 항목에서 설정하며 기본값은 mysql이다. auth 항목에는 각 드라이버
+// 형식의 접속 정보(SQLite는 파일 경로)를 설정한다.
+func (c *Configure) DatabaseDriver() string {
+	if c.Database.Driver == "" {
+		return DriverMySQL
+	}
+	return strings.ToLower(c.Database.Driver)
+}
+
+// IsAutoMigrate 함수는 서버가 시작될 때 적용되지 않은 마이그레이션을 자동으로 적용할지 여부를 반환한다.
+// [database] 섹션의 automigrate 항목에서 설정하며 기본값은 true이다. false이면 -migrate up으로 먼저
+// 적용해야 서버가 시작된다.
+func (c *Configure) IsAutoMigrate() bool {
+	return !strings.EqualFold(c.Database.AutoMigrate, "false")
+}
+
+// ConfigWatchInterval 함수는 설정 파일이 바뀌었는지 확인하는 간격을 초 단위로 반환한다.
+// 간격은 [server] 섹션의 configwatchsecond 항목에서 설정하며 0이면 확인하지 않는다.
+func (c *Configure) ConfigWatchInterval() int64 {
+	if c.Server.ConfigWatchSecond < 0 {
+		return 0
+	}
+	return int64(c.Server.ConfigWatchSecond)
+}
+
 // IsUseActivation 함수는 이메일 인증 사용 여부를 설정 파일로부터 반환한다.
 func (c *Configure) IsUseActivation() bool {
 	return strings.EqualFold(c.Activation.Use, "true")
 }
+
+// ActivationExpire 함수는 이메일 인증키의 유효 기간을 초 단위로 반환한다.
+// 유효 기간은 [activation] 섹션의 expirehour 항목에서 설정한다.
+func (c *Configure) ActivationExpire() int64 {
+	hour := c.Activation.ExpireHour
+	if hour <= 0 {
+		hour = defaultActivationExpire
+	}
+	return int64(hour) * 3600
+}
+
+// ActivationResendThrottle 함수는 아이디별 인증 메일 재발송 제한 정책을 반환한다.
+// 허용 횟수는 [activation] 섹션의 resendattempts 항목에서 설정하며, 잠금 시간은 [throttle] 섹션을 따른다.
+func (c *Configure) ActivationResendThrottle() ThrottleLimit {
+	attempts := c.Activation.ResendAttempts
+	if attempts <= 0 {
+		attempts = defaultResendAttempts
+	}
+	return c.throttleLimit(attempts)
+}
+
+// EmailChangeExpire 함수는 이메일 변경 확인 링크의 유효 기간을 초 단위로 반환한다.
+// 유효 기간은 [changeemail] 섹션의 expireminute 항목에서 설정한다.
+func (c *Configure) EmailChangeExpire() int64 {
+	minute := c.ChangeEmail.ExpireMinute
+	if minute <= 0 {
+		minute = defaultEmailChangeExpire
+	}
+	return int64(minute) * 60
+}
+
+// WithdrawGrace 함수는 탈퇴 후 다시 로그인하여 탈퇴를 취소할 수 있는 유예 기간을 초 단위로 반환한다.
+// 유예 기간은 [withdraw] 섹션의 graceday 항목에서 설정하며 음수이면 유예 기간 없이 다음 purge 작업에서 삭제된다.
+func (c *Configure) WithdrawGrace() int64 {
+	day := c.Withdraw.GraceDay
+	if day < 0 {
+		return 0
+	}
+	if day == 0 {
+		day = defaultWithdrawGraceDay
+	}
+	return int64(day) * 24 * 3600
+}
+
+// WithdrawPurgeInterval 함수는 유예 기간이 지난 탈퇴 사용자를 영구 삭제하는 작업의 실행 간격을 초 단위로
+// 반환한다. 간격은 [withdraw] 섹션의 purgeintervalminute 항목에서 설정한다.
+func (c *Configure) WithdrawPurgeInterval() int64 {
+	minute := c.Withdraw.PurgeIntervalMinute
+	if minute <= 0 {
+		minute = defaultPurgeInterval
+	}
+	return int64(minute) * 60
+}
+
+// MailTransport 함수는 메일을 보낼 방법(MailTransportXXX)을 반환한다.
+// [mail] 섹션의 transport 항목에서 설정하며 기본값은 smtp이다.
+func (c *Configure) MailTransport() string {
+	if c.Mail.Transport == "" {
+		return MailTransportSMTP
+	}
+	return strings.ToLower(c.Mail.Transport)
+}
+
+// MailSendmailPath 함수는 sendmail 방식에서 실행할 프로그램의 경로를 반환한다.
+func (c *Configure) MailSendmailPath() string {
+	if c.Mail.SendmailPath == "" {
+		return defaultMailSendmailPath
+	}
+	return c.Mail.SendmailPath
+}
+
+// MailSpoolDir 함수는 file 방식에서 메일을 저장할 디렉토리를 반환한다.
+func (c *Configure) MailSpoolDir() string {
+	if c.Mail.SpoolDir == "" {
+		return defaultMailSpoolDir
+	}
+	return c.Mail.SpoolDir
+}
+
+// MailWorkers 함수는 메일 대기열을 처리하는 작업자의 수를 반환한다. 서버를 다시 시작해야 반영된다.
+func (c *Configure) MailWorkers() int {
+	if c.Mail.Workers <= 0 {
+		return defaultMailWorkers
+	}
+	return c.Mail.Workers
+}
+
+// MailMaxAttempts 함수는 메일을 보내는 최대 시도 횟수를 반환한다. 모두 실패한 메일은 dead 상태가 된다.
+func (c *Configure) MailMaxAttempts() int {
+	if c.Mail.MaxAttempts <= 0 {
+		return defaultMailMaxAttempts
+	}
+	return c.Mail.MaxAttempts
+}
+
+// MailRetryDelay 함수는 attempts번 실패한 메일을 다시 보낼 때까지 기다릴 시간을 초 단위로 반환한다.
+// [mail] 섹션의 retrysecond부터 실패할 때마다 두 배로 늘어나며 maxretrysecond를 넘지 않는다.
+func (c *Configure) MailRetryDelay(attempts int) int64 {
+	delay, max := c.Mail.RetrySecond, c.Mail.MaxRetrySecond
+	if delay <= 0 {
+		delay = defaultMailRetrySecond
+	}
+	if max <= 0 {
+		max = defaultMailMaxRetrySecond
+	}
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// MailPollInterval 함수는 새 메일이 없을 때 대기열을 다시 확인하는 간격을 초 단위로 반환한다.
+func (c *Configure) MailPollInterval() int64 {
+	if c.Mail.PollSecond <= 0 {
+		return defaultMailPollSecond
+	}
+	return c.Mail.PollSecond
+}
+
+// MailKeep 함수는 보냈거나 dead 상태가 된 메일의 기록을 보관하는 기간을 초 단위로 반환한다.
+func (c *Configure) MailKeep() int64 {
+	day := c.Mail.KeepDay
+	if day <= 0 {
+		day = defaultMailKeepDay
+	}
+	return int64(day) * 24 * 3600
+}
+
+// AccessTokenExpire 함수는 jwt 액세스 토큰의 유효 기간을 초 단위로 반환한다.
+// 유효 기간은 [token] 섹션의 accessexpireminute 항목에서 설정한다.
+func (c *Configure) AccessTokenExpire() int64 {
+	minute := c.Token.AccessExpireMinute
+	if minute <= 0 {
+		minute = defaultAccessExpireMinute
+	}
+	return int64(minute) * 60
+}
+
+// RefreshTokenExpire 함수는 리프레시 토큰의 유효 기간을 초 단위로 반환한다.
+// 유효 기간은 [token] 섹션의 refreshexpireday 항목에서 설정한다.
+func (c *Configure) RefreshTokenExpire() int64 {
+	day := c.Token.RefreshExpireDay
+	if day <= 0 {
+		day = defaultRefreshExpireDay
+	}
+	return int64(day) * 24 * 3600
+}
+
+// JWTAlgorithm 함수는 jwt 토큰의 서명 알고리즘을 반환한다. 이 알고리즘 이외의 토큰은 거부된다.
+// 알고리즘은 [jwt] 섹션의 algorithm 항목에서 설정하며 rsa 키를 사용하므로 RS256, RS384,
+// RS512 중 하나여야 한다.
+func (c *Configure) JWTAlgorithm() string {
+	if c.JWT.Algorithm == "" {
+		return defaultJWTAlgorithm
+	}
+	return c.JWT.Algorithm
+}
+
+// JWTLeeway 함수는 jwt 토큰의 시간 관련 클레임(exp, nbf, iat)을 검사할 때 허용하는 서버간 시간
+// 오차를 초 단위로 반환한다. 오차는 [jwt] 섹션의 leewaysecond 항목에서 설정한다.
+func (c *Configure) JWTLeeway() int64 {
+	if c.JWT.LeewaySecond < 0 {
+		return 0
+	}
+	return int64(c.JWT.LeewaySecond)
+}
+
+// PasswordHasher 함수는 새 비밀번호를 해시할 때 사용하는 방식의 이름을 반환한다.
+// 방식은 [password] 섹션의 hasher 항목에서 설정하며 다른 방식으로 저장된 비밀번호는 로그인 시에
+// 이 방식으로 다시 해시된다.
+func (c *Configure) PasswordHasher() string {
+	if c.Password.Hasher == "" {
+		return defaultPasswordHasher
+	}
+	return c.Password.Hasher
+}
+
+// PasswordResetExpire 함수는 비밀번호 재설정 토큰의 유효 기간을 초 단위로 반환한다.
+// 유효 기간은 [password] 섹션의 resetexpireminute 항목에서 설정한다.
+func (c *Configure) PasswordResetExpire() int64 {
+	minute := c.Password.ResetExpireMinute
+	if minute <= 0 {
+		minute = defaultResetExpireMinute
+	}
+	return int64(minute) * 60
+}
+
+// PasswordResetThrottle 함수는 아이디별 비밀번호 재설정 메일 요청 제한 정책을 반환한다.
+// 허용 횟수는 [password] 섹션의 resetattempts 항목에서 설정하며, 잠금 시간은 [throttle] 섹션을 따른다.
+func (c *Configure) PasswordResetThrottle() ThrottleLimit {
+	attempts := c.Password.ResetAttempts
+	if attempts <= 0 {
+		attempts = defaultResetAttempts
+	}
+	return c.throttleLimit(attempts)
+}
+
+// PasswordMinLength 함수는 비밀번호 최소 길이(글자 수)를 반환한다.
+// 최소 길이는 [passwordpolicy] 섹션의 minlength 항목에서 설정한다.
+func (c *Configure) PasswordMinLength() int {
+	if c.PasswordPolicy.MinLength <= 0 {
+		return defaultPasswordMinLength
+	}
+	return c.PasswordPolicy.MinLength
+}
+
+// PasswordMaxLength 함수는 비밀번호 최대 길이(글자 수)를 반환한다.
+// 최대 길이는 [passwordpolicy] 섹션의 maxlength 항목에서 설정한다.
+func (c *Configure) PasswordMaxLength() int {
+	if c.PasswordPolicy.MaxLength <= 0 {
+		return defaultPasswordMaxLength
+	}
+	return c.PasswordPolicy.MaxLength
+}
+
+// PasswordHistorySize 함수는 재사용을 금지할 최근 비밀번호의 개수를 반환한다.
+// 개수는 [passwordpolicy] 섹션의 historysize 항목에서 설정하며 음수이면 검사하지 않는다.
+func (c *Configure) PasswordHistorySize() int {
+	if c.PasswordPolicy.HistorySize < 0 {
+		return 0
+	}
+	if c.PasswordPolicy.HistorySize == 0 {
+		return defaultPasswordHistory
+	}
+	return c.PasswordPolicy.HistorySize
+}
+
+// IsRejectEmailInPassword 함수는 이메일의 @ 앞부분이 포함된 비밀번호를 거부할지 여부를 반환한다.
+func (c *Configure) IsRejectEmailInPassword() bool {
+	return strings.EqualFold(c.PasswordPolicy.RejectEmail, "true")
+}
+
+// IsMemoryThrottle 함수는 로그인 실패 횟수 등을 redis 대신 메모리에 저장할지 여부를 반환한다.
+// [throttle] 섹션의 store 항목이 memory이면 메모리에 저장하며, 서버가 여러 대인 경우에는
+// 서버간에 상태가 공유되지 않으므로 redis를 사용해야 한다. 항목이 없으면 [database] 섹션의 driver가
+// memory인 경우에만 메모리에 저장한다.
+func (c *Configure) IsMemoryThrottle() bool {
+	if c.Throttle.Store == "" {
+		return c.DatabaseDriver() == DriverMemory
+	}
+	return strings.EqualFold(c.Throttle.Store, "memory")
+}
+
+// IsMemoryRevocation 함수는 폐기된 토큰 목록을 redis 대신 메모리에 저장할지 여부를 반환한다.
+// [token] 섹션의 revocationstore 항목이 memory이면 메모리에 저장하며, 서버가 여러 대인 경우에는
+// 다른 서버에서 폐기한 토큰이 거부되지 않으므로 redis를 사용해야 한다. 항목이 없으면 [database] 섹션의
+// driver가 memory인 경우에만 메모리에 저장한다.
+func (c *Configure) IsMemoryRevocation() bool {
+	if c.Token.RevocationStore == "" {
+		return c.DatabaseDriver() == DriverMemory
+	}
+	return strings.EqualFold(c.Token.RevocationStore, "memory")
+}
+
+// IsRedisUsed 함수는 redis를 사용하는 저장소가 하나라도 있는지 여부를 반환한다.
+// 사용하지 않으면 서버 시작 시에 redis에 접속하지 않는다.
+func (c *Configure) IsRedisUsed() bool {
+	return !c.IsMemoryThrottle() || !c.IsMemoryRevocation()
+}
+
+// throttleLimit 함수는 [throttle] 섹션의 설정으로 attempts번 실패를 허용하는 잠금 정책을 만든다.
+func (c *Configure) throttleLimit(attempts int64) ThrottleLimit {
+	limit := ThrottleLimit{
+		Attempts: attempts,
+		BaseLock: c.Throttle.BaseLockSecond,
+		MaxLock:  c.Throttle.MaxLockSecond,
+		Window:   c.Throttle.WindowSecond,
+	}
+	if limit.BaseLock <= 0 {
+		limit.BaseLock = defaultBaseLockSecond
+	}
+	if limit.MaxLock <= 0 {
+		limit.MaxLock = defaultMaxLockSecond
+	}
+	if limit.Window <= 0 {
+		limit.Window = defaultThrottleWindow
+	}
+	return limit
+}
+
+// LoginThrottle 함수는 아이디별 로그인 실패 잠금 정책을 반환한다.
+// 허용 횟수는 [throttle] 섹션의 loginattempts 항목에서 설정한다.
+func (c *Configure) LoginThrottle() ThrottleLimit {
+	attempts := c.Throttle.LoginAttempts
+	if attempts <= 0 {
+		attempts = defaultLoginAttempts
+	}
+	return c.throttleLimit(attempts)
+}
+
+// LoginIPThrottle 함수는 IP별 로그인 실패 잠금 정책을 반환한다.
+// 허용 횟수는 [throttle] 섹션의 loginipattempts 항목에서 설정한다.
+func (c *Configure) LoginIPThrottle() ThrottleLimit {
+	attempts := c.Throttle.LoginIPAttempts
+	if attempts <= 0 {
+		attempts = defaultLoginIPAttempts
+	}
+	return c.throttleLimit(attempts)
+}
+
+// splitList 함수는 쉼표로 구분된 설정 값을 공백을 제거한 목록으로 만든다. 빈 항목은 무시한다.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// IsAllowOrigin 함수는 다른 출처(Origin)의 웹 페이지가 api를 부를 수 있는지 여부를 반환한다.
+// 허용할 출처는 [cors] 섹션의 origins 항목에 쉼표로 구분하여 설정한다. https://app.example.com 처럼
+// 정확히 일치하는 출처 혹은 https://*.example.com 처럼 하위 도메인 전체를 지정할 수 있다.
+// 하위 도메인 와일드카드는 example.com 자체는 포함하지 않는다. *는 모든 출처를 허용한다.
+// 목록이 비어 있으면 다른 출처의 요청을 모두 거부한다.
+func (c *Configure) IsAllowOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range splitList(strings.ToLower(c.CORS.Origins)) {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		// https://*.example.com -> https:// 와 .example.com
+		if i := strings.Index(allowed, "://*."); i >= 0 {
+			scheme, domain := allowed[:i+3], allowed[i+4:]
+			if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, domain) &&
+				len(origin) > len(scheme)+len(domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CORSMethods 함수는 다른 출처에서 사용할 수 있는 HTTP 메소드 목록을 반환한다.
+// 목록은 [cors] 섹션의 methods 항목에서 설정한다.
+func (c *Configure) CORSMethods() []string {
+	if c.CORS.Methods == "" {
+		return splitList(defaultCORSMethods)
+	}
+	return splitList(strings.ToUpper(c.CORS.Methods))
+}
+
+// CORSHeaders 함수는 다른 출처에서 보낼 수 있는 요청 헤더 목록을 반환한다.
+// 목록은 [cors] 섹션의 headers 항목에서 설정한다.
+func (c *Configure) CORSHeaders() []string {
+	if c.CORS.Headers == "" {
+		return splitList(defaultCORSHeaders)
+	}
+	return splitList(c.CORS.Headers)
+}
+
+// IsCORSCredentials 함수는 다른 출처의 요청에 쿠키 등의 자격 증명을 허용할지 여부를 반환한다.
+// [cors] 섹션의 credentials 항목에서 설정한다.
+func (c *Configure) IsCORSCredentials() bool {
+	return strings.EqualFold(c.CORS.Credentials, "true")
+}
+
+// CORSMaxAge 함수는 브라우저가 사전 요청(preflight)의 결과를 캐시할 시간을 초 단위로 반환한다.
+// 시간은 [cors] 섹션의 maxagesecond 항목에서 설정한다.
+func (c *Configure) CORSMaxAge() int {
+	if c.CORS.MaxAgeSecond <= 0 {
+		return defaultCORSMaxAge
+	}
+	return c.CORS.MaxAgeSecond
+}
+
+// TOTPIssuer 함수는 인증 앱에 표시되는 서비스 이름을 반환한다.
+// 이름은 [totp] 섹션의 issuer 항목에서 설정한다.
+func (c *Configure) TOTPIssuer() string {
+	if c.TOTP.Issuer == "" {
+		return defaultTOTPIssuer
+	}
+	return c.TOTP.Issuer
+}
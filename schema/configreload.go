@@ -0,0 +1,344 @@
+package schema
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ConfigError 는 설정 파일을 읽거나 검사하는 중 발생한 오류이다. 잘못된 항목을 모두 담는다.
+type ConfigError struct {
+	File   string
+	Errors []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid config. file=%s, err=%s", e.File, strings.Join(e.Errors, "; "))
+}
+
+// ConfigChange 구조체는 설정을 다시 읽을 때 바뀐 항목 하나를 나타낸다.
+// 비밀번호 등 비밀 항목의 값은 가려진다.
+type ConfigChange struct {
+	Section string `json:"section"` // 섹션 이름. 하위 섹션은 jwtkey kid 형식
+	Item    string `json:"item"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+}
+
+func (c ConfigChange) String() string {
+	return fmt.Sprintf("[%s] %s: %q -> %q", c.Section, c.Item, c.Old, c.New)
+}
+
+// ConfigReload 구조체는 설정을 다시 읽은 결과이다.
+type ConfigReload struct {
+	Source          string         `json:"source"`                    // 다시 읽게 한 원인(admin, sighup, watch)
+	Changes         []ConfigChange `json:"changes"`                   // 바뀐 항목. 실패한 경우에는 바뀌려 했던 항목
+	RestartRequired []string       `json:"restartrequired,omitempty"` // 서버를 다시 시작해야 적용되는 항목
+}
+
+// Summary 함수는 감사 기록 등에 남길 짧은 요약을 만든다.
+func (r *ConfigReload) Summary() string {
+	items := make([]string, len(r.Changes))
+	for i, c := range r.Changes {
+		items[i] = fmt.Sprintf("[%s] %s", c.Section, c.Item)
+	}
+	return fmt.Sprintf("source=%s changes=%s", r.Source, strings.Join(items, ", "))
+}
+
+// ConfigSubscriber 는 구독한 섹션이 바뀌었을 때 불리는 함수이다.
+// 새 설정을 적용할 준비를 하고 실제로 적용할 함수를 리턴한다. 오류를 리턴하면 설정 변경 전체가 거부된다.
+// 리턴한 apply 함수는 현재 설정이 새 설정으로 교체된 직후에 불리며 실패해서는 안된다.
+type ConfigSubscriber func(old *Configure, c *Configure) (apply func(), err error)
+
+type configSubscription struct {
+	sections []string
+	f        ConfigSubscriber
+}
+
+var (
+	reloadMutex     sync.Mutex
+	subscriberMutex sync.Mutex
+	subscribers     []configSubscription
+)
+
+// restartConfigItems 는 서버를 다시 시작해야 적용되는 섹션 혹은 항목이다.
+var restartConfigItems = map[string]bool{
+	"server.bind":           true,
+	"database":              true,
+	"redis":                 true,
+	"throttle.store":        true,
+	"token.revocationstore": true,
+	"mail.workers":          true,
+}
+
+// OnConfigChange 함수는 sections 중 하나라도 바뀌었을 때 불릴 함수를 등록한다.
+// 섹션 이름은 설정 파일의 이름(예: jwt, jwtkey)을 사용한다.
+func OnConfigChange(f ConfigSubscriber, sections ...string) {
+	subscriberMutex.Lock()
+	defer subscriberMutex.Unlock()
+	subscribers = append(subscribers, configSubscription{sections, f})
+}
+
+// ReloadConfig 함수는 설정 파일을 다시 읽는다.
+//
+// 파일은 새 구조체로 읽어 검사한 뒤, 바뀐 섹션을 구독하는 함수가 모두 준비를 마쳤을 때에만 현재 설정과
+// 교체된다. 파일이 잘못되었거나 준비에 실패하면 현재 설정은 그대로 유지되며 *ConfigError를 리턴한다.
+// 이 경우에도 바뀌려 했던 항목을 결과로 리턴한다.
+func ReloadConfig(source string) (*ConfigReload, error) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	old := Config()
+	result := &ConfigReload{Source: source}
+
+	c, err := LoadConfig(conffile)
+	if c != nil {
+		result.Changes = diffConfig(old, c)
+	}
+	if err != nil {
+		return result, err
+	}
+	if len(result.Changes) == 0 {
+		return result, nil
+	}
+
+	changed := make(map[string]bool)
+	for _, change := range result.Changes {
+		changed[strings.SplitN(change.Section, " ", 2)[0]] = true
+		if restartConfigItems[change.Section] || restartConfigItems[change.Section+"."+change.Item] {
+			result.RestartRequired = append(result.RestartRequired, change.Section+"."+change.Item)
+		}
+	}
+
+	subscriberMutex.Lock()
+	subs := append([]configSubscription(nil), subscribers...)
+	subscriberMutex.Unlock()
+
+	var applies []func()
+	for _, s := range subs {
+		for _, section := range s.sections {
+			if !changed[section] {
+				continue
+			}
+			apply, err := s.f(old, c)
+			if err != nil {
+				return result, &ConfigError{File: conffile, Errors: []string{fmt.Sprintf("[%s] %v", section, err)}}
+			}
+			if apply != nil {
+				applies = append(applies, apply)
+			}
+			break
+		}
+	}
+
+	current.Store(c)
+	for _, apply := range applies {
+		apply()
+	}
+
+	for _, change := range result.Changes {
+		log.WithFields(log.Fields{
+			"source":  source,
+			"section": change.Section,
+			"item":    change.Item,
+			"old":     change.Old,
+			"new":     change.New,
+		}).Warn("CONFIG_CHANGED")
+	}
+	if len(result.RestartRequired) > 0 {
+		log.Warnf("config changes require restart. items=%s", strings.Join(result.RestartRequired, ", "))
+	}
+	return result, nil
+}
+
+// configName 함수는 구조체 필드에 해당하는 설정 파일의 이름을 리턴한다.
+func configName(f reflect.StructField) string {
+	if tag := f.Tag.Get("json"); tag != "" {
+		return strings.Split(tag, ",")[0]
+	}
+	return strings.ToLower(f.Name)
+}
+
+// diffConfig 함수는 두 설정을 비교하여 바뀐 항목을 섹션, 항목 순서로 리턴한다.
+func diffConfig(old *Configure, c *Configure) []ConfigChange {
+	var changes []ConfigChange
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(c).Elem()
+	t := ov.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			continue
+		}
+		section := configName(t.Field(i))
+		of, nf := ov.Field(i), nv.Field(i)
+		switch of.Kind() {
+		case reflect.Struct:
+			changes = append(changes, diffSection(old, c, section, of, nf)...)
+		case reflect.Map:
+			// [jwtkey "kid"] 처럼 이름이 있는 하위 섹션
+			names := make(map[string]bool)
+			for _, k := range append(of.MapKeys(), nf.MapKeys()...) {
+				names[k.String()] = true
+			}
+			var sorted []string
+			for name := range names {
+				sorted = append(sorted, name)
+			}
+			sort.Strings(sorted)
+			elem := t.Field(i).Type.Elem().Elem()
+			for _, name := range sorted {
+				key := reflect.ValueOf(name)
+				changes = append(changes, diffSection(old, c, section+" "+name,
+					subsection(of.MapIndex(key), elem), subsection(nf.MapIndex(key), elem))...)
+			}
+		}
+	}
+	return changes
+}
+
+// subsection 함수는 하위 섹션 맵의 값을 꺼낸다. 섹션이 없으면 빈 구조체를 리턴한다.
+func subsection(v reflect.Value, t reflect.Type) reflect.Value {
+	if !v.IsValid() || v.IsNil() {
+		return reflect.Zero(t)
+	}
+	return v.Elem()
+}
+
+// diffSection 함수는 한 섹션의 항목들을 비교한다. 어느 한 쪽에서라도 비밀 항목이면 값을 가린다.
+func diffSection(old *Configure, c *Configure, section string, o reflect.Value, n reflect.Value) []ConfigChange {
+	var changes []ConfigChange
+	t := o.Type()
+	for j := 0; j < t.NumField(); j++ {
+		item := configName(t.Field(j))
+		ostr, nstr := fmt.Sprint(o.Field(j).Interface()), fmt.Sprint(n.Field(j).Interface())
+		if ostr == nstr {
+			continue
+		}
+		if old.isSecret(section, item, t.Field(j)) || c.isSecret(section, item, t.Field(j)) {
+			ostr, nstr = redact(ostr), redact(nstr)
+		}
+		changes = append(changes, ConfigChange{section, item, ostr, nstr})
+	}
+	return changes
+}
+
+// redact 함수는 비밀 항목의 값을 가린다. 값이 설정되었는지 여부만 알 수 있다.
+func redact(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "******"
+}
+
+// reloadConfigFrom 함수는 관리자 요청 이외의 원인으로 설정을 다시 읽고 결과를 로그와 감사 기록으로 남긴다.
+func reloadConfigFrom(source string) {
+	result, err := ReloadConfig(source)
+	if err != nil {
+		log.Errorf("config reload rejected. source=%s, err=%v", source, err)
+		WriteAudit(&AuditEntry{Event: AuditConfigReload, Result: AuditResultFailure, Detail: err.Error()})
+		return
+	}
+	if len(result.Changes) == 0 {
+		log.Infof("config not changed. source=%s", source)
+		return
+	}
+	WriteAudit(&AuditEntry{Event: AuditConfigReload, Result: AuditResultSuccess, Detail: result.Summary()})
+}
+
+// configFileStamp 는 설정 파일이 바뀌었는지 비교하기 위한 파일의 수정 시각과 크기이다.
+type configFileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// configFileStamps 함수는 설정을 만들 때 읽은 모든 파일(include와 프로필 파일 포함)의 상태를 읽는다.
+// 읽을 수 없는 파일은 빈 상태로 기록하여 다시 생기면 바뀐 것으로 본다.
+func configFileStamps(c *Configure) map[string]configFileStamp {
+	stamps := make(map[string]configFileStamp)
+	for _, source := range c.Sources() {
+		if !strings.HasPrefix(source, "file:") {
+			continue
+		}
+		name := strings.TrimPrefix(source, "file:")
+		info, err := os.Stat(name)
+		if err != nil {
+			log.Warnf("config file stat failed. file=%s, err=%v", name, err)
+			stamps[name] = configFileStamp{}
+			continue
+		}
+		stamps[name] = configFileStamp{info.ModTime(), info.Size()}
+	}
+	return stamps
+}
+
+// configWatchTicker 함수는 설정의 확인 간격으로 동작하는 Ticker를 만든다. 간격이 0이면 nil을 리턴한다.
+func configWatchTicker(interval int64) *time.Ticker {
+	if interval <= 0 {
+		return nil
+	}
+	return time.NewTicker(time.Duration(interval) * time.Second)
+}
+
+// startConfigWatcher 함수는 SIGHUP 신호를 받거나 설정 파일이 바뀌면 설정을 다시 읽는 작업을 시작한다.
+// 파일은 [server] 섹션의 configwatchsecond 간격으로 설정을 만들 때 읽은 모든 파일의 수정 시각과 크기를
+// 비교하여 확인한다. 간격이 바뀌면 다시 읽은 설정의 간격으로 바로 확인 주기를 바꾼다.
+func startConfigWatcher() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	reset := make(chan int64, 1)
+	OnConfigChange(func(old *Configure, c *Configure) (func(), error) {
+		if old.ConfigWatchInterval() == c.ConfigWatchInterval() {
+			return nil, nil
+		}
+		interval := c.ConfigWatchInterval()
+		return func() {
+			// 아직 처리되지 않은 이전 간격은 버리고 마지막 간격만 전달한다.
+			select {
+			case <-reset:
+			default:
+			}
+			reset <- interval
+		}, nil
+	}, "server")
+
+	go func() {
+		last := configFileStamps(Config())
+		ticker := configWatchTicker(Config().ConfigWatchInterval())
+		for {
+			var tick <-chan time.Time
+			if ticker != nil {
+				tick = ticker.C
+			}
+
+			select {
+			case <-hup:
+				reloadConfigFrom("sighup")
+				last = configFileStamps(Config())
+			case interval := <-reset:
+				if ticker != nil {
+					ticker.Stop()
+				}
+				ticker = configWatchTicker(interval)
+				log.Infof("config watch interval changed. second=%d", interval)
+			case <-tick:
+				stamps := configFileStamps(Config())
+				if reflect.DeepEqual(stamps, last) {
+					continue
+				}
+				reloadConfigFrom("watch")
+				// 잘못된 파일을 계속 다시 읽지 않도록 결과와 관계 없이 현재 상태를 기록한다. 설정이 바뀌었으면
+				// 새로 추가된 include 파일도 확인하도록 새 설정의 파일 목록으로 상태를 읽는다.
+				last = configFileStamps(Config())
+			}
+		}
+	}()
+	log.Info("config watcher started.")
+}
@@ -0,0 +1,241 @@
+package schema
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"code.google.com/p/gcfg"
+)
+
+const (
+	configEnvPrefix       = "AUTH_" // 설정 항목을 덮어쓰는 환경 변수의 접두어
+	configEnvFileSuffix   = "_FILE" // 값 대신 값을 담은 파일의 경로를 지정하는 환경 변수의 접미어
+	maxConfigIncludeDepth = 8       // include 최대 깊이
+)
+
+// ConfigItem 구조체는 현재 적용된 설정 항목 하나를 나타낸다. 비밀 항목의 값은 가려진다.
+type ConfigItem struct {
+	Section string `json:"section"`
+	Item    string `json:"item"`
+	Value   string `json:"value"`
+	Env     string `json:"env"` // 이 항목을 덮어쓰는 환경 변수 이름
+}
+
+// configLoader 는 설정 파일을 차례로 겹쳐 읽는다.
+type configLoader struct {
+	c       *Configure
+	reading map[string]bool // include 순환을 찾기 위해 읽는 중인 파일
+	sources []string        // 읽은 순서대로 기록된 파일과 환경 변수
+}
+
+// readConfigLayers 함수는 설정 파일과 include, 프로필 파일, 환경 변수를 차례로 겹쳐 읽는다.
+// 나중에 읽은 값이 앞의 값을 덮어쓴다.
+//
+//  1. filename 파일
+//  2. [config] 섹션의 include에 쉼표로 나열된 파일(파일 기준 상대 경로, 다시 include 가능)
+//  3. 프로필이 지정되었다면 auth.<profile>.cfg 처럼 파일 이름에 프로필을 붙인 파일과 그 include
+//  4. AUTH_<SECTION>_<ITEM> 환경 변수 혹은 AUTH_<SECTION>_<ITEM>_FILE 환경 변수가 가리키는 파일의 내용
+//
+// 프로필은 AUTH_CONFIG_PROFILE 환경 변수 혹은 [config] 섹션의 profile 항목으로 지정한다.
+func readConfigLayers(filename string) (*Configure, error) {
+	l := &configLoader{c: new(Configure), reading: make(map[string]bool)}
+	if err := l.readFile(filename, 0); err != nil {
+		return nil, err
+	}
+
+	profile := os.Getenv(configEnvName("config", "profile"))
+	if profile == "" {
+		profile = l.c.Config.Profile
+	}
+	if profile != "" {
+		ext := filepath.Ext(filename)
+		if err := l.readFile(strings.TrimSuffix(filename, ext)+"."+profile+ext, 0); err != nil {
+			return nil, err
+		}
+		l.c.Config.Profile = profile
+	}
+
+	if err := l.readEnv(); err != nil {
+		return nil, err
+	}
+	l.c.sources = l.sources
+	return l.c, nil
+}
+
+// readFile 함수는 설정 파일 하나를 현재까지 읽은 설정 위에 덮어 읽고 그 파일의 include를 차례로 읽는다.
+func (l *configLoader) readFile(name string, depth int) error {
+	if depth > maxConfigIncludeDepth {
+		return fmt.Errorf("config include too deep. file=%s", name)
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	if l.reading[abs] {
+		return fmt.Errorf("config include cycle. file=%s", name)
+	}
+	l.reading[abs] = true
+	defer delete(l.reading, abs)
+
+	// include는 파일마다 따로 지정되므로 이 파일의 값만 얻도록 비워 두고 읽는다.
+	include := l.c.Config.Include
+	l.c.Config.Include = ""
+	if err := gcfg.ReadFileInto(l.c, name); err != nil {
+		return err
+	}
+	includes := splitList(l.c.Config.Include)
+	l.c.Config.Include = include
+	l.sources = append(l.sources, "file:"+name)
+
+	for _, inc := range includes {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(filepath.Dir(name), inc)
+		}
+		if err := l.readFile(inc, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readEnv 함수는 환경 변수로 설정 항목을 덮어쓴다. AUTH_SMTP_PASSWORD 처럼 값을 직접 지정하거나
+// AUTH_SMTP_PASSWORD_FILE 처럼 값을 담은 파일(컨테이너의 secret 등)을 지정할 수 있으며 둘을 함께
+// 지정할 수는 없다. 파일 끝의 줄바꿈은 제거된다.
+// [jwtkey "kid"] 같은 하위 섹션은 설정 파일에 있는 섹션만 AUTH_JWTKEY_<KID>_<ITEM> 으로 덮어쓸 수 있다.
+func (l *configLoader) readEnv() error {
+	var errs []string
+	walkConfig(l.c, func(section string, item string, field reflect.StructField, v reflect.Value) {
+		name := configEnvName(section, item)
+		value, ok := os.LookupEnv(name)
+		source := "env:" + name
+		if path, fok := os.LookupEnv(name + configEnvFileSuffix); fok {
+			if ok {
+				errs = append(errs, fmt.Sprintf("both %s and %s are set", name, name+configEnvFileSuffix))
+				return
+			}
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", name+configEnvFileSuffix, err))
+				return
+			}
+			value, ok = strings.TrimRight(string(b), "\r\n"), true
+			source = "env:" + name + configEnvFileSuffix
+		}
+		if !ok {
+			return
+		}
+		if err := setConfigValue(v, value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			return
+		}
+		l.sources = append(l.sources, source)
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config environment. err=%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// configEnvName 함수는 설정 항목을 덮어쓰는 환경 변수 이름을 만든다. 영문자와 숫자 이외의 문자는 _로 바뀐다.
+// 예: [smtp] password -> AUTH_SMTP_PASSWORD, [jwtkey "2015-06"] privatekeyfile -> AUTH_JWTKEY_2015_06_PRIVATEKEYFILE
+func configEnvName(section string, item string) string {
+	name := strings.ToUpper(section + "_" + item)
+	return configEnvPrefix + strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// setConfigValue 함수는 문자열로 주어진 값을 설정 항목의 타입에 맞게 저장한다.
+func setConfigValue(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Kind())
+	}
+	return nil
+}
+
+// walkConfig 함수는 설정의 모든 항목을 섹션 순서대로 방문한다. 하위 섹션은 이름 순서로 방문한다.
+// section은 표시할 섹션 이름이고 field는 항목의 태그를 확인할 때 사용한다.
+func walkConfig(c *Configure, f func(section string, item string, field reflect.StructField, v reflect.Value)) {
+	cv := reflect.ValueOf(c).Elem()
+	t := cv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			continue
+		}
+		name := configName(t.Field(i))
+		sv := cv.Field(i)
+		switch sv.Kind() {
+		case reflect.Struct:
+			walkSection(name, sv, f)
+		case reflect.Map:
+			var keys []string
+			for _, k := range sv.MapKeys() {
+				keys = append(keys, k.String())
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				if sub := sv.MapIndex(reflect.ValueOf(k)); !sub.IsNil() {
+					walkSection(name+" "+k, sub.Elem(), f)
+				}
+			}
+		}
+	}
+}
+
+func walkSection(section string, sv reflect.Value, f func(string, string, reflect.StructField, reflect.Value)) {
+	t := sv.Type()
+	for j := 0; j < t.NumField(); j++ {
+		f(section, configName(t.Field(j)), t.Field(j), sv.Field(j))
+	}
+}
+
+// Items 함수는 현재 적용된 설정 항목의 목록을 리턴한다. 비밀 항목의 값은 가려진다.
+func (c *Configure) Items() []ConfigItem {
+	var items []ConfigItem
+	walkConfig(c, func(section string, item string, field reflect.StructField, v reflect.Value) {
+		value := fmt.Sprint(v.Interface())
+		if c.isSecret(section, item, field) {
+			value = redact(value)
+		}
+		items = append(items, ConfigItem{section, item, value, configEnvName(section, item)})
+	})
+	return items
+}
+
+// isSecret 함수는 로그와 응답에 값을 남기지 않을 항목인지 확인한다. secret:"true" 태그가 붙은 항목과
+// AUTH_<SECTION>_<ITEM>_FILE 환경 변수로 파일(컨테이너의 secret 등)에서 값을 읽은 항목은 모두 비밀 항목이다.
+func (c *Configure) isSecret(section string, item string, field reflect.StructField) bool {
+	if field.Tag.Get("secret") == "true" {
+		return true
+	}
+	source := "env:" + configEnvName(section, item) + configEnvFileSuffix
+	for _, s := range c.sources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// Sources 함수는 설정을 만들 때 읽은 파일과 환경 변수의 목록을 읽은 순서대로 리턴한다.
+func (c *Configure) Sources() []string {
+	return c.sources
+}
@@ -0,0 +1,37 @@
+package schema
+
+import (
+	"testing"
+)
+
+func TestConfigSecretItems(t *testing.T) {
+	old := new(Configure)
+	c := new(Configure)
+	c.SMTP.Password = "smtp-password"
+	c.SMTP.Server = "smtp.example.com"
+	c.TOTP.Issuer = "from-file"
+	c.sources = []string{"file:auth.cfg", "env:" + configEnvName("totp", "issuer") + configEnvFileSuffix}
+
+	values := make(map[string]string)
+	for _, item := range c.Items() {
+		values[item.Section+"."+item.Item] = item.Value
+	}
+	for name, want := range map[string]string{
+		"smtp.password": "******", // secret 태그
+		"totp.issuer":   "******", // _FILE 환경 변수
+		"smtp.server":   "smtp.example.com",
+	} {
+		if values[name] != want {
+			t.Errorf("Items %s = %q, want %q", name, values[name], want)
+		}
+	}
+
+	for _, change := range diffConfig(old, c) {
+		if change.Section == "smtp" && change.Item == "server" {
+			continue
+		}
+		if change.New != "******" {
+			t.Errorf("diffConfig %s.%s = %q, want redacted", change.Section, change.Item, change.New)
+		}
+	}
+}
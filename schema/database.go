@@ -1,37 +1,81 @@
 package schema
 
 import (
+	"bytes"
 	"database/sql"
+	"database/sql/driver"
+	"strconv"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
 	"gopkg.in/gorp.v1"
 )
 
 // Databases 구조체는 데이터베이스의 묶음 객체이다.
+// 사용자와 일정은 Users, Todos 저장소를 통해서만 접근하며 그 이외의 테이블은 Auth를 사용한다.
 type Databases struct {
-	Auth *gorp.DbMap
+	Auth  *gorp.DbMap
+	Users UserStore
+	Todos TodoStore
 }
 
+// [database] 섹션의 driver 항목에 사용할 수 있는 데이터베이스 종류
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite3"
+	DriverMemory   = "memory" // 사용자와 일정은 메모리에, 나머지 테이블은 메모리 SQLite에 저장한다.
+
+	// postgresRebindDriver 는 쿼리의 ? 를 $1, $2... 로 바꾸어 PostgreSQL 드라이버에 넘기는 드라이버 이름이다.
+	postgresRebindDriver = "postgres-rebind"
+)
+
 var (
 	dbs Databases
 )
 
-// IsDuplicated 함수는 데이터베이스 Insert 쿼리 결과 error 를 확인하여 중복오류가 발생 했는지
-// 여부를 리턴한다.
+func init() {
+	sql.Register(postgresRebindDriver, rebindDriver{&pq.Driver{}})
+}
+
+// IsDuplicated 함수는 데이터베이스 Insert, Update 쿼리 결과 error 를 확인하여 중복오류가 발생 했는지
+// 여부를 리턴한다. 저장소의 ErrDuplicated와 각 데이터베이스 드라이버의 unique 제약 오류를 모두 확인한다.
 func (d *Databases) IsDuplicated(err error) bool {
-	if err != nil {
-		if err.(*mysql.MySQLError).Number == 1062 {
-			return true
-		}
+	return isDuplicated(err)
+}
+
+func isDuplicated(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return false
+	case *mysql.MySQLError:
+		return e.Number == 1062
+	case *pq.Error:
+		return e.Code == "23505" // unique_violation
+	case sqlite3.Error:
+		return e.ExtendedCode == sqlite3.ErrConstraintUnique || e.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
 	}
-	return false
+	return err == ErrDuplicated
 }
 
 func mustInitDatabase(conf *Configure) {
-	auth := mustInitAuthDatabase(conf.Database.Auth)
+	driver := conf.DatabaseDriver()
+	auth := mustInitAuthDatabase(driver, conf.Database.Auth, conf.IsAutoMigrate())
 	dbs = Databases{Auth: auth}
-	log.Info("databases initialized.")
+	if driver == DriverMemory {
+		store := newMemoryStore(auth)
+		dbs.Users, dbs.Todos = store, store
+	} else {
+		store := &sqlStore{dbmap: auth}
+		if driver == DriverSQLite {
+			// SQLite의 like는 기본 이스케이프 문자가 없다.
+			store.likeEscape = ` escape '\'`
+		}
+		dbs.Users, dbs.Todos = store, store
+	}
+	log.Infof("databases initialized. driver=%s", driver)
 }
 
 // Database 함수는 현재 생성된 Databases 구조체의 인스턴스를 반환한다.
@@ -39,25 +83,98 @@ func Database() *Databases {
 	return &dbs
 }
 
-func mustInitAuthDatabase(dsn string) *gorp.DbMap {
-	db, err := sql.Open("mysql", dsn)
+// openDatabase 함수는 driver에 맞게 데이터베이스를 열고 gorp 방언을 리턴한다.
+func openDatabase(driver string, dsn string) (*sql.DB, gorp.Dialect, error) {
+	switch driver {
+	case DriverPostgres:
+		db, err := sql.Open(postgresRebindDriver, dsn)
+		return db, gorp.PostgresDialect{}, err
+	case DriverSQLite, DriverMemory:
+		if driver == DriverMemory {
+			dsn = "file::memory:?cache=shared"
+		}
+		db, err := sql.Open("sqlite3", dsn)
+		if err == nil {
+			// SQLite는 동시에 하나의 연결만 쓸 수 있으며 메모리 데이터베이스는 연결이 모두 닫히면 사라진다.
+			db.SetMaxOpenConns(1)
+			db.SetMaxIdleConns(1)
+		}
+		return db, gorp.SqliteDialect{}, err
+	default:
+		db, err := sql.Open("mysql", dsn)
+		return db, gorp.MySQLDialect{Engine: "InnoDB", Encoding: "UTF8"}, err
+	}
+}
+
+func mustInitAuthDatabase(driver string, dsn string, autoMigrate bool) *gorp.DbMap {
+	db, dialect, err := openDatabase(driver, dsn)
 	if err != nil {
-		log.Fatalf("db open error. dsn=%s, err=%v", dsn, err)
+		log.Fatalf("db open error. driver=%s, err=%v", driver, err)
+	}
+	// sqlite3, memory 드라이버는 cgo 없이 빌드되었다면 여기에서 실패한다.
+	if err := db.Ping(); err != nil {
+		log.Fatalf("db connect error. driver=%s, err=%v", driver, err)
 	}
 
-	dbmap := gorp.DbMap{
-		Db: db, Dialect: gorp.MySQLDialect{
-			Engine:   "InnoDB",
-			Encoding: "UTF8"}}
+	dbmap := gorp.DbMap{Db: db, Dialect: dialect}
 
-	// 테이블 생성
+	// 테이블 매핑. 테이블은 migrations.go의 마이그레이션으로 생성된다.
 	createUserTable(&dbmap)
 	createTodoListTable(&dbmap)
+	createRefreshTokenTable(&dbmap)
+	createPasswordResetTable(&dbmap)
+	createPasswordHistoryTable(&dbmap)
+	createTOTPTable(&dbmap)
+	createSessionTable(&dbmap)
+	createEmailChangeTable(&dbmap)
+	createRoleTables(&dbmap)
+	createAuditTable(&dbmap)
+	createOutboxTable(&dbmap)
+
+	mustMigrate(db, driver, autoMigrate)
+
+	// 기본 역할 생성
+	mustSeedRoles(&dbmap)
+
+	return &dbmap
+}
+
+// rebindDriver 는 MySQL 형식(?)의 쿼리 인자를 PostgreSQL 형식($1, $2...)으로 바꾸어 주는 드라이버이다.
+// 모든 쿼리를 데이터베이스 종류와 관계 없이 같은 형식으로 작성할 수 있게 한다.
+type rebindDriver struct {
+	driver.Driver
+}
 
-	err = dbmap.CreateTablesIfNotExists()
+func (d rebindDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
 	if err != nil {
-		log.Fatalf("table create error. dsn=%s, err=%v", dsn, err)
+		return nil, err
 	}
+	return rebindConn{conn}, nil
+}
 
-	return &dbmap
+type rebindConn struct {
+	driver.Conn
+}
+
+func (c rebindConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(rebind(query))
+}
+
+// rebind 함수는 문자열 상수 밖의 ? 를 $1, $2... 로 바꾼다.
+func rebind(query string) string {
+	var b bytes.Buffer
+	n, quoted := 0, false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
 }
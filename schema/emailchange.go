@@ -0,0 +1,139 @@
+package schema
+
+import (
+	"encoding/gob"
+	"errors"
+
+	"gopkg.in/gorp.v1"
+	"jsproj.com/koo/gosari/utils"
+)
+
+// EmailChange 객체는 이메일(아이디) 변경 요청 스키마 객체이다. 여기에서 정의된 형태로 데이터베이스
+// 테이블이 작성된다. 따라서 이 코드를 바꾸는 경우 반드시 DB 마이그레이션이 필요하다.
+//
+// 확인 토큰은 새 이메일 주소로만 전달되며 데이터베이스에는 해시만 저장된다. 사용자의 ID는 새 주소에서
+// 링크를 열어 확인한 뒤에만 바뀐다. 토큰은 한 번만 사용할 수 있고 [changeemail] 섹션의
+// expireminute 이후에는 만료된다.
+type EmailChange struct {
+	ECID    int64  `db:"ecid" json:"-"`
+	UID     int64  `db:"uid" json:"uid"`         // 변경 대상 사용자
+	NewID   string `db:"newid" json:"newid"`     // 새 이메일 주소
+	Hash    string `db:"hash" json:"-"`          // 토큰의 sha256 해시
+	Created int64  `db:"created" json:"created"` // 요청 시각
+	Expire  int64  `db:"expire" json:"expire"`   // 만료 시각
+	Used    int64  `db:"used" json:"used"`       // 사용한 시각. 0이면 아직 사용 전
+}
+
+// 이메일 변경 처리 중에 발생하는 오류
+var (
+	ErrEmailChangeInvalid = errors.New("invalid email change token")
+	ErrEmailDuplicated    = errors.New("email already exists")
+)
+
+// CreateEmailChange 함수는 사용자의 이메일을 newID로 바꾸는 확인 토큰을 발급한다.
+// 이전에 발급되어 아직 사용하지 않은 토큰은 모두 무효화 된다.
+func CreateEmailChange(user *User, newID string) (string, error) {
+	db := Database()
+	now := utils.ServerTime()
+
+	if _, err := db.Auth.Exec("update emailchanges set used=? where uid=? and used=0", now, user.UID); err != nil {
+		return "", err
+	}
+
+	token, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	ec := &EmailChange{
+		UID:     user.UID,
+		NewID:   newID,
+		Hash:    hashSecretToken(token),
+		Created: now,
+		Expire:  now + Config().EmailChangeExpire(),
+	}
+	if err := db.Auth.Insert(ec); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// FindEmailChange 함수는 사용 가능한 확인 토큰을 찾는다. 토큰을 사용 처리하지는 않는다.
+func FindEmailChange(token string) (*EmailChange, error) {
+	db := Database()
+	var ec EmailChange
+	err := db.Auth.SelectOne(&ec, "select * from emailchanges where hash=?", hashSecretToken(token))
+	if err != nil {
+		return nil, ErrEmailChangeInvalid
+	}
+	if ec.Used != 0 || ec.Expire < utils.ServerTime() {
+		return nil, ErrEmailChangeInvalid
+	}
+	return &ec, nil
+}
+
+// ConfirmEmailChange 함수는 확인 토큰을 사용 처리하고 사용자의 ID를 새 이메일로 바꾼다.
+// 변경된 사용자와 이전 이메일 주소를 리턴한다. 그 사이에 다른 사용자가 같은 이메일을 사용하게 되었다면
+// 토큰을 사용 처리하지 않고 ErrEmailDuplicated를 리턴한다. 토큰 사용과 ID 변경은 하나의 트랜잭션으로
+// 처리되며, 이전 ID가 담긴 토큰은 모두 폐기된다.
+func ConfirmEmailChange(ec *EmailChange) (*User, string, error) {
+	db := Database()
+
+	user, err := LoadUserFromUID(ec.UID)
+	if err == ErrNotFound {
+		return nil, "", ErrEmailChangeInvalid
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	// 토큰을 사용 처리하기 전에 중복을 확인하여 다른 사용자가 이메일을 놓으면 다시 사용할 수 있게 한다.
+	if other, err := LoadUserFromID(ec.NewID); err == nil && other.UID != user.UID {
+		return nil, "", ErrEmailDuplicated
+	} else if err != nil && err != ErrNotFound {
+		return nil, "", err
+	}
+
+	oldID := user.ID
+	changed := *user
+	changed.ID = ec.NewID
+	// users 테이블의 id 컬럼은 unique이므로 확인과 변경 사이에 가입한 사용자도 여기에서 걸린다.
+	err = db.Users.UpdateUserWith(&changed, func(tx gorp.SqlExecutor) error {
+		return consumeEmailChange(tx, ec.ECID)
+	})
+	if err == ErrDuplicated {
+		return nil, "", ErrEmailDuplicated
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	// 액세스 토큰에는 ID가 들어 있으므로 이전 ID로 발급된 토큰을 모두 폐기한다.
+	if err := RevokeTokensIssuedBefore(changed.UID, ServerTimeMilli()); err != nil {
+		return nil, "", err
+	}
+	return &changed, oldID, nil
+}
+
+// consumeEmailChange 함수는 확인 토큰을 사용 처리한다. 같은 토큰으로 동시에 요청이 들어오더라도
+// 하나만 성공하며 나머지는 ErrEmailChangeInvalid를 리턴한다.
+func consumeEmailChange(exec gorp.SqlExecutor, ecid int64) error {
+	now := utils.ServerTime()
+	result, err := exec.Exec("update emailchanges set used=? where ecid=? and used=0 and expire>=?", now, ecid, now)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n != 1 {
+		return ErrEmailChangeInvalid
+	}
+	return nil
+}
+
+func createEmailChangeTable(dbmap *gorp.DbMap) {
+	gob.Register(&EmailChange{})
+	table := dbmap.AddTableWithName(EmailChange{}, "emailchanges").SetKeys(true, "ECID")
+	table.ColMap("NewID").SetMaxSize(IDMaxSize)
+	table.ColMap("Hash").SetMaxSize(SecretHashSize)
+	table.ColMap("Hash").SetUnique(true)
+}
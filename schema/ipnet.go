@@ -0,0 +1,142 @@
+package schema
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseCIDRList 함수는 쉼표로 구분된 IPv4/IPv6 CIDR 목록을 읽는다.
+// 192.168.0.15 처럼 프리픽스 길이가 없는 주소는 해당 주소 하나(/32, /128)로 취급한다.
+func ParseCIDRList(s string) ([]*net.IPNet, error) {
+	var list []*net.IPNet
+	for _, element := range strings.Split(s, ",") {
+		element = strings.TrimSpace(element)
+		if element == "" {
+			continue
+		}
+		if !strings.Contains(element, "/") {
+			ip := net.ParseIP(element)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip address. ip=%s", element)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				element += "/32"
+			} else {
+				element += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(element)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, ipnet)
+	}
+	return list, nil
+}
+
+// IPInList 함수는 ip가 CIDR 목록에 포함 되었는지 여부를 리턴한다.
+// IPv4-mapped IPv6 주소(::ffff:1.2.3.4)는 IPv4 주소로 비교한다. 형식이 잘못된 ip는 포함되지 않는다.
+func IPInList(ip string, list []*net.IPNet) bool {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return false
+	}
+	if ip4 := parsed.To4(); ip4 != nil {
+		parsed = ip4
+	}
+	for _, ipnet := range list {
+		if ipnet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeIP 함수는 X-Forwarded-For, Forwarded 헤더의 주소 항목에서 IP 주소를 꺼낸다.
+// 따옴표, IPv6의 대괄호와 포트 번호를 제거하며, IP 주소가 아니면(unknown, 숨겨진 식별자 등) 빈 문자열을 리턴한다.
+func normalizeIP(s string) string {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if strings.HasPrefix(s, "[") {
+		// [2001:db8::1]:4711
+		if end := strings.Index(s, "]"); end > 0 {
+			s = s[1:end]
+		}
+	} else if strings.Count(s, ":") == 1 {
+		// 192.0.2.60:4711
+		s = s[:strings.Index(s, ":")]
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// forwardedChain 함수는 프록시가 추가한 클라이언트 주소 목록을 요청 순서(왼쪽이 클라이언트)대로 읽는다.
+// RFC 7239 Forwarded 헤더가 있으면 for 항목을 사용하고, 없으면 X-Forwarded-For 헤더를 사용한다.
+// 여러 줄로 나뉜 헤더는 순서대로 합친다.
+func forwardedChain(header http.Header) []string {
+	var chain []string
+	if values := header["Forwarded"]; len(values) > 0 {
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					chain = append(chain, kv[1])
+				}
+			}
+		}
+		return chain
+	}
+	for _, element := range strings.Split(strings.Join(header["X-Forwarded-For"], ","), ",") {
+		if strings.TrimSpace(element) != "" {
+			chain = append(chain, element)
+		}
+	}
+	return chain
+}
+
+// ClientIP 함수는 요청을 보낸 클라이언트의 IP 주소를 찾는다.
+//
+// 직접 연결한 상대(RemoteAddr)가 [server] 섹션의 trustedproxies에 포함된 경우에만 프록시 헤더를 믿는다.
+// 프록시 헤더는 오른쪽(서버에 가까운 쪽)부터 읽어 신뢰하는 프록시가 아닌 첫 주소를 클라이언트로 본다.
+// 클라이언트가 임의로 넣은 왼쪽의 주소는 신뢰하는 프록시가 추가한 주소가 아니므로 사용되지 않는다.
+// [server] 섹션의 realip가 true이면 신뢰하는 프록시가 보낸 X-Real-IP 헤더를 먼저 사용한다.
+// 헤더를 매번 다시 읽으므로 한 요청에서는 handlers.GetIP로 미리 찾아 둔 값을 사용한다.
+func ClientIP(r *http.Request) string {
+	conf := Config()
+
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	peer = normalizeIP(peer)
+
+	trusted := conf.trustedProxies
+	if !IPInList(peer, trusted) {
+		return peer
+	}
+
+	if conf.IsUseRealIP() {
+		if ip := normalizeIP(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+	}
+
+	client := peer
+	chain := forwardedChain(r.Header)
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := normalizeIP(chain[i])
+		if ip == "" {
+			// 형식이 잘못된 주소 이후는 믿을 수 없으므로 마지막으로 확인한 주소를 사용한다.
+			break
+		}
+		client = ip
+		if !IPInList(ip, trusted) {
+			break
+		}
+	}
+	return client
+}
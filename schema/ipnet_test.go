@@ -0,0 +1,35 @@
+package schema
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	conf := new(Configure)
+	conf.Server.TrustedProxies = "10.0.0.0/8, ::1"
+	conf.parseIPLists()
+	current.Store(conf)
+
+	cases := []struct {
+		name   string
+		remote string
+		xff    string
+		want   string
+	}{
+		{"direct", "203.0.113.7:4711", "", "203.0.113.7"},
+		{"untrusted peer ignores header", "203.0.113.7:4711", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:4711", "198.51.100.1", "198.51.100.1"},
+		{"spoofed left entry", "10.0.0.2:4711", "192.0.2.1, 198.51.100.1", "198.51.100.1"},
+		{"proxy chain", "[::1]:4711", "198.51.100.1, 10.0.0.3", "198.51.100.1"},
+	}
+	for _, c := range cases {
+		r := &http.Request{RemoteAddr: c.remote, Header: http.Header{}}
+		if c.xff != "" {
+			r.Header.Set("X-Forwarded-For", c.xff)
+		}
+		if got := ClientIP(r); got != c.want {
+			t.Errorf("%s: ClientIP = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
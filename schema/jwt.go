@@ -1,83 +1,345 @@
 package schema
 
 import (
-	"errors"
-	"io/ioutil"
+	"fmt"
+	"math"
 	"net/http"
 
 	log "github.com/Sirupsen/logrus"
 	jwt "github.com/dgrijalva/jwt-go"
+	"jsproj.com/koo/gosari/crypto"
 	"jsproj.com/koo/gosari/utils"
 )
 
+// TokenErrorKind 는 토큰 검증 실패 사유의 종류이다.
+type TokenErrorKind int
+
+// 토큰 검증 실패 사유
 const (
-	tokenExpireHour = 24
+	TokenMissing          TokenErrorKind = iota + 1 // 요청에 토큰이 없음
+	TokenMalformed                                  // 토큰 형식이 잘못됨
+	TokenAlgorithmInvalid                           // 설정된 서명 알고리즘이 아님
+	TokenUnverifiable                               // 검증할 키를 찾을 수 없음
+	TokenSignatureInvalid                           // 서명이 올바르지 않음
+	TokenClaimInvalid                               // 클레임이 없거나 형식이 잘못됨
+	TokenExpired                                    // 만료됨(exp)
+	TokenNotValidYet                                // 아직 사용할 수 없음(nbf)
+	TokenIssuedInFuture                             // 발급 시각이 미래임(iat)
+	TokenIssuerInvalid                              // 발급자가 다름(iss)
+	TokenAudienceInvalid                            // 대상이 다름(aud)
+	TokenRevoked                                    // 폐기됨
 )
 
-var (
-	privateKey []byte
-	publicKey  []byte
-)
+var tokenErrorReasons = map[TokenErrorKind]string{
+	TokenMissing:          "no token in request",
+	TokenMalformed:        "malformed token",
+	TokenAlgorithmInvalid: "unexpected signing algorithm",
+	TokenUnverifiable:     "unknown signing key",
+	TokenSignatureInvalid: "invalid signature",
+	TokenClaimInvalid:     "invalid claim",
+	TokenExpired:          "token is expired",
+	TokenNotValidYet:      "token is not valid yet",
+	TokenIssuedInFuture:   "token is issued in the future",
+	TokenIssuerInvalid:    "invalid issuer",
+	TokenAudienceInvalid:  "invalid audience",
+	TokenRevoked:          "token is revoked",
+}
 
-func mustInitJWT(conf *Configure) {
-	var err error
-	privateKey, err = ioutil.ReadFile(conf.PrivateKeyFile())
-	if err != nil {
-		log.Fatalf("invalid private key. err=%v", err)
-	}
+// TokenError 구조체는 토큰 검증 실패 시 ParseToken이 리턴하는 오류이다.
+type TokenError struct {
+	Kind  TokenErrorKind // 실패 사유
+	Claim string         // 문제가 된 클레임 이름(클레임과 관계 없는 오류는 빈 문자열)
+	Err   error          // jwt 라이브러리 등에서 발생한 원래 오류
+}
 
-	publicKey, err = ioutil.ReadFile(conf.PublicKeyFile())
-	if err != nil {
-		log.Fatalf("invalid public key. err=%v", err)
+func (e *TokenError) Error() string {
+	msg := tokenErrorReasons[e.Kind]
+	if e.Claim != "" {
+		msg = fmt.Sprintf("%s. claim=%s", msg, e.Claim)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s. err=%v", msg, e.Err)
 	}
+	return msg
+}
+
+// IsTokenExpired 함수는 err이 토큰 만료로 인한 오류인지 여부를 리턴한다.
+func IsTokenExpired(err error) bool {
+	tokenErr, ok := err.(*TokenError)
+	return ok && tokenErr.Kind == TokenExpired
+}
+
+// 토큰의 scope 클레임 값. scope 클레임이 없는 이전 형식의 토큰은 ScopeAll로 취급한다.
+const (
+	ScopeAll            = "all"        // 모든 api를 호출할 수 있음
+	ScopeChangePassword = "changepass" // 비밀번호 변경만 가능(비밀번호 변경이 강제된 사용자)
+	ScopeSecondFactor   = "mfa"        // 2단계 인증 확인에만 사용(비밀번호 확인을 마친 로그인 도전 토큰)
+)
+
+// challengeExpireSecond 는 2단계 인증 도전 토큰의 유효 기간(초)이다.
+const challengeExpireSecond = 300
+
+// TokenClaims 구조체는 jwt 토큰에서 읽어온 클레임 정보이다.
+type TokenClaims struct {
+	UID      int64  // 사용자 uid
+	JTI      string // 토큰 고유 아이디(폐기 목록의 키로 사용된다)
+	IssuedAt int64  // 발급 시각
+	// 밀리초 단위 발급 시각. iat가 정수인 이전 토큰은 IssuedAt * 1000이다.
+	IssuedAtMilli int64
+	Expire        int64  // 만료 시각
+	Scope         string // 토큰으로 호출할 수 있는 api 범위
+	SID           string // 토큰이 속한 세션 아이디(도전 토큰은 빈 문자열)
 }
 
 // IssueToken is issue a jason web token(jwt).
 // It need rsa key fo generate the token.
-//  openssl genrsa -out mykey.rsa 1024
-//  openssl rsa -in mykey.rsa -pubout > mykey.rsa.pub
-func IssueToken(user *User) (string, error) {
+//
+//	openssl genrsa -out mykey.rsa 1024
+//	openssl rsa -in mykey.rsa -pubout > mykey.rsa.pub
+//
+// The token is signed with the active key of the key ring and has its kid header.
+// Its scope is decided by the user's state(see User.TokenScope) and
+// it belongs to the session sid.
+func IssueToken(user *User, sid string) (string, error) {
+	return signToken(user, user.TokenScope(), Config().AccessTokenExpire(), sid)
+}
+
+// IssueChallengeToken 함수는 비밀번호 확인을 마치고 2단계 인증을 기다리는 사용자에게 발급하는
+// 도전 토큰을 만든다. 도전 토큰은 ScopeSecondFactor scope를 가지므로 다른 api에는 사용할 수 없다.
+func IssueChallengeToken(user *User) (string, error) {
+	return signToken(user, ScopeSecondFactor, challengeExpireSecond, "")
+}
+
+// signToken 함수는 키 링의 현재 서명 키로 scope와 유효 기간(초)을 가진 토큰을 만든다.
+// sid가 빈 문자열이 아니면 sid 클레임에 세션 아이디를 넣는다.
+func signToken(user *User, scope string, expire int64, sid string) (string, error) {
+	conf := Config()
+
+	jti, err := crypto.NewUUID()
+	if err != nil {
+		return "", err
+	}
+
+	nowMilli := ServerTimeMilli()
+	now := nowMilli / 1000
+
+	key := currentKeyRing().active
+
 	// Create the token
-	token := jwt.New(jwt.GetSigningMethod("RS256"))
+	token := jwt.New(jwt.GetSigningMethod(conf.JWTAlgorithm()))
+	token.Header["kid"] = key.kid
 	// Set some claims
 	token.Claims["uid"] = user.UID
-	token.Claims["exp"] = utils.ServerTime() + (3600 * tokenExpireHour)
+	token.Claims["jti"] = jti
+	// 같은 초에 시각으로 폐기된 토큰과 구분할 수 있도록 iat는 밀리초까지 기록한다.
+	token.Claims["iat"] = float64(nowMilli) / 1000
+	token.Claims["nbf"] = now
+	token.Claims["exp"] = now + expire
+	token.Claims["scope"] = scope
+	if sid != "" {
+		token.Claims["sid"] = sid
+	}
+	if conf.JWT.Issuer != "" {
+		token.Claims["iss"] = conf.JWT.Issuer
+	}
+	if conf.JWT.Audience != "" {
+		token.Claims["aud"] = conf.JWT.Audience
+	}
 	// Sign and get the complete encoded token as a string
-	tokenString, err := token.SignedString(privateKey)
+	tokenString, err := token.SignedString(key.private)
 	if err != nil {
 		return "", err
 	}
 	return tokenString, nil
 }
 
-// ParseToken implements the jwt token parser.
-// It returns user unique id(uid), expire time(exp) and error.
-func ParseToken(r *http.Request) (int64, float64, error) {
-	token, err := jwt.ParseFromRequest(r, func(token *jwt.Token) (interface{}, error) {
-		return publicKey, nil
-	})
+// verificationKey 함수는 토큰 헤더의 alg와 kid를 확인하여 검증에 사용할 공개키를 찾는 함수를 만든다.
+// 키를 찾지 못한 사유는 keyErr에 기록된다.
+func verificationKey(keyErr *error) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		// 토큰이 주장하는 알고리즘을 믿지 않고 설정된 알고리즘만 허용한다.
+		if token.Method == nil || token.Method.Alg() != Config().JWTAlgorithm() {
+			*keyErr = &TokenError{Kind: TokenAlgorithmInvalid}
+			return nil, *keyErr
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, err := currentKeyRing().publicKey(kid)
+		if err != nil {
+			*keyErr = &TokenError{Kind: TokenUnverifiable, Err: err}
+			return nil, *keyErr
+		}
+		return key, nil
+	}
+}
+
+// numericClaim 함수는 숫자 형식의 클레임을 읽는다.
+// required가 false이고 클레임이 없으면 0을 리턴한다.
+func numericClaim(claims map[string]interface{}, name string, required bool) (int64, error) {
+	v, ok := claims[name]
+	if !ok {
+		if required {
+			return 0, &TokenError{Kind: TokenClaimInvalid, Claim: name}
+		}
+		return 0, nil
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return 0, &TokenError{Kind: TokenClaimInvalid, Claim: name}
+	}
+	return int64(f), nil
+}
+
+// hasAudience 함수는 aud 클레임에 audience가 포함 되어 있는지 확인한다.
+// aud 클레임은 문자열 혹은 문자열 배열일 수 있다.
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateToken 함수는 jwt 라이브러리의 파싱 결과를 설정에 맞게 엄격하게 검증하고 클레임을 읽는다.
+// 만료 시각 등 시간 관련 클레임은 설정된 leeway를 고려하여 직접 검사한다.
+func validateToken(token *jwt.Token, err error, keyErr error) (*TokenClaims, error) {
+	conf := Config()
+
+	if keyErr != nil {
+		return nil, keyErr
+	}
+	if err == jwt.ErrNoTokenInRequest {
+		return nil, &TokenError{Kind: TokenMissing}
+	}
 	if err != nil {
-		return 0, 0, err
+		vErr, ok := err.(*jwt.ValidationError)
+		if !ok || token == nil {
+			return nil, &TokenError{Kind: TokenMalformed, Err: err}
+		}
+		switch {
+		case vErr.Errors&jwt.ValidationErrorUnverifiable != 0:
+			// 헤더의 alg가 jwt 라이브러리에 없는 알고리즘인 경우
+			return nil, &TokenError{Kind: TokenAlgorithmInvalid, Err: err}
+		case vErr.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+			return nil, &TokenError{Kind: TokenSignatureInvalid, Err: err}
+		case vErr.Errors&^(jwt.ValidationErrorExpired|jwt.ValidationErrorNotValidYet) != 0:
+			return nil, &TokenError{Kind: TokenMalformed, Err: err}
+		}
 	}
-	if !token.Valid {
-		return 0, 0, errors.New("invalid token")
+
+	now := utils.ServerTime()
+	leeway := conf.JWTLeeway()
+
+	uid, err := numericClaim(token.Claims, "uid", true)
+	if err != nil {
+		return nil, err
 	}
-	return int64(token.Claims["uid"].(float64)), token.Claims["exp"].(float64), nil
+	exp, err := numericClaim(token.Claims, "exp", true)
+	if err != nil {
+		return nil, err
+	}
+	iat, err := numericClaim(token.Claims, "iat", true)
+	if err != nil {
+		return nil, err
+	}
+	nbf, err := numericClaim(token.Claims, "nbf", false)
+	if err != nil {
+		return nil, err
+	}
+	// jti가 없는 토큰은 폐기할 수 없으므로 받아들이지 않는다.
+	jti, ok := token.Claims["jti"].(string)
+	if !ok || jti == "" {
+		return nil, &TokenError{Kind: TokenClaimInvalid, Claim: "jti"}
+	}
+
+	scope := ScopeAll
+	if v, ok := token.Claims["scope"]; ok {
+		if scope, ok = v.(string); !ok || scope == "" {
+			return nil, &TokenError{Kind: TokenClaimInvalid, Claim: "scope"}
+		}
+	}
+
+	sid := ""
+	if v, ok := token.Claims["sid"]; ok {
+		if sid, ok = v.(string); !ok || sid == "" {
+			return nil, &TokenError{Kind: TokenClaimInvalid, Claim: "sid"}
+		}
+	}
+
+	if now > exp+leeway {
+		return nil, &TokenError{Kind: TokenExpired, Claim: "exp"}
+	}
+	if nbf > now+leeway {
+		return nil, &TokenError{Kind: TokenNotValidYet, Claim: "nbf"}
+	}
+	if iat > now+leeway {
+		return nil, &TokenError{Kind: TokenIssuedInFuture, Claim: "iat"}
+	}
+	if conf.JWT.Issuer != "" {
+		if iss, _ := token.Claims["iss"].(string); iss != conf.JWT.Issuer {
+			return nil, &TokenError{Kind: TokenIssuerInvalid, Claim: "iss"}
+		}
+	}
+	if conf.JWT.Audience != "" && !hasAudience(token.Claims["aud"], conf.JWT.Audience) {
+		return nil, &TokenError{Kind: TokenAudienceInvalid, Claim: "aud"}
+	}
+
+	claims := &TokenClaims{
+		UID:           uid,
+		JTI:           jti,
+		IssuedAt:      iat,
+		IssuedAtMilli: int64(math.Floor(token.Claims["iat"].(float64)*1000 + 0.5)),
+		Expire:        exp,
+		Scope:         scope,
+		SID:           sid,
+	}
+
+	revoked, err := IsTokenRevoked(claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, &TokenError{Kind: TokenRevoked}
+	}
+
+	return claims, nil
+}
+
+// ParseToken implements the jwt token parser.
+// It returns claims of the token and error. Invalid tokens are rejected with *TokenError.
+func ParseToken(r *http.Request) (*TokenClaims, error) {
+	var keyErr error
+	token, err := jwt.ParseFromRequest(r, verificationKey(&keyErr))
+	return validateToken(token, err, keyErr)
+}
+
+// ParseTokenString is the same as ParseToken but parses the given token string
+// instead of the Authorization header of a request.
+func ParseTokenString(tokenString string) (*TokenClaims, error) {
+	var keyErr error
+	token, err := jwt.Parse(tokenString, verificationKey(&keyErr))
+	return validateToken(token, err, keyErr)
 }
 
 // LoadUserFromRequest is get a user from database using uid of jwt.
-func LoadUserFromRequest(r *http.Request) (*User, error) {
-	uid, _, err := ParseToken(r)
+// It also returns claims of the token.
+func LoadUserFromRequest(r *http.Request) (*User, *TokenClaims, error) {
+	claims, err := ParseToken(r)
 	if err != nil {
 		log.Debug(err)
-		return nil, err
+		return nil, nil, err
 	}
 
-	user, err := LoadUserFromUID(uid)
+	user, err := LoadUserFromUID(claims.UID)
 	if err != nil {
 		log.Debug(err)
-		return nil, err
+		return nil, nil, err
 	}
 
-	return user, nil
+	return user, claims, nil
 }
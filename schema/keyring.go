@@ -0,0 +1,192 @@
+package schema
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sort"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// legacyKeyID 는 [jwtkey] 섹션 없이 [resources] 섹션의 키 한 쌍만 설정된 경우에 사용하는 kid이다.
+// kid 헤더가 없는 이전 형식의 토큰도 이 kid의 키로 검증한다.
+const legacyKeyID = "default"
+
+var errUnknownKeyID = errors.New("unknown key id")
+
+// signingKey 구조체는 kid 하나에 해당하는 rsa 키 쌍이다.
+// 검증에만 사용하는 키는 private이 nil이다.
+type signingKey struct {
+	kid     string
+	private *rsa.PrivateKey
+	public  *rsa.PublicKey
+}
+
+// keyRing 구조체는 토큰 검증에 사용할 수 있는 키 묶음과 현재 서명에 사용하는 키를 가진다.
+type keyRing struct {
+	active *signingKey
+	keys   map[string]*signingKey
+}
+
+var (
+	ringMutex sync.RWMutex
+	ring      *keyRing
+)
+
+// JSONWebKey 구조체는 RFC 7517 형식의 공개키이다.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JSONWebKeySet 구조체는 /.well-known/jwks.json 으로 공개되는 공개키 목록이다.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+func loadSigningKey(kid string, privateKeyFile string, publicKeyFile string) (*signingKey, error) {
+	key := &signingKey{kid: kid}
+
+	pub, err := ioutil.ReadFile(publicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key. kid=%s, err=%v", kid, err)
+	}
+	if key.public, err = jwt.ParseRSAPublicKeyFromPEM(pub); err != nil {
+		return nil, fmt.Errorf("invalid public key. kid=%s, err=%v", kid, err)
+	}
+
+	if privateKeyFile != "" {
+		priv, err := ioutil.ReadFile(privateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key. kid=%s, err=%v", kid, err)
+		}
+		if key.private, err = jwt.ParseRSAPrivateKeyFromPEM(priv); err != nil {
+			return nil, fmt.Errorf("invalid private key. kid=%s, err=%v", kid, err)
+		}
+	}
+
+	return key, nil
+}
+
+// loadKeyRing 함수는 설정 파일로부터 키 링을 만든다.
+// [jwtkey "kid"] 섹션이 하나도 없으면 [resources] 섹션의 키 한 쌍을 legacyKeyID로 사용한다.
+func loadKeyRing(conf *Configure) (*keyRing, error) {
+	kr := &keyRing{keys: make(map[string]*signingKey)}
+
+	if _, ok := jwt.GetSigningMethod(conf.JWTAlgorithm()).(*jwt.SigningMethodRSA); !ok {
+		return nil, fmt.Errorf("unsupported jwt algorithm. algorithm=%s", conf.JWTAlgorithm())
+	}
+
+	activeKID := conf.JWT.ActiveKID
+	if len(conf.JWTKey) == 0 {
+		key, err := loadSigningKey(legacyKeyID, conf.PrivateKeyFile(), conf.PublicKeyFile())
+		if err != nil {
+			return nil, err
+		}
+		kr.keys[legacyKeyID] = key
+		activeKID = legacyKeyID
+	} else {
+		for kid, k := range conf.JWTKey {
+			key, err := loadSigningKey(kid, k.PrivateKeyFile, k.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			kr.keys[kid] = key
+		}
+	}
+
+	active, ok := kr.keys[activeKID]
+	if !ok {
+		return nil, fmt.Errorf("active key not found. activekid=%s", activeKID)
+	}
+	if active.private == nil {
+		return nil, fmt.Errorf("active key has no private key. activekid=%s", activeKID)
+	}
+	kr.active = active
+
+	return kr, nil
+}
+
+func mustInitJWT(conf *Configure) {
+	kr, err := loadKeyRing(conf)
+	if err != nil {
+		log.Fatalf("jwt key ring load error. err=%v", err)
+	}
+	ringMutex.Lock()
+	ring = kr
+	ringMutex.Unlock()
+	log.Infof("jwt key ring loaded. activekid=%s", kr.active.kid)
+	OnConfigChange(reloadKeyRing, "jwt", "jwtkey", "resources")
+}
+
+// reloadKeyRing 함수는 설정이 바뀌었을 때 새 설정으로부터 키 링을 다시 읽는다. 서버를 재시작 하지 않고
+// 서명 키를 교체할 때 사용된다. 키를 읽는 중 오류가 발생하면 설정 변경이 거부되고 기존 키 링이 유지된다.
+//
+// 키를 교체하려면 새 [jwtkey "kid"] 섹션을 추가하고 [jwt] 섹션의 activekid를 새 kid로 바꾼 뒤
+// 설정을 다시 읽는다. 이전 키는 그 키로 발급된 토큰이 모두 만료될 때까지 섹션을 남겨 두어야 한다.
+func reloadKeyRing(old *Configure, c *Configure) (func(), error) {
+	kr, err := loadKeyRing(c)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		ringMutex.Lock()
+		ring = kr
+		ringMutex.Unlock()
+		log.WithFields(log.Fields{"activekid": kr.active.kid}).Info("KEYRING_RELOAD")
+	}, nil
+}
+
+func currentKeyRing() *keyRing {
+	ringMutex.RLock()
+	defer ringMutex.RUnlock()
+	return ring
+}
+
+// publicKey 함수는 kid에 해당하는 검증용 공개키를 반환한다.
+func (kr *keyRing) publicKey(kid string) (*rsa.PublicKey, error) {
+	if kid == "" {
+		kid = legacyKeyID
+	}
+	key, ok := kr.keys[kid]
+	if !ok {
+		return nil, errUnknownKeyID
+	}
+	return key.public, nil
+}
+
+// JWKS 함수는 현재 키 링의 공개키 목록을 JWK Set 형식으로 반환한다.
+func JWKS() *JSONWebKeySet {
+	kr := currentKeyRing()
+	alg := Config().JWTAlgorithm()
+
+	kids := make([]string, 0, len(kr.keys))
+	for kid := range kr.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	set := &JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, kid := range kids {
+		pub := kr.keys[kid].public
+		set.Keys = append(set.Keys, JSONWebKey{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: alg,
+			Kid: kid,
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		})
+	}
+	return set
+}
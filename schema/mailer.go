@@ -0,0 +1,148 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"jsproj.com/koo/gosari/utils"
+)
+
+// Mailer 는 메일을 실제로 보내는 방법의 인터페이스이다. [mail] 섹션의 transport 항목에 따라 선택된다.
+// Send가 오류를 리턴하면 메일은 대기열에 남아 나중에 다시 보내진다.
+type Mailer interface {
+	Send(m *OutboxMail) error
+}
+
+// 메일을 보내는 방법. [mail] 섹션의 transport 항목에 사용한다.
+const (
+	MailTransportSMTP     = "smtp"     // [smtp] 섹션의 서버로 보낸다
+	MailTransportSendmail = "sendmail" // 로컬 sendmail 프로그램으로 보낸다
+	MailTransportFile     = "file"     // spooldir에 .eml 파일로 저장한다(개발, 다른 프로그램이 발송)
+	MailTransportLog      = "log"      // 보내지 않고 로그만 남긴다(테스트용)
+)
+
+var mailTransports = map[string]func(c *Configure) Mailer{
+	MailTransportSMTP:     func(c *Configure) Mailer { return smtpMailer{c} },
+	MailTransportSendmail: func(c *Configure) Mailer { return sendmailMailer{c.MailSendmailPath()} },
+	MailTransportFile:     func(c *Configure) Mailer { return fileMailer{c.MailSpoolDir()} },
+	MailTransportLog:      func(c *Configure) Mailer { return logMailer{} },
+}
+
+// newMailer 함수는 설정에 맞는 Mailer를 만든다. 설정은 검사를 통과한 것이어야 한다.
+func newMailer(c *Configure) Mailer {
+	return mailTransports[c.MailTransport()](c)
+}
+
+// smtpMailer 는 [smtp] 섹션의 서버로 메일을 보낸다.
+type smtpMailer struct {
+	conf *Configure
+}
+
+func (s smtpMailer) Send(m *OutboxMail) error {
+	smtp := &utils.SMTP{
+		Server:   s.conf.SMTP.Server,
+		Port:     s.conf.SMTP.Port,
+		User:     s.conf.SMTP.User,
+		Password: s.conf.SMTP.Password,
+	}
+	return smtp.Send(&utils.Email{
+		From:    m.From(),
+		To:      m.To(),
+		Subject: m.Subject,
+		Body:    m.Body,
+	})
+}
+
+// sendmailMailer 는 sendmail 호환 프로그램의 표준 입력으로 메일을 넘긴다.
+type sendmailMailer struct {
+	path string
+}
+
+func (s sendmailMailer) Send(m *OutboxMail) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(s.path, "-i", "-f", m.FromAddress, "--", m.ToAddress)
+	cmd.Stdin = bytes.NewReader(m.message())
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("sendmail failed. err=%v, stderr=%s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// fileMailer 는 메일을 디렉토리에 .eml 파일로 저장한다. 다른 프로그램이 파일을 읽는 도중의 내용을 보지
+// 않도록 임시 파일에 쓴 뒤 이름을 바꾼다.
+type fileMailer struct {
+	dir string
+}
+
+func (f fileMailer) Send(m *OutboxMail) error {
+	if err := os.MkdirAll(f.dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(f.dir, ".mail")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(m.message()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	name := fmt.Sprintf("%d-%d.eml", utils.ServerTime(), m.MID)
+	return os.Rename(tmp.Name(), filepath.Join(f.dir, name))
+}
+
+// logMailer 는 메일을 보내지 않고 로그만 남긴다. 본문에는 인증 링크 같은 토큰이 있으므로 남기지 않는다.
+type logMailer struct{}
+
+func (logMailer) Send(m *OutboxMail) error {
+	log.WithFields(log.Fields{
+		"mid":      m.MID,
+		"to":       m.ToAddress,
+		"template": m.Template,
+		"subject":  m.Subject,
+	}).Info("MAIL")
+	return nil
+}
+
+// message 함수는 메일을 RFC 5322 형식의 메시지로 만든다.
+func (m *OutboxMail) message() []byte {
+	var b bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+	}
+	from, to := m.From(), m.To()
+	header("From", from.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", time.Unix(m.Created, 0).Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=UTF-8")
+	header("Content-Transfer-Encoding", "8bit")
+	b.WriteString("\r\n")
+	b.WriteString(strings.Replace(strings.Replace(m.Body, "\r\n", "\n", -1), "\n", "\r\n", -1))
+	return b.Bytes()
+}
+
+// From 함수는 보내는 사람의 주소를 리턴한다.
+func (m *OutboxMail) From() mail.Address {
+	return mail.Address{Name: m.FromName, Address: m.FromAddress}
+}
+
+// To 함수는 받는 사람의 주소를 리턴한다.
+func (m *OutboxMail) To() mail.Address {
+	return mail.Address{Name: m.ToName, Address: m.ToAddress}
+}
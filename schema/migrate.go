@@ -0,0 +1,532 @@
+package schema
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"jsproj.com/koo/gosari/utils"
+)
+
+// Migration 구조체는 데이터베이스 스키마 변경 하나를 나타낸다. 이미 배포된 마이그레이션은 체크섬으로
+// 검사되므로 내용을 바꾸면 안되며, 스키마를 바꾸려면 migrations 목록의 끝에 새 마이그레이션을 추가한다.
+//
+// Up, Down에는 ;로 구분된 SQL을 작성하며 데이터베이스마다 다른 부분은 다음 토큰을 사용한다.
+//
+//	{{serial}}  자동 증가하는 bigint 기본키
+//	{{options}} 테이블 옵션(MySQL의 엔진과 문자셋)
+//
+// 토큰으로 해결되지 않는 경우에는 DriverUp, DriverDown에 드라이버별 SQL을 작성한다.
+//
+// Exists에는 마이그레이션이 만드는 테이블("table") 혹은 컬럼("table.column")을 적는다. 마이그레이션을
+// 도입하기 전에 만들어진 데이터베이스에 이미 있다면 실행하지 않고 적용된 것으로 기록한다.
+//
+// 데이터를 잃지 않고는 되돌릴 수 없는 마이그레이션은 Down 대신 Irreversible을 true로 하며, 이보다
+// 이전 버전으로는 되돌릴 수 없다.
+type Migration struct {
+	Version      int
+	Name         string
+	Up           string
+	Down         string
+	DriverUp     map[string]string
+	DriverDown   map[string]string
+	Exists       string
+	Irreversible bool
+}
+
+// MigrationStatus 구조체는 마이그레이션 하나의 적용 상태이다.
+type MigrationStatus struct {
+	Version  int    `json:"version"`
+	Name     string `json:"name"`
+	Applied  int64  `json:"applied"`  // 적용한 시각. 0이면 적용 전
+	Modified bool   `json:"modified"` // 적용한 뒤에 내용이 바뀌었는지 여부
+}
+
+const (
+	migrationTable       = "schema_migrations"
+	migrationLockName    = "talkcrew-auth-migrate" // MySQL get_lock 이름
+	migrationLockKey     = 7402150601              // PostgreSQL advisory lock 키
+	migrationLockTimeout = 60                      // 잠금을 기다리는 최대 시간(초)
+)
+
+var migrationTokens = map[string]*strings.Replacer{
+	DriverMySQL: strings.NewReplacer(
+		"{{serial}}", "bigint not null auto_increment primary key",
+		"{{options}}", " engine=InnoDB default charset=utf8"),
+	DriverPostgres: strings.NewReplacer(
+		"{{serial}}", "bigserial primary key",
+		"{{options}}", ""),
+	DriverSQLite: strings.NewReplacer(
+		"{{serial}}", "integer primary key autoincrement",
+		"{{options}}", ""),
+}
+
+// migrationDialect 함수는 마이그레이션에 사용할 SQL 문법의 드라이버 이름을 리턴한다.
+func migrationDialect(driver string) string {
+	if driver == DriverMemory {
+		return DriverSQLite
+	}
+	return driver
+}
+
+func (m Migration) sql(driver string, up bool) string {
+	driver = migrationDialect(driver)
+	text, drivers := m.Up, m.DriverUp
+	if !up {
+		text, drivers = m.Down, m.DriverDown
+	}
+	if s, ok := drivers[driver]; ok {
+		text = s
+	}
+	return migrationTokens[driver].Replace(text)
+}
+
+// checksum 함수는 드라이버에서 실행될 Up, Down SQL의 sha256 해시를 리턴한다.
+func (m Migration) checksum(driver string) string {
+	sum := sha256.Sum256([]byte(m.sql(driver, true) + "\n-- down\n" + m.sql(driver, false)))
+	return hex.EncodeToString(sum[:])
+}
+
+// statements 함수는 ;로 구분된 SQL을 문장 목록으로 나눈다.
+func statements(s string) []string {
+	var list []string
+	for _, stmt := range strings.Split(s, ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			list = append(list, stmt)
+		}
+	}
+	return list
+}
+
+// LatestMigration 함수는 이 서버가 알고 있는 마지막 스키마 버전을 리턴한다.
+func LatestMigration() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// migrator 는 잠금을 얻은 연결 하나로 마이그레이션을 실행한다.
+type migrator struct {
+	ctx     context.Context
+	conn    *sql.Conn
+	driver  string
+	applied map[int]MigrationStatus
+	sums    map[int]string // 적용할 때 기록된 체크섬
+}
+
+// withMigrator 함수는 다른 서버와 동시에 마이그레이션을 실행하지 않도록 잠금을 얻은 뒤 f를 실행한다.
+// MySQL은 get_lock, PostgreSQL은 advisory lock을 사용하며, SQLite는 연결이 하나뿐이므로 잠그지 않는다.
+func withMigrator(db *sql.DB, driver string, f func(m *migrator) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	switch driver {
+	case DriverMySQL:
+		var got sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "select get_lock(?, ?)", migrationLockName, migrationLockTimeout).Scan(&got); err != nil {
+			return err
+		}
+		if got.Int64 != 1 {
+			return fmt.Errorf("migration lock timeout. lock=%s", migrationLockName)
+		}
+		defer conn.ExecContext(ctx, "do release_lock(?)", migrationLockName)
+	case DriverPostgres:
+		// advisory lock은 기다리는 시간을 정할 수 없으므로 statement_timeout으로 제한한다.
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("set statement_timeout = %d", migrationLockTimeout*1000)); err != nil {
+			return err
+		}
+		_, err := conn.ExecContext(ctx, "select pg_advisory_lock(?)", migrationLockKey)
+		conn.ExecContext(ctx, "set statement_timeout = 0")
+		if err != nil {
+			return fmt.Errorf("migration lock failed. err=%v", err)
+		}
+		defer conn.ExecContext(ctx, "select pg_advisory_unlock(?)", migrationLockKey)
+	}
+
+	m := &migrator{ctx: ctx, conn: conn, driver: driver}
+	if err := m.load(); err != nil {
+		return err
+	}
+	return f(m)
+}
+
+// load 함수는 schema_migrations 테이블을 만들고 적용된 마이그레이션을 읽는다.
+// 마이그레이션을 도입하기 전에 만들어진 데이터베이스는 첫 마이그레이션(baseline)이 적용된 것으로 기록한다.
+func (m *migrator) load() error {
+	create := "create table if not exists " + migrationTable + ` (
+		version int not null primary key,
+		name varchar(100) not null,
+		checksum varchar(64) not null,
+		applied bigint not null
+	){{options}}`
+	if _, err := m.conn.ExecContext(m.ctx, migrationTokens[migrationDialect(m.driver)].Replace(create)); err != nil {
+		return err
+	}
+
+	m.applied = make(map[int]MigrationStatus)
+	m.sums = make(map[int]string)
+	rows, err := m.conn.QueryContext(m.ctx, "select version, name, checksum, applied from "+migrationTable)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s MigrationStatus
+		var sum string
+		if err := rows.Scan(&s.Version, &s.Name, &sum, &s.Applied); err != nil {
+			return err
+		}
+		m.applied[s.Version] = s
+		m.sums[s.Version] = sum
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(m.applied) == 0 {
+		return m.adopt()
+	}
+	return nil
+}
+
+// adopt 함수는 마이그레이션을 도입하기 전에 만들어진 데이터베이스라면 이미 있는 스키마에 해당하는
+// 마이그레이션을 적용된 것으로 기록한다. users 테이블이 없으면 새 데이터베이스로 보고 아무것도 하지 않는다.
+func (m *migrator) adopt() error {
+	exists, err := m.exists("users")
+	if err != nil || !exists {
+		return err
+	}
+	for i, mig := range migrations {
+		if i > 0 {
+			if mig.Exists == "" {
+				continue
+			}
+			if exists, err = m.exists(mig.Exists); err != nil {
+				return err
+			} else if !exists {
+				continue
+			}
+		}
+		log.Warnf("existing schema found. marking migration as applied. version=%d, name=%s", mig.Version, mig.Name)
+		if err := m.record(m.conn, mig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exists 함수는 현재 데이터베이스에 테이블("table") 혹은 컬럼("table.column")이 있는지 확인한다.
+func (m *migrator) exists(name string) (bool, error) {
+	var query string
+	args := []interface{}{name}
+	if i := strings.Index(name, "."); i >= 0 {
+		args = []interface{}{name[:i], name[i+1:]}
+		switch migrationDialect(m.driver) {
+		case DriverSQLite:
+			query = "select count(*) from pragma_table_info(?) where name=?"
+		case DriverPostgres:
+			query = "select count(*) from information_schema.columns where table_schema=current_schema() and table_name=? and column_name=?"
+		default:
+			query = "select count(*) from information_schema.columns where table_schema=database() and table_name=? and column_name=?"
+		}
+	} else {
+		switch migrationDialect(m.driver) {
+		case DriverSQLite:
+			query = "select count(*) from sqlite_master where type='table' and name=?"
+		case DriverPostgres:
+			query = "select count(*) from information_schema.tables where table_schema=current_schema() and table_name=?"
+		default:
+			query = "select count(*) from information_schema.tables where table_schema=database() and table_name=?"
+		}
+	}
+	var n int
+	err := m.conn.QueryRowContext(m.ctx, query, args...).Scan(&n)
+	return n > 0, err
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// record 함수는 마이그레이션을 적용한 것으로 기록한다.
+func (m *migrator) record(e execer, mig Migration) error {
+	now := utils.ServerTime()
+	sum := mig.checksum(m.driver)
+	_, err := e.ExecContext(m.ctx, "insert into "+migrationTable+" (version, name, checksum, applied) values (?, ?, ?, ?)",
+		mig.Version, mig.Name, sum, now)
+	if err == nil {
+		m.applied[mig.Version] = MigrationStatus{Version: mig.Version, Name: mig.Name, Applied: now}
+		m.sums[mig.Version] = sum
+	}
+	return err
+}
+
+// status 함수는 알고 있는 마이그레이션과 데이터베이스에만 기록된 마이그레이션의 상태를 버전 순서로 리턴한다.
+func (m *migrator) status() []MigrationStatus {
+	var list []MigrationStatus
+	known := make(map[int]bool)
+	for _, mig := range migrations {
+		known[mig.Version] = true
+		s := MigrationStatus{Version: mig.Version, Name: mig.Name}
+		if a, ok := m.applied[mig.Version]; ok {
+			s.Applied = a.Applied
+			s.Modified = m.sums[mig.Version] != mig.checksum(m.driver)
+		}
+		list = append(list, s)
+	}
+	for v, a := range m.applied {
+		if !known[v] {
+			list = append(list, a)
+		}
+	}
+	sort.Sort(statusByVersion(list))
+	return list
+}
+
+// verify 함수는 데이터베이스가 이 서버보다 새 스키마이거나 적용된 마이그레이션의 내용이 바뀌었다면 오류를 리턴한다.
+func (m *migrator) verify() error {
+	latest := LatestMigration()
+	for _, s := range m.status() {
+		switch {
+		case s.Applied != 0 && s.Version > latest:
+			return fmt.Errorf("database schema is newer than this server. version=%d, latest=%d", s.Version, latest)
+		case s.Modified:
+			return fmt.Errorf("applied migration has been modified. version=%d, name=%s", s.Version, s.Name)
+		}
+	}
+	return nil
+}
+
+// pending 함수는 아직 적용되지 않은 마이그레이션 목록을 리턴한다.
+func (m *migrator) pending() []Migration {
+	var list []Migration
+	for _, mig := range migrations {
+		if _, ok := m.applied[mig.Version]; !ok {
+			list = append(list, mig)
+		}
+	}
+	return list
+}
+
+// run 함수는 마이그레이션 하나를 트랜잭션으로 실행하고 기록을 남기거나 지운다.
+// MySQL은 DDL을 트랜잭션으로 묶을 수 없으므로 실패하면 일부만 적용되었을 수 있다.
+func (m *migrator) run(mig Migration, up bool) error {
+	tx, err := m.conn.BeginTx(m.ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range statements(mig.sql(m.driver, up)) {
+		if _, err := tx.ExecContext(m.ctx, stmt); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration failed. version=%d, name=%s, err=%v", mig.Version, mig.Name, err)
+		}
+	}
+	if up {
+		err = m.record(tx, mig)
+	} else {
+		_, err = tx.ExecContext(m.ctx, "delete from "+migrationTable+" where version=?", mig.Version)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if !up {
+		delete(m.applied, mig.Version)
+		delete(m.sums, mig.Version)
+	}
+	log.WithFields(log.Fields{"version": mig.Version, "name": mig.Name, "up": up}).Warn("MIGRATION")
+	return nil
+}
+
+// up 함수는 target 버전까지 적용되지 않은 마이그레이션을 차례로 적용한다.
+func (m *migrator) up(target int) error {
+	if err := m.verify(); err != nil {
+		return err
+	}
+	for _, mig := range m.pending() {
+		if mig.Version > target {
+			break
+		}
+		if err := m.run(mig, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// down 함수는 target 버전보다 새 마이그레이션을 역순으로 되돌린다. 되돌릴 수 없는 마이그레이션이 하나라도
+// 있으면 아무것도 되돌리지 않고 오류를 리턴한다.
+func (m *migrator) down(target int) error {
+	if err := m.verify(); err != nil {
+		return err
+	}
+	var list []Migration
+	for i := len(migrations) - 1; i >= 0; i-- {
+		mig := migrations[i]
+		if mig.Version <= target {
+			break
+		}
+		if _, ok := m.applied[mig.Version]; !ok {
+			continue
+		}
+		if err := m.checkDown(mig); err != nil {
+			return err
+		}
+		list = append(list, mig)
+	}
+	for _, mig := range list {
+		if err := m.run(mig, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqliteDropColumnVersion 은 alter table의 drop column을 지원하는 첫 SQLite 버전이다.
+var sqliteDropColumnVersion = []int{3, 35, 0}
+
+// checkDown 함수는 마이그레이션을 되돌릴 수 있는지 확인한다. SQLite는 3.35.0부터 컬럼을 지울 수 있으므로
+// 그보다 오래된 버전에서는 컬럼을 지우는 마이그레이션을 되돌리지 않는다.
+func (m *migrator) checkDown(mig Migration) error {
+	if mig.Irreversible {
+		return fmt.Errorf("migration is irreversible. version=%d, name=%s", mig.Version, mig.Name)
+	}
+	if migrationDialect(m.driver) != DriverSQLite || !strings.Contains(strings.ToLower(mig.sql(m.driver, false)), "drop column") {
+		return nil
+	}
+	var version string
+	if err := m.conn.QueryRowContext(m.ctx, "select sqlite_version()").Scan(&version); err != nil {
+		return err
+	}
+	if !isVersionAtLeast(version, sqliteDropColumnVersion) {
+		return fmt.Errorf("sqlite %s can not drop columns. 3.35.0 or later is required. version=%d, name=%s",
+			version, mig.Version, mig.Name)
+	}
+	return nil
+}
+
+// isVersionAtLeast 함수는 3.35.5 형식의 버전이 min 이상인지 확인한다.
+func isVersionAtLeast(version string, min []int) bool {
+	parts := strings.Split(version, ".")
+	for i, want := range min {
+		got := 0
+		if i < len(parts) {
+			got, _ = strconv.Atoi(parts[i])
+		}
+		if got != want {
+			return got > want
+		}
+	}
+	return true
+}
+
+// current 함수는 적용된 마지막 버전을 리턴한다. 적용된 마이그레이션이 없으면 0을 리턴한다.
+func (m *migrator) current() int {
+	v := 0
+	for version := range m.applied {
+		if version > v {
+			v = version
+		}
+	}
+	return v
+}
+
+// mustMigrate 함수는 서버가 시작될 때 스키마를 확인한다. 데이터베이스가 이 서버보다 새 스키마라면 서버를
+// 종료한다. 적용되지 않은 마이그레이션은 [database] 섹션의 automigrate가 true이면 적용하고, 아니면
+// -migrate up으로 먼저 적용하도록 알리고 종료한다.
+func mustMigrate(db *sql.DB, driver string, auto bool) {
+	err := withMigrator(db, driver, func(m *migrator) error {
+		if err := m.verify(); err != nil {
+			return err
+		}
+		pending := m.pending()
+		if len(pending) == 0 {
+			return nil
+		}
+		if !auto {
+			return fmt.Errorf("%d pending migrations. run with -migrate up first", len(pending))
+		}
+		return m.up(LatestMigration())
+	})
+	if err != nil {
+		log.Fatalf("database migration error. driver=%s, err=%v", driver, err)
+	}
+	log.Infof("database schema is up to date. version=%d", LatestMigration())
+}
+
+// Migrate 함수는 설정 파일의 데이터베이스에 마이그레이션 명령을 실행하고 결과를 w에 출력한다.
+// 서버를 시작하지 않고 명령행(-migrate)에서 실행하기 위한 함수이다.
+//
+//	up     target 버전까지 적용한다. target이 0 이하이면 마지막 버전까지 적용한다.
+//	down   target 버전까지 되돌린다. target이 0 미만이면 마지막 마이그레이션 하나만 되돌린다.
+//	status 각 마이그레이션의 적용 상태를 출력한다.
+func Migrate(configFileName string, command string, target int, w io.Writer) error {
+	conf, err := LoadConfig(configFileName)
+	if err != nil {
+		return err
+	}
+	driver := conf.DatabaseDriver()
+	db, _, err := openDatabase(driver, conf.Database.Auth)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return withMigrator(db, driver, func(m *migrator) error {
+		switch command {
+		case "up":
+			if target <= 0 {
+				target = LatestMigration()
+			}
+			if err := m.up(target); err != nil {
+				return err
+			}
+		case "down":
+			if target < 0 {
+				target = 0
+				for _, mig := range migrations {
+					if _, ok := m.applied[mig.Version]; ok && mig.Version < m.current() {
+						target = mig.Version
+					}
+				}
+			}
+			if err := m.down(target); err != nil {
+				return err
+			}
+		case "status":
+		default:
+			return fmt.Errorf("unknown migrate command. command=%s", command)
+		}
+
+		fmt.Fprintf(w, "driver=%s current=%d latest=%d\n", driver, m.current(), LatestMigration())
+		for _, s := range m.status() {
+			state := "pending"
+			if s.Applied != 0 {
+				state = "applied"
+			}
+			if s.Modified {
+				state = "modified"
+			}
+			fmt.Fprintf(w, "%4d %-8s %s\n", s.Version, state, s.Name)
+		}
+		return nil
+	})
+}
+
+type statusByVersion []MigrationStatus
+
+func (a statusByVersion) Len() int           { return len(a) }
+func (a statusByVersion) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a statusByVersion) Less(i, j int) bool { return a[i].Version < a[j].Version }
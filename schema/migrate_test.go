@@ -0,0 +1,56 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsVersionAtLeast(t *testing.T) {
+	cases := []struct {
+		version string
+		want    bool
+	}{
+		{"3.35.0", true},
+		{"3.35.5", true},
+		{"3.36", true},
+		{"4.0.0", true},
+		{"3.34.1", false},
+		{"3.8.10", false},
+		{"2.99.99", false},
+	}
+	for _, c := range cases {
+		if got := isVersionAtLeast(c.version, sqliteDropColumnVersion); got != c.want {
+			t.Errorf("isVersionAtLeast(%q) = %v, want %v", c.version, got, c.want)
+		}
+	}
+}
+
+// 스키마를 바꾸는 마이그레이션은 되돌리는 SQL이 있거나 되돌릴 수 없다고 표시되어야 한다.
+// 데이터만 바꾸는 마이그레이션은 되돌릴 때 아무것도 하지 않을 수 있다.
+func TestMigrationsReversible(t *testing.T) {
+	for _, mig := range migrations {
+		if mig.Irreversible {
+			continue
+		}
+		for _, driver := range []string{DriverMySQL, DriverPostgres, DriverSQLite} {
+			up := strings.ToLower(mig.sql(driver, true))
+			ddl := strings.Contains(up, "create ") || strings.Contains(up, "alter ")
+			if ddl && mig.sql(driver, false) == "" {
+				t.Errorf("migration %d %q has no down for %s", mig.Version, mig.Name, driver)
+			}
+		}
+	}
+}
+
+func TestMigrateDownPastDataMigration(t *testing.T) {
+	last := migrations[len(migrations)-1]
+	m := &migrator{driver: DriverMySQL}
+	if err := m.checkDown(last); err != nil {
+		t.Errorf("checkDown(%d) = %v, want nil", last.Version, err)
+	}
+	for _, mig := range migrations {
+		if mig.Version == 3 && m.checkDown(mig) == nil {
+			t.Errorf("checkDown(3) = nil, want irreversible")
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package schema
+
+// migrations 는 데이터베이스 스키마의 변경 이력이다. 서버가 시작될 때 버전 순서로 적용되며 적용된 버전은
+// schema_migrations 테이블에 체크섬과 함께 기록된다. 이미 배포된 항목은 절대 수정하지 말고 스키마를
+// 바꿀 때에는 목록의 끝에 다음 버전을 추가한다. 컬럼의 크기는 각 스키마 파일의 XXXMaxSize 상수와 같아야 한다.
+//
+// 1번(baseline)은 처음 배포된 users, todo 테이블이다. 마이그레이션 없이 만들어진 데이터베이스는 baseline과
+// Exists의 테이블, 컬럼이 이미 있는 마이그레이션이 적용된 것으로 기록된다.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		Up: `
+create table if not exists users (
+	uid {{serial}},
+	id varchar(200) not null unique,
+	info varchar(500) not null,
+	status int not null,
+	password varchar(48) not null,
+	passwordtmp varchar(48) not null,
+	created bigint not null,
+	activationkey varchar(36) not null,
+	type int not null
+){{options}};
+create table if not exists todo (
+	tid {{serial}},
+	owneruid bigint not null,
+	category varchar(10) not null,
+	todo varchar(200) not null,
+	limittime bigint not null,
+	status int not null
+){{options}}`,
+		Down: `
+drop table todo;
+drop table users`,
+	},
+	{
+		Version: 2,
+		Name:    "refresh tokens",
+		Exists:  "refreshtokens",
+		Up: `
+create table if not exists refreshtokens (
+	rtid {{serial}},
+	uid bigint not null,
+	family varchar(36) not null,
+	device varchar(100) not null,
+	hash varchar(64) not null unique,
+	created bigint not null,
+	expire bigint not null,
+	status int not null
+){{options}}`,
+		Down: `drop table refreshtokens`,
+	},
+	{
+		// 비밀번호 해시 형식이 바뀌어 컬럼을 늘린다. SQLite는 varchar의 크기를 검사하지 않으므로 바꿀 것이 없다.
+		// 늘어난 해시가 잘리므로 되돌리지 않는다.
+		Version:      3,
+		Name:         "widen password hash",
+		Irreversible: true,
+		DriverUp: map[string]string{
+			DriverMySQL: `
+alter table users modify password varchar(255) not null;
+alter table users modify passwordtmp varchar(255) not null`,
+			DriverPostgres: `
+alter table users alter column password type varchar(255);
+alter table users alter column passwordtmp type varchar(255)`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "password resets",
+		Exists:  "passwordresets",
+		Up: `
+create table if not exists passwordresets (
+	prid {{serial}},
+	uid bigint not null,
+	hash varchar(64) not null unique,
+	created bigint not null,
+	expire bigint not null,
+	used bigint not null
+){{options}}`,
+		Down: `drop table passwordresets`,
+	},
+	{
+		Version: 5,
+		Name:    "users must change password",
+		Exists:  "users.mustchangepass",
+		Up:      `alter table users add column mustchangepass boolean not null default false`,
+		Down:    `alter table users drop column mustchangepass`,
+	},
+	{
+		Version: 6,
+		Name:    "password history",
+		Exists:  "passwordhistory",
+		Up: `
+create table if not exists passwordhistory (
+	phid {{serial}},
+	uid bigint not null,
+	hash varchar(255) not null,
+	created bigint not null
+){{options}}`,
+		Down: `drop table passwordhistory`,
+	},
+	{
+		Version: 7,
+		Name:    "totp",
+		Exists:  "totp",
+		Up: `
+create table if not exists totp (
+	uid bigint not null primary key,
+	secret varchar(32) not null,
+	enabled boolean not null,
+	created bigint not null,
+	laststep bigint not null
+){{options}};
+create table if not exists recoverycodes (
+	rcid {{serial}},
+	uid bigint not null,
+	hash varchar(64) not null,
+	used bigint not null
+){{options}}`,
+		Down: `
+drop table recoverycodes;
+drop table totp`,
+	},
+	{
+		Version: 8,
+		Name:    "sessions",
+		Exists:  "sessions",
+		Up: `
+create table if not exists sessions (
+	sid varchar(36) not null primary key,
+	uid bigint not null,
+	device varchar(100) not null,
+	useragent varchar(255) not null,
+	ip varchar(45) not null,
+	created bigint not null,
+	lastseen bigint not null,
+	revoked bigint not null
+){{options}}`,
+		Down: `drop table sessions`,
+	},
+	{
+		Version: 9,
+		Name:    "users activation expire",
+		Exists:  "users.activationexpire",
+		Up:      `alter table users add column activationexpire bigint not null default 0`,
+		Down:    `alter table users drop column activationexpire`,
+	},
+	{
+		Version: 10,
+		Name:    "email changes",
+		Exists:  "emailchanges",
+		Up: `
+create table if not exists emailchanges (
+	ecid {{serial}},
+	uid bigint not null,
+	newid varchar(200) not null,
+	hash varchar(64) not null unique,
+	created bigint not null,
+	expire bigint not null,
+	used bigint not null
+){{options}}`,
+		Down: `drop table emailchanges`,
+	},
+	{
+		Version: 11,
+		Name:    "users withdrawn",
+		Exists:  "users.withdrawn",
+		Up:      `alter table users add column withdrawn bigint not null default 0`,
+		Down:    `alter table users drop column withdrawn`,
+	},
+	{
+		Version: 12,
+		Name:    "users block reason",
+		Exists:  "users.blockreason",
+		Up:      `alter table users add column blockreason varchar(255) not null default ''`,
+		Down:    `alter table users drop column blockreason`,
+	},
+	{
+		Version: 13,
+		Name:    "roles",
+		Exists:  "roles",
+		Up: `
+create table if not exists roles (
+	name varchar(50) not null primary key,
+	description varchar(255) not null
+){{options}};
+create table if not exists rolepermissions (
+	rpid {{serial}},
+	role varchar(50) not null,
+	permission varchar(50) not null,
+	unique (role, permission)
+){{options}};
+create table if not exists userroles (
+	urid {{serial}},
+	uid bigint not null,
+	role varchar(50) not null,
+	unique (uid, role)
+){{options}}`,
+		Down: `
+drop table userroles;
+drop table rolepermissions;
+drop table roles`,
+	},
+	{
+		Version: 14,
+		Name:    "audit",
+		Exists:  "audit",
+		Up: `
+create table if not exists audit (
+	aid {{serial}},
+	created bigint not null,
+	event varchar(50) not null,
+	result varchar(20) not null,
+	actoruid bigint not null,
+	targetuid bigint not null,
+	targetid varchar(200) not null,
+	ip varchar(45) not null,
+	useragent varchar(255) not null,
+	detail varchar(255) not null
+){{options}}`,
+		Down: `drop table audit`,
+	},
+	{
+		Version: 15,
+		Name:    "mail outbox",
+		Exists:  "outbox",
+		Up: `
+create table if not exists outbox (
+	mid {{serial}},
+	fromname varchar(100) not null,
+	fromaddress varchar(200) not null,
+	toname varchar(100) not null,
+	toaddress varchar(200) not null,
+	template varchar(100) not null,
+	subject varchar(255) not null,
+	body text not null,
+	status int not null,
+	attempts int not null,
+	nextattempt bigint not null,
+	lasterror varchar(255) not null,
+	created bigint not null,
+	finished bigint not null
+){{options}};
+create index outbox_due on outbox (status, nextattempt)`,
+		Down: `drop table outbox`,
+	},
+	{
+		// 사용자 아이디를 대소문자 구분 없이 찾도록 소문자로 바꾼다(normalizeID). 대소문자만 다른 아이디가
+		// 이미 있으면 unique 제약 오류로 실패하므로 하나를 정리한 뒤 다시 적용한다. 소문자 아이디는 이전
+		// 스키마에서도 그대로 사용할 수 있으므로 되돌릴 때에는 아무것도 바꾸지 않는다.
+		Version: 16,
+		Name:    "normalize user ids",
+		Up: `
+update users set id=lower(id);
+update emailchanges set newid=lower(newid)`,
+	},
+}
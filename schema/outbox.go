@@ -0,0 +1,293 @@
+package schema
+
+import (
+	"encoding/gob"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/gorp.v1"
+	"jsproj.com/koo/gosari/utils"
+)
+
+// OutboxMail 객체는 보낼 메일 대기열(outbox)의 스키마 객체이다. 여기에서 정의된 형태로 데이터베이스
+// 테이블이 작성된다. 따라서 이 코드를 바꾸는 경우 반드시 DB 마이그레이션이 필요하다.
+//
+// 요청을 처리하는 동안에는 대기열에 넣기만 하고 실제 발송은 작업자가 한다. 본문에는 인증 링크 같은
+// 비밀 값이 있으므로 보냈거나 dead 상태가 되면 본문을 지운다.
+type OutboxMail struct {
+	MID         int64  `db:"mid" json:"mid"`
+	FromName    string `db:"fromname" json:"fromname"`
+	FromAddress string `db:"fromaddress" json:"fromaddress"`
+	ToName      string `db:"toname" json:"toname"`
+	ToAddress   string `db:"toaddress" json:"toaddress"`
+	Template    string `db:"template" json:"template"` // 본문 템플릿 이름(운영 화면에서 메일 종류 구분용)
+	Subject     string `db:"subject" json:"subject"`
+	Body        string `db:"body" json:"-"`
+	Status      int    `db:"status" json:"status"`           // 상태(OutboxStatusXXX)
+	Attempts    int    `db:"attempts" json:"attempts"`       // 보내기를 시도한 횟수
+	NextAttempt int64  `db:"nextattempt" json:"nextattempt"` // 다음에 보낼 시각(보내는 중이면 점유 만료 시각)
+	LastError   string `db:"lasterror" json:"lasterror"`     // 마지막 실패 사유
+	Created     int64  `db:"created" json:"created"`         // 대기열에 넣은 시각
+	Finished    int64  `db:"finished" json:"finished"`       // 보냈거나 dead 상태가 된 시각
+}
+
+// OutboxMail 스키마 상수 정의. 이곳에서 사용되는 상수는 데이터베이스에 반영되므로 값을 변경하면 안된다.
+// 불가피하게 값을 변경해야 할 경우는 기존 데이터베이스가 마이그레이션 되어야 한다.
+const (
+	OutboxStatusDead    = -1 // 최대 시도 횟수를 넘어 더 보내지 않음
+	OutboxStatusPending = 0  // 보낼 차례를 기다리는 중
+	OutboxStatusSent    = 1  // 보냄
+
+	OutboxNameMaxSize     = 100
+	OutboxTemplateMaxSize = 100
+	OutboxSubjectMaxSize  = 255
+	OutboxErrorMaxSize    = 255
+
+	OutboxListDefaultSize = 50  // 한 페이지의 기본 메일 수
+	OutboxListMaxSize     = 500 // 한 페이지의 최대 메일 수
+
+	// 작업자가 메일을 가져간 뒤 다른 작업자(다른 서버 포함)가 가져가지 못하는 시간(초).
+	// 작업자가 보내는 도중에 서버가 종료되면 이 시간이 지난 뒤 다시 보내진다.
+	outboxLeaseSecond   = 600
+	outboxPurgeInterval = 3600 // 오래된 기록을 지우는 간격(초)
+)
+
+// mailWake 는 새 메일이 대기열에 들어왔음을 작업자에게 알린다.
+var mailWake = make(chan struct{}, 1)
+
+// EnqueueMail 함수는 메일을 대기열에 넣는다. 메일은 작업자가 [mail] 섹션의 transport로 보낸다.
+func EnqueueMail(m *OutboxMail) error {
+	if err := enqueueMail(Database().Auth, m); err != nil {
+		return err
+	}
+	wakeMailWorkers()
+	return nil
+}
+
+// enqueueMail 함수는 메일을 exec(트랜잭션일 수 있음)로 대기열에 넣는다. 트랜잭션이면 커밋한 뒤에
+// wakeMailWorkers를 불러야 바로 보내진다.
+func enqueueMail(exec gorp.SqlExecutor, m *OutboxMail) error {
+	now := utils.ServerTime()
+	m.MID = 0
+	m.FromName = truncate(m.FromName, OutboxNameMaxSize)
+	m.ToName = truncate(m.ToName, OutboxNameMaxSize)
+	m.Template = truncate(m.Template, OutboxTemplateMaxSize)
+	m.Subject = truncate(m.Subject, OutboxSubjectMaxSize)
+	m.Status = OutboxStatusPending
+	m.Attempts = 0
+	m.NextAttempt = now
+	m.LastError = ""
+	m.Created = now
+	m.Finished = 0
+	return exec.Insert(m)
+}
+
+// wakeMailWorkers 함수는 새 메일이 대기열에 들어왔음을 작업자에게 알린다.
+func wakeMailWorkers() {
+	select {
+	case mailWake <- struct{}{}:
+	default:
+	}
+}
+
+// startMailWorkers 함수는 [mail] 섹션의 workers 만큼 메일을 보내는 작업자를 시작한다.
+// 보낼 차례가 된 메일은 하나의 분배자가 점유하여 작업자에게 넘기며, 여러 서버가 같은 대기열을 처리
+// 하더라도 한 메일은 한 작업자만 보낸다.
+func startMailWorkers() {
+	n := Config().MailWorkers()
+	jobs := make(chan *OutboxMail)
+	for i := 0; i < n; i++ {
+		go func() {
+			for m := range jobs {
+				deliverMail(m)
+			}
+		}()
+	}
+
+	go func() {
+		var lastPurge int64
+		for {
+			if now := utils.ServerTime(); now-lastPurge >= outboxPurgeInterval {
+				if err := purgeOutbox(now - Config().MailKeep()); err != nil {
+					log.Errorf("mail outbox purge failed. err=%v", err)
+				}
+				lastPurge = now
+			}
+
+			mails, err := claimMails(n)
+			if err != nil {
+				log.Errorf("mail claim failed. err=%v", err)
+			}
+			for _, m := range mails {
+				jobs <- m
+			}
+			if len(mails) == n {
+				// 보낼 차례가 된 메일이 더 있을 수 있다.
+				continue
+			}
+
+			select {
+			case <-mailWake:
+			case <-time.After(time.Duration(Config().MailPollInterval()) * time.Second):
+			}
+		}
+	}()
+	log.Infof("mail workers started. workers=%d", n)
+}
+
+// claimMails 함수는 보낼 차례가 된 메일을 limit개까지 점유한다. 점유한 메일은 시도 횟수가 늘어나고
+// outboxLeaseSecond 동안 다른 작업자가 가져가지 못한다.
+func claimMails(limit int) ([]*OutboxMail, error) {
+	db := Database()
+	now := utils.ServerTime()
+
+	var due []*OutboxMail
+	_, err := db.Auth.Select(&due, "select * from outbox where status=? and nextattempt<=? order by nextattempt, mid limit ?",
+		OutboxStatusPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []*OutboxMail
+	lease := now + outboxLeaseSecond
+	for _, m := range due {
+		result, err := db.Auth.Exec("update outbox set nextattempt=?, attempts=attempts+1 where mid=? and status=? and nextattempt=?",
+			lease, m.MID, OutboxStatusPending, m.NextAttempt)
+		if err != nil {
+			return claimed, err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return claimed, err
+		} else if n != 1 {
+			// 다른 작업자가 먼저 가져갔다.
+			continue
+		}
+		m.NextAttempt = lease
+		m.Attempts++
+		claimed = append(claimed, m)
+	}
+	return claimed, nil
+}
+
+// deliverMail 함수는 점유한 메일을 보내고 결과를 기록한다. 실패하면 [mail] 섹션의 retrysecond부터 두 배씩
+// 늘어나는 시간 뒤에 다시 보내며, maxattempts번 실패하면 dead 상태로 바꾸어 더 보내지 않는다.
+func deliverMail(m *OutboxMail) {
+	conf := Config()
+	db := Database()
+	now := utils.ServerTime()
+	fields := log.Fields{
+		"mid":      m.MID,
+		"to":       m.ToAddress,
+		"template": m.Template,
+		"attempts": m.Attempts,
+	}
+
+	sendErr := newMailer(conf).Send(m)
+
+	var err error
+	switch {
+	case sendErr == nil:
+		_, err = db.Auth.Exec("update outbox set status=?, body=?, lasterror=?, finished=? where mid=?",
+			OutboxStatusSent, "", "", now, m.MID)
+		log.WithFields(fields).Info("MAIL_SENT")
+	case m.Attempts >= conf.MailMaxAttempts():
+		_, err = db.Auth.Exec("update outbox set status=?, body=?, lasterror=?, finished=? where mid=?",
+			OutboxStatusDead, "", truncate(sendErr.Error(), OutboxErrorMaxSize), now, m.MID)
+		fields["err"] = sendErr
+		log.WithFields(fields).Error("MAIL_DEAD")
+	default:
+		next := now + conf.MailRetryDelay(m.Attempts)
+		_, err = db.Auth.Exec("update outbox set nextattempt=?, lasterror=? where mid=?",
+			next, truncate(sendErr.Error(), OutboxErrorMaxSize), m.MID)
+		fields["err"] = sendErr
+		fields["next"] = next
+		log.WithFields(fields).Warn("MAIL_RETRY")
+	}
+	if err != nil {
+		log.WithFields(fields).Errorf("mail result update failed. err=%v", err)
+	}
+}
+
+// purgeOutbox 함수는 before 이전에 보냈거나 dead 상태가 된 메일의 기록을 지운다.
+func purgeOutbox(before int64) error {
+	_, err := Database().Auth.Exec("delete from outbox where status<>? and finished<=?", OutboxStatusPending, before)
+	return err
+}
+
+// SearchOutbox 함수는 메일 대기열을 최근 순서로 읽는다. status가 nil이면 모든 상태의 메일을 읽는다.
+// page는 1부터 시작하며 조건에 맞는 전체 메일 수를 함께 리턴한다.
+func SearchOutbox(status *int, page int, size int) ([]*OutboxMail, int64, error) {
+	db := Database()
+
+	if page < 1 {
+		page = 1
+	}
+	if size <= 0 {
+		size = OutboxListDefaultSize
+	}
+	if size > OutboxListMaxSize {
+		size = OutboxListMaxSize
+	}
+
+	where := ""
+	var args []interface{}
+	if status != nil {
+		where = " where status=?"
+		args = append(args, *status)
+	}
+	total, err := db.Auth.SelectInt("select count(*) from outbox"+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var mails []*OutboxMail
+	args = append(args, size, (page-1)*size)
+	_, err = db.Auth.Select(&mails, "select * from outbox"+where+" order by mid desc limit ? offset ?", args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return mails, total, nil
+}
+
+// OutboxCount 구조체는 대기열의 상태별 메일 수이다.
+type OutboxCount struct {
+	Pending int64 `json:"pending"`
+	Sent    int64 `json:"sent"`
+	Dead    int64 `json:"dead"`
+}
+
+// CountOutbox 함수는 대기열의 상태별 메일 수를 리턴한다.
+func CountOutbox() (OutboxCount, error) {
+	var count OutboxCount
+	var rows []struct {
+		Status int   `db:"status"`
+		N      int64 `db:"n"`
+	}
+	_, err := Database().Auth.Select(&rows, "select status, count(*) as n from outbox group by status")
+	if err != nil {
+		return count, err
+	}
+	for _, r := range rows {
+		switch r.Status {
+		case OutboxStatusPending:
+			count.Pending = r.N
+		case OutboxStatusSent:
+			count.Sent = r.N
+		case OutboxStatusDead:
+			count.Dead = r.N
+		}
+	}
+	return count, nil
+}
+
+func createOutboxTable(dbmap *gorp.DbMap) {
+	gob.Register(&OutboxMail{})
+	table := dbmap.AddTableWithName(OutboxMail{}, "outbox").SetKeys(true, "MID")
+	table.ColMap("FromName").SetMaxSize(OutboxNameMaxSize)
+	table.ColMap("FromAddress").SetMaxSize(IDMaxSize)
+	table.ColMap("ToName").SetMaxSize(OutboxNameMaxSize)
+	table.ColMap("ToAddress").SetMaxSize(IDMaxSize)
+	table.ColMap("Template").SetMaxSize(OutboxTemplateMaxSize)
+	table.ColMap("Subject").SetMaxSize(OutboxSubjectMaxSize)
+	table.ColMap("LastError").SetMaxSize(OutboxErrorMaxSize)
+}
@@ -0,0 +1,268 @@
+package schema
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"jsproj.com/koo/gosari/crypto"
+)
+
+// PasswordHasher 는 비밀번호 해시 방식의 인터페이스이다.
+// 해시 결과 문자열에는 방식과 파라미터가 함께 들어 있어야 하며(PHC 문자열 형식 등), 데이터베이스에
+// 저장된 해시가 어떤 방식으로 만들어졌는지 Match 함수로 구분할 수 있어야 한다.
+type PasswordHasher interface {
+	// Name 함수는 [password] 섹션의 hasher 항목에 사용하는 이름을 반환한다.
+	Name() string
+	// Match 함수는 encoded가 이 방식으로 만들어진 해시인지 여부를 리턴한다.
+	Match(encoded string) bool
+	// Hash 함수는 비밀번호를 해시한 문자열을 반환한다.
+	Hash(password string) (string, error)
+	// Verify 함수는 비밀번호가 해시와 일치하는지 여부를 리턴한다.
+	Verify(encoded string, password string) (bool, error)
+	// NeedsRehash 함수는 해시의 파라미터가 현재 기본값과 달라 다시 해시해야 하는지 여부를 리턴한다.
+	NeedsRehash(encoded string) bool
+}
+
+// 비밀번호 해시 파라미터. 값을 올리면 다음 로그인 시에 자동으로 다시 해시된다.
+const (
+	PasswordHashMaxSize = 255 // 데이터베이스에 저장되는 비밀번호 해시 문자열 최대 길이
+
+	argon2Version = argon2.Version
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024 // KiB
+	argon2Threads = 2
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+
+	bcryptCost = 12
+	// bcrypt는 비밀번호의 72바이트 이후를 무시한다. 한글은 한 글자가 3바이트이므로 글자 수 제한보다
+	// 먼저 걸릴 수 있다.
+	bcryptMaxPasswordSize = 72
+)
+
+var (
+	errUnknownPasswordHash = errors.New("unknown password hash format")
+	errPasswordTooLong     = errors.New("password too long for hasher")
+)
+
+var (
+	hasherMutex     sync.RWMutex
+	passwordHashers []PasswordHasher
+)
+
+func init() {
+	RegisterPasswordHasher(argon2idHasher{})
+	RegisterPasswordHasher(bcryptHasher{})
+	// legacyHasher는 접두어 없이 길이와 문자로만 구분하므로 마지막에 등록한다.
+	RegisterPasswordHasher(legacyHasher{})
+}
+
+// RegisterPasswordHasher 함수는 비밀번호 해시 방식을 추가한다.
+// 해시를 검증할 때에는 등록된 순서대로 Match 함수를 호출하여 처음 일치하는 방식을 사용한다.
+func RegisterPasswordHasher(h PasswordHasher) {
+	hasherMutex.Lock()
+	defer hasherMutex.Unlock()
+	passwordHashers = append(passwordHashers, h)
+}
+
+func findPasswordHasher(match func(PasswordHasher) bool) PasswordHasher {
+	hasherMutex.RLock()
+	defer hasherMutex.RUnlock()
+	for _, h := range passwordHashers {
+		if match(h) {
+			return h
+		}
+	}
+	return nil
+}
+
+// HashPassword 함수는 [password] 섹션의 hasher 항목에 설정된 방식으로 비밀번호를 해시한다.
+func HashPassword(password string) (string, error) {
+	name := Config().PasswordHasher()
+	h := findPasswordHasher(func(h PasswordHasher) bool { return h.Name() == name })
+	if h == nil {
+		return "", fmt.Errorf("unknown password hasher. hasher=%s", name)
+	}
+	return h.Hash(password)
+}
+
+// VerifyPassword 함수는 비밀번호가 저장된 해시와 일치하는지 확인한다.
+// 일치하고 해시가 현재 기본 방식이나 파라미터로 만들어지지 않았다면 rehash로 true를 리턴한다.
+// 이 경우 호출하는 쪽에서 HashPassword로 다시 해시하여 저장해야 한다.
+func VerifyPassword(encoded string, password string) (ok bool, rehash bool, err error) {
+	if encoded == "" {
+		return false, false, nil
+	}
+	h := findPasswordHasher(func(h PasswordHasher) bool { return h.Match(encoded) })
+	if h == nil {
+		return false, false, errUnknownPasswordHash
+	}
+	if ok, err = h.Verify(encoded, password); err != nil || !ok {
+		return false, false, err
+	}
+	rehash = h.Name() != Config().PasswordHasher() || h.NeedsRehash(encoded)
+	return true, rehash, nil
+}
+
+// argon2idHasher 는 argon2id 방식이다. 해시는 PHC 문자열 형식으로 저장된다.
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type argon2idHasher struct{}
+
+func (argon2idHasher) Name() string {
+	return "argon2id"
+}
+
+func (argon2idHasher) Match(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+type argon2Params struct {
+	version int
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2id(encoded string) (*argon2Params, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return nil, errUnknownPasswordHash
+	}
+
+	var p argon2Params
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &p.version); err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (argon2idHasher) Verify(encoded string, password string) (bool, error) {
+	p, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	if p.version != argon2Version {
+		return false, errUnknownPasswordHash
+	}
+	key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (argon2idHasher) NeedsRehash(encoded string) bool {
+	p, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return p.memory != argon2Memory || p.time != argon2Time || p.threads != argon2Threads ||
+		len(p.salt) != argon2SaltLen || len(p.key) != argon2KeyLen
+}
+
+// bcryptHasher 는 bcrypt 방식이다. 해시는 bcrypt의 modular crypt 형식($2a$...)으로 저장된다.
+type bcryptHasher struct{}
+
+func (bcryptHasher) Name() string {
+	return "bcrypt"
+}
+
+func (bcryptHasher) Match(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+func (bcryptHasher) Hash(password string) (string, error) {
+	// 잘린 비밀번호로 해시하지 않도록 길이를 넘으면 오류를 리턴한다. 정책 검사에서 미리 걸러진다.
+	if len(password) > bcryptMaxPasswordSize {
+		return "", errPasswordTooLong
+	}
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (bcryptHasher) Verify(encoded string, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (bcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != bcryptCost
+}
+
+// legacyHasher 는 이전 버전에서 사용하던 방식이다. SaltMaxSize 길이의 salt 뒤에 해시가 붙은
+// legacyPasswordHashSize 길이의 16진수 문자열이며 새 비밀번호에는 사용하지 않고 로그인 시에 현재 기본
+// 방식으로 다시 해시된다.
+type legacyHasher struct{}
+
+// legacyPasswordHashSize 는 이전 방식 해시 문자열의 길이이다.
+const legacyPasswordHashSize = SaltMaxSize + PasswordMaxSize
+
+func (legacyHasher) Name() string {
+	return "legacy"
+}
+
+func (legacyHasher) Match(encoded string) bool {
+	if len(encoded) != legacyPasswordHashSize {
+		return false
+	}
+	for _, c := range encoded {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+func (legacyHasher) Hash(password string) (string, error) {
+	salt := crypto.NewSalt(SaltMaxSize)
+	return string(crypto.SecurePassword(salt, []byte(password))), nil
+}
+
+func (legacyHasher) Verify(encoded string, password string) (bool, error) {
+	salt := []byte(encoded[:SaltMaxSize])
+	hashed := string(crypto.SecurePassword(salt, []byte(password)))
+	return subtle.ConstantTimeCompare([]byte(hashed), []byte(encoded)) == 1, nil
+}
+
+func (legacyHasher) NeedsRehash(encoded string) bool {
+	return false
+}
@@ -0,0 +1,85 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHasherRoundTrip(t *testing.T) {
+	hashers := []PasswordHasher{argon2idHasher{}, bcryptHasher{}, legacyHasher{}}
+	for _, h := range hashers {
+		encoded, err := h.Hash("correct horse")
+		if err != nil {
+			t.Fatalf("%s: hash failed. err=%v", h.Name(), err)
+		}
+		if !h.Match(encoded) {
+			t.Errorf("%s: does not match its own hash %q", h.Name(), encoded)
+		}
+		for _, other := range hashers {
+			if other.Name() != h.Name() && other.Match(encoded) {
+				t.Errorf("%s: hash %q also matches %s", h.Name(), encoded, other.Name())
+			}
+		}
+		if ok, err := h.Verify(encoded, "correct horse"); err != nil || !ok {
+			t.Errorf("%s: verify failed. ok=%v, err=%v", h.Name(), ok, err)
+		}
+		if ok, err := h.Verify(encoded, "wrong horse"); err != nil || ok {
+			t.Errorf("%s: wrong password verified. ok=%v, err=%v", h.Name(), ok, err)
+		}
+		if h.NeedsRehash(encoded) {
+			t.Errorf("%s: fresh hash needs rehash", h.Name())
+		}
+	}
+}
+
+func TestPasswordHasherNeedsRehash(t *testing.T) {
+	argon := "$argon2id$v=19$m=4096,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	if !(argon2idHasher{}).NeedsRehash(argon) {
+		t.Error("argon2id: weaker parameters should need rehash")
+	}
+	if !(argon2idHasher{}).NeedsRehash("$argon2id$broken") {
+		t.Error("argon2id: malformed hash should need rehash")
+	}
+
+	weak, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !(bcryptHasher{}).NeedsRehash(string(weak)) {
+		t.Error("bcrypt: lower cost should need rehash")
+	}
+}
+
+func TestLegacyHasherMatch(t *testing.T) {
+	cases := []struct {
+		encoded string
+		match   bool
+	}{
+		{strings.Repeat("0123456789abcdef", 3), true},
+		{strings.Repeat("0123456789ABCDEF", 3), true},
+		{strings.Repeat("a", legacyPasswordHashSize-1), false},
+		{strings.Repeat("a", legacyPasswordHashSize+1), false},
+		{strings.Repeat("z", legacyPasswordHashSize), false},
+		{"$" + strings.Repeat("a", legacyPasswordHashSize-1), false},
+		{"plaintext-password", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if got := (legacyHasher{}).Match(c.encoded); got != c.match {
+			t.Errorf("Match(%q) = %v, want %v", c.encoded, got, c.match)
+		}
+	}
+}
+
+func TestBcryptHasherTooLong(t *testing.T) {
+	h := bcryptHasher{}
+	if _, err := h.Hash(strings.Repeat("a", bcryptMaxPasswordSize)); err != nil {
+		t.Errorf("%d bytes should be hashed. err=%v", bcryptMaxPasswordSize, err)
+	}
+	// 한글 25자는 75바이트이다.
+	if _, err := h.Hash(strings.Repeat("가", 25)); err != errPasswordTooLong {
+		t.Errorf("password over %d bytes should be rejected. err=%v", bcryptMaxPasswordSize, err)
+	}
+}
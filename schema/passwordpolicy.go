@@ -0,0 +1,257 @@
+package schema
+
+import (
+	"bufio"
+	"encoding/gob"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/gorp.v1"
+	"jsproj.com/koo/gosari/utils"
+)
+
+// 비밀번호 정책 위반 사유. 가입, 비밀번호 변경, 재설정 응답의 fields 항목으로 클라이언트에 그대로
+// 전달되므로 값을 바꾸면 클라이언트도 함께 바뀌어야 한다.
+const (
+	PasswordTooShort       = "too_short"        // 최소 길이보다 짧음
+	PasswordTooLong        = "too_long"         // 최대 길이보다 김
+	PasswordFewCharClasses = "few_char_classes" // 문자 종류(대문자, 소문자, 숫자, 특수문자)가 부족함
+	PasswordContainsEmail  = "contains_email"   // 이메일의 @ 앞부분을 포함함
+	PasswordTooCommon      = "too_common"       // 흔히 사용되는 비밀번호임
+	PasswordReused         = "reused"           // 최근에 사용한 비밀번호임
+)
+
+// 비밀번호 정책 기본값. [passwordpolicy] 섹션에서 바꿀 수 있다.
+const (
+	defaultPasswordMinLength = 8
+	defaultPasswordMaxLength = 128
+	defaultPasswordHistory   = 5
+
+	emailLocalPartMinSize = 3 // 이보다 짧은 이메일 앞부분은 비밀번호에 포함 되어도 허용한다.
+)
+
+// PasswordHistory 객체는 사용자가 이전에 사용한 비밀번호의 해시 스키마 객체이다. 여기에서 정의된 형태로
+// 데이터베이스 테이블이 작성된다. 따라서 이 코드를 바꾸는 경우 반드시 DB 마이그레이션이 필요하다.
+type PasswordHistory struct {
+	PHID    int64  `db:"phid" json:"-"`
+	UID     int64  `db:"uid" json:"uid"`
+	Hash    string `db:"hash" json:"-"`
+	Created int64  `db:"created" json:"created"`
+}
+
+// commonPasswords 는 흔히 사용되는 비밀번호 목록이다.
+// [passwordpolicy] 섹션의 commonpasswordfile 파일을 처음 사용할 때 읽는다.
+var (
+	commonMutex     sync.Mutex
+	commonFile      string
+	commonPasswords map[string]bool
+)
+
+func loadCommonPasswords(path string) map[string]bool {
+	commonMutex.Lock()
+	defer commonMutex.Unlock()
+
+	if commonPasswords != nil && commonFile == path {
+		return commonPasswords
+	}
+
+	list := make(map[string]bool)
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Errorf("common password file open error. file=%s, err=%v", path, err)
+		} else {
+			defer f.Close()
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				list[strings.ToLower(line)] = true
+			}
+			if err := scanner.Err(); err != nil {
+				log.Errorf("common password file read error. file=%s, err=%v", path, err)
+			}
+		}
+	}
+
+	commonFile = path
+	commonPasswords = list
+	return list
+}
+
+// charClasses 함수는 비밀번호에 포함된 문자 종류(대문자, 소문자, 숫자, 특수문자)의 수를 센다.
+func charClasses(password string) int {
+	var upper, lower, digit, symbol int
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			upper = 1
+		case unicode.IsLower(c):
+			lower = 1
+		case unicode.IsDigit(c):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return upper + lower + digit + symbol
+}
+
+// CheckPasswordPolicy 함수는 비밀번호가 [passwordpolicy] 섹션에 설정된 정책을 지키는지 검사하고
+// 위반 사유 목록을 리턴한다. id는 비밀번호에 이메일의 앞부분이 포함 되었는지 검사하는데 사용된다.
+// 데이터베이스가 필요한 비밀번호 재사용 검사는 CheckPasswordHistory 함수에서 한다.
+func CheckPasswordPolicy(id string, password string) []string {
+	conf := Config()
+	reasons := []string{}
+
+	length := utf8.RuneCountInString(password)
+	if length < conf.PasswordMinLength() {
+		reasons = append(reasons, PasswordTooShort)
+	}
+	if length > conf.PasswordMaxLength() ||
+		conf.PasswordHasher() == (bcryptHasher{}).Name() && len(password) > bcryptMaxPasswordSize {
+		reasons = append(reasons, PasswordTooLong)
+	}
+	if charClasses(password) < conf.PasswordPolicy.MinCharClasses {
+		reasons = append(reasons, PasswordFewCharClasses)
+	}
+
+	lower := strings.ToLower(password)
+	if conf.IsRejectEmailInPassword() {
+		local := strings.ToLower(strings.Split(id, "@")[0])
+		if len(local) >= emailLocalPartMinSize && strings.Contains(lower, local) {
+			reasons = append(reasons, PasswordContainsEmail)
+		}
+	}
+	if loadCommonPasswords(conf.PasswordPolicy.CommonPasswordFile)[lower] {
+		reasons = append(reasons, PasswordTooCommon)
+	}
+
+	return reasons
+}
+
+// CheckPasswordHistory 함수는 비밀번호가 현재 비밀번호이거나 최근에 사용한 비밀번호인지 검사한다.
+func CheckPasswordHistory(user *User, password string) (bool, error) {
+	db := Database()
+
+	if ok, _, err := VerifyPassword(user.Password, password); err == nil && ok {
+		return true, nil
+	}
+
+	size := Config().PasswordHistorySize()
+	if size == 0 {
+		return false, nil
+	}
+
+	var history []*PasswordHistory
+	_, err := db.Auth.Select(&history,
+		"select * from passwordhistory where uid=? order by created desc, phid desc limit ?", user.UID, size)
+	if err != nil {
+		return false, err
+	}
+	for _, h := range history {
+		if ok, _, err := VerifyPassword(h.Hash, password); err == nil && ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ValidateNewPassword 함수는 사용자의 새 비밀번호가 정책을 지키는지 검사하고 위반 사유 목록을 리턴한다.
+// 아직 가입 전인 사용자(UID가 0)는 비밀번호 재사용 검사를 하지 않는다.
+func ValidateNewPassword(user *User, password string) ([]string, error) {
+	reasons := CheckPasswordPolicy(user.ID, password)
+	if user.UID == 0 {
+		return reasons, nil
+	}
+
+	reused, err := CheckPasswordHistory(user, password)
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		reasons = append(reasons, PasswordReused)
+	}
+	return reasons, nil
+}
+
+// AddPasswordHistory 함수는 사용자의 현재 비밀번호를 이력에 추가하고 설정된 개수보다 오래된 이력을 지운다.
+func AddPasswordHistory(user *User) error {
+	return addPasswordHistory(Database().Auth, user)
+}
+
+// addPasswordHistory 함수는 AddPasswordHistory 함수를 exec(트랜잭션일 수 있음)로 실행한다.
+func addPasswordHistory(exec gorp.SqlExecutor, user *User) error {
+	size := Config().PasswordHistorySize()
+	if size == 0 {
+		return nil
+	}
+
+	h := &PasswordHistory{
+		UID:     user.UID,
+		Hash:    user.Password,
+		Created: utils.ServerTime(),
+	}
+	if err := exec.Insert(h); err != nil {
+		return err
+	}
+
+	// 최근 size개를 제외한 이력을 지운다.
+	var keep []*PasswordHistory
+	_, err := exec.Select(&keep,
+		"select * from passwordhistory where uid=? order by created desc, phid desc limit ?", user.UID, size)
+	if err != nil || len(keep) < size {
+		return err
+	}
+	_, err = exec.Exec("delete from passwordhistory where uid=? and phid<?", user.UID, keep[len(keep)-1].PHID)
+	return err
+}
+
+// ChangePassword 함수는 사용자의 비밀번호를 바꾸어 저장하고 비밀번호 이력에 추가한다.
+// 기존에 발급된 모든 토큰은 폐기되며 비밀번호 변경 강제 상태도 해제된다.
+// 새 비밀번호는 미리 ValidateNewPassword 함수로 검사 되어야 한다.
+func ChangePassword(user *User, password string) error {
+	return changePassword(user, password, nil)
+}
+
+// changePassword 함수는 비밀번호를 바꾸면서 f를 같은 트랜잭션에서 실행한다. f는 nil일 수 있다.
+func changePassword(user *User, password string, f StoreTxFunc) error {
+	db := Database()
+
+	changed := *user
+	if err := changed.SetPassword(password); err != nil {
+		return err
+	}
+	changed.PasswordTmp = ""
+	changed.MustChangePass = false
+	err := db.Users.UpdateUserWith(&changed, func(tx gorp.SqlExecutor) error {
+		if f != nil {
+			if err := f(tx); err != nil {
+				return err
+			}
+		}
+		return addPasswordHistory(tx, &changed)
+	})
+	if err != nil {
+		return err
+	}
+	*user = changed
+
+	// 비밀번호가 바뀌었으므로 다른 기기를 포함한 모든 세션을 로그아웃 시킨다.
+	if err := RevokeTokensIssuedBefore(user.UID, ServerTimeMilli()); err != nil {
+		return err
+	}
+	return RevokeSessionsOfUser(user.UID, "")
+}
+
+func createPasswordHistoryTable(dbmap *gorp.DbMap) {
+	gob.Register(&PasswordHistory{})
+	table := dbmap.AddTableWithName(PasswordHistory{}, "passwordhistory").SetKeys(true, "PHID")
+	table.ColMap("Hash").SetMaxSize(PasswordHashMaxSize)
+}
@@ -0,0 +1,103 @@
+package schema
+
+import (
+	"encoding/gob"
+	"errors"
+
+	"gopkg.in/gorp.v1"
+	"jsproj.com/koo/gosari/utils"
+)
+
+// PasswordReset 객체는 비밀번호 재설정 요청 스키마 객체이다. 여기에서 정의된 형태로 데이터베이스
+// 테이블이 작성된다. 따라서 이 코드를 바꾸는 경우 반드시 DB 마이그레이션이 필요하다.
+//
+// 재설정 토큰은 이메일 링크로만 전달되며 데이터베이스에는 해시만 저장된다. 토큰은 한 번만 사용할 수
+// 있고 [password] 섹션의 resetexpireminute 이후에는 만료된다.
+type PasswordReset struct {
+	PRID    int64  `db:"prid" json:"-"`
+	UID     int64  `db:"uid" json:"uid"`         // 재설정 대상 사용자
+	Hash    string `db:"hash" json:"-"`          // 토큰의 sha256 해시
+	Created int64  `db:"created" json:"created"` // 요청 시각
+	Expire  int64  `db:"expire" json:"expire"`   // 만료 시각
+	Used    int64  `db:"used" json:"used"`       // 사용한 시각. 0이면 아직 사용 전
+}
+
+// ErrPasswordResetInvalid 는 재설정 토큰이 없거나, 이미 사용되었거나, 만료된 경우의 오류이다.
+var ErrPasswordResetInvalid = errors.New("invalid password reset token")
+
+// CreatePasswordReset 함수는 사용자의 비밀번호 재설정 토큰을 발급한다.
+// 이전에 발급되어 아직 사용하지 않은 토큰은 모두 무효화 된다.
+func CreatePasswordReset(user *User) (string, error) {
+	db := Database()
+	now := utils.ServerTime()
+
+	if _, err := db.Auth.Exec("update passwordresets set used=? where uid=? and used=0", now, user.UID); err != nil {
+		return "", err
+	}
+
+	token, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	pr := &PasswordReset{
+		UID:     user.UID,
+		Hash:    hashSecretToken(token),
+		Created: now,
+		Expire:  now + Config().PasswordResetExpire(),
+	}
+	if err := db.Auth.Insert(pr); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// FindPasswordReset 함수는 사용 가능한 재설정 토큰을 찾는다. 토큰을 사용 처리하지는 않는다.
+func FindPasswordReset(token string) (*PasswordReset, error) {
+	db := Database()
+	var pr PasswordReset
+	err := db.Auth.SelectOne(&pr, "select * from passwordresets where hash=?", hashSecretToken(token))
+	if err != nil {
+		return nil, ErrPasswordResetInvalid
+	}
+	if pr.Used != 0 || pr.Expire < utils.ServerTime() {
+		return nil, ErrPasswordResetInvalid
+	}
+	return &pr, nil
+}
+
+// consumePasswordReset 함수는 재설정 토큰을 사용 처리한다. 이미 사용되었거나 만료된 경우
+// ErrPasswordResetInvalid를 리턴한다.
+func consumePasswordReset(exec gorp.SqlExecutor, prid int64) error {
+	now := utils.ServerTime()
+	result, err := exec.Exec("update passwordresets set used=? where prid=? and used=0 and expire>=?", now, prid, now)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n != 1 {
+		return ErrPasswordResetInvalid
+	}
+	return nil
+}
+
+// ResetPassword 함수는 재설정 토큰을 사용 처리하면서 비밀번호를 바꾼다. 토큰 사용과 비밀번호 변경은
+// 하나의 트랜잭션으로 처리되므로 비밀번호를 바꾸지 못하면 토큰도 다시 사용할 수 있다. 같은 토큰으로
+// 동시에 요청이 들어오더라도 하나만 성공하며 나머지는 ErrPasswordResetInvalid를 리턴한다.
+func ResetPassword(pr *PasswordReset, user *User, password string) error {
+	if pr.UID != user.UID {
+		return ErrPasswordResetInvalid
+	}
+	return changePassword(user, password, func(tx gorp.SqlExecutor) error {
+		return consumePasswordReset(tx, pr.PRID)
+	})
+}
+
+func createPasswordResetTable(dbmap *gorp.DbMap) {
+	gob.Register(&PasswordReset{})
+	table := dbmap.AddTableWithName(PasswordReset{}, "passwordresets").SetKeys(true, "PRID")
+	table.ColMap("Hash").SetMaxSize(SecretHashSize)
+	table.ColMap("Hash").SetUnique(true)
+}
@@ -0,0 +1,263 @@
+package schema
+
+import (
+	"encoding/gob"
+	"errors"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/gorp.v1"
+)
+
+// Role 객체는 권한의 묶음인 역할 스키마 객체이다. 여기에서 정의된 형태로 데이터베이스 테이블이
+// 작성된다. 따라서 이 코드를 바꾸는 경우 반드시 DB 마이그레이션이 필요하다.
+type Role struct {
+	Name        string `db:"name" json:"name"`
+	Description string `db:"description" json:"description"`
+}
+
+// RolePermission 객체는 역할에 부여된 권한 스키마 객체이다.
+type RolePermission struct {
+	RPID       int64  `db:"rpid" json:"-"`
+	Role       string `db:"role" json:"role"`
+	Permission string `db:"permission" json:"permission"`
+}
+
+// UserRole 객체는 사용자에게 부여된 역할 스키마 객체이다.
+type UserRole struct {
+	URID int64  `db:"urid" json:"-"`
+	UID  int64  `db:"uid" json:"uid"`
+	Role string `db:"role" json:"role"`
+}
+
+// 운영 api를 호출하는 데 필요한 권한. 라우트를 등록할 때 필요한 권한을 지정한다.
+const (
+	PermAll               = "*"              // 모든 권한
+	PermConfigReload      = "config.reload"  // 설정 다시 읽기
+	PermConfigRead        = "config.read"    // 현재 설정 조회(비밀 항목 제외)
+	PermUserRead          = "user.read"      // 사용자 목록 및 정보 조회
+	PermUserBlock         = "user.block"     // 사용자 블럭
+	PermUserUnblock       = "user.unblock"   // 사용자 블럭 해제
+	PermUserActivate      = "user.activate"  // 사용자 이메일 인증 처리
+	PermUserResetPassword = "user.resetpass" // 사용자 비밀번호 변경 강제
+	PermUserTwoFactor     = "user.2fa.reset" // 사용자 2단계 인증 해제
+	PermUserRole          = "user.role"      // 사용자 역할 부여 및 회수
+	PermAuditRead         = "audit.read"     // 감사 기록 조회 및 내보내기
+	PermMailRead          = "mail.read"      // 메일 발송 대기열 조회
+)
+
+// 기본 역할. 서버가 시작될 때 데이터베이스에 없으면 만들어진다.
+const (
+	RoleAdmin   = "admin"   // 모든 권한을 가진 운영자. UserTypeAdmin 사용자는 이 역할을 가진 것으로 취급한다.
+	RoleSupport = "support" // 사용자 문의를 처리하는 고객 지원 담당자
+)
+
+// Role 스키마 상수 정의. 이곳에서 사용되는 상수는 데이터베이스에 반영되므로 값을 변경하면 안된다.
+// 불가피하게 값을 변경해야 할 경우는 기존 데이터베이스가 마이그레이션 되어야 한다.
+const (
+	RoleNameMaxSize       = 50  // 역할 이름 최대 길이
+	RoleDescMaxSize       = 255 // 역할 설명 최대 길이
+	PermissionNameMaxSize = 50  // 권한 이름 최대 길이
+)
+
+var defaultRoles = []struct {
+	role        Role
+	permissions []string
+}{
+	{Role{RoleAdmin, "Administrator with every permission."}, []string{PermAll}},
+	{Role{RoleSupport, "Support staff who can look up and help users."}, []string{
+		PermUserRead,
+		PermUserUnblock,
+		PermUserActivate,
+		PermUserResetPassword,
+		PermUserTwoFactor,
+	}},
+}
+
+// ErrRoleNotFound 는 존재하지 않는 역할을 부여하려는 경우의 오류이다.
+var ErrRoleNotFound = errors.New("role not found")
+
+// mustSeedRoles 함수는 기본 역할과 권한이 데이터베이스에 없으면 만든다.
+// 이미 있는 역할의 권한은 운영 중에 바뀌었을 수 있으므로 건드리지 않는다.
+func mustSeedRoles(dbmap *gorp.DbMap) {
+	for _, d := range defaultRoles {
+		n, err := dbmap.SelectInt("select count(*) from roles where name=?", d.role.Name)
+		if err != nil {
+			log.Fatalf("role seed error. role=%s, err=%v", d.role.Name, err)
+		}
+		if n > 0 {
+			continue
+		}
+		role := d.role
+		if err := dbmap.Insert(&role); err != nil {
+			log.Fatalf("role seed error. role=%s, err=%v", d.role.Name, err)
+		}
+		for _, p := range d.permissions {
+			if err := dbmap.Insert(&RolePermission{Role: d.role.Name, Permission: p}); err != nil {
+				log.Fatalf("role seed error. role=%s, err=%v", d.role.Name, err)
+			}
+		}
+		log.Infof("role %s created.", d.role.Name)
+	}
+}
+
+// HasPermission 함수는 사용자가 perm 권한을 가지고 있는지 여부를 리턴한다.
+// 이전 버전과의 호환을 위해 UserTypeAdmin 사용자는 모든 권한을 가진다.
+func (u User) HasPermission(perm string) (bool, error) {
+	if u.IsAdmin() {
+		return true, nil
+	}
+	db := Database()
+	n, err := db.Auth.SelectInt(
+		"select count(*) from userroles ur join rolepermissions rp on ur.role=rp.role"+
+			" where ur.uid=? and (rp.permission=? or rp.permission=?)", u.UID, perm, PermAll)
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// LoadUserPermissions 함수는 사용자가 역할로 가진 권한의 집합을 읽는다.
+// UserTypeAdmin 사용자는 PermAll을 가진다.
+func LoadUserPermissions(u *User) (map[string]bool, error) {
+	if u.IsAdmin() {
+		return map[string]bool{PermAll: true}, nil
+	}
+	db := Database()
+	var perms []string
+	_, err := db.Auth.Select(&perms,
+		"select distinct rp.permission from userroles ur join rolepermissions rp on ur.role=rp.role where ur.uid=?", u.UID)
+	if err != nil {
+		return nil, err
+	}
+	return permissionSet(perms), nil
+}
+
+// loadRolePermissions 함수는 역할에 부여된 권한의 집합을 읽는다. 역할이 없으면 ErrRoleNotFound를 리턴한다.
+func loadRolePermissions(role string) (map[string]bool, error) {
+	db := Database()
+	n, err := db.Auth.SelectInt("select count(*) from roles where name=?", role)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrRoleNotFound
+	}
+	var perms []string
+	if _, err := db.Auth.Select(&perms, "select permission from rolepermissions where role=?", role); err != nil {
+		return nil, err
+	}
+	return permissionSet(perms), nil
+}
+
+func permissionSet(perms []string) map[string]bool {
+	set := make(map[string]bool, len(perms))
+	for _, p := range perms {
+		set[p] = true
+	}
+	return set
+}
+
+// coversPermissions 함수는 have가 need의 모든 권한을 포함하는지 여부를 리턴한다.
+func coversPermissions(have map[string]bool, need map[string]bool) bool {
+	if have[PermAll] {
+		return true
+	}
+	for p := range need {
+		if !have[p] {
+			return false
+		}
+	}
+	return true
+}
+
+// CanManage 함수는 운영자 u가 target 사용자를 관리(블럭, 역할 변경 등)할 수 있는지 여부를 리턴한다.
+// 자신이 가지지 않은 권한을 가진 사용자는 관리할 수 없으므로 고객 지원 담당자는 운영자를 관리할 수 없다.
+func (u User) CanManage(target *User) (bool, error) {
+	have, err := LoadUserPermissions(&u)
+	if err != nil {
+		return false, err
+	}
+	need, err := LoadUserPermissions(target)
+	if err != nil {
+		return false, err
+	}
+	return coversPermissions(have, need), nil
+}
+
+// CanGrantRole 함수는 운영자 u가 role 역할을 부여하거나 회수할 수 있는지 여부를 리턴한다.
+// 역할의 모든 권한을 가진 경우에만 가능하므로 PermUserRole 권한만으로 RoleAdmin을 부여할 수 없다.
+// 역할이 없으면 ErrRoleNotFound를 리턴한다.
+func (u User) CanGrantRole(role string) (bool, error) {
+	need, err := loadRolePermissions(role)
+	if err != nil {
+		return false, err
+	}
+	have, err := LoadUserPermissions(&u)
+	if err != nil {
+		return false, err
+	}
+	return coversPermissions(have, need), nil
+}
+
+// LoadUserRoles 함수는 사용자에게 부여된 역할의 이름 목록을 읽는다.
+// UserTypeAdmin 사용자는 부여되지 않았더라도 RoleAdmin을 포함한다.
+func LoadUserRoles(u *User) ([]string, error) {
+	db := Database()
+	var urs []*UserRole
+	if _, err := db.Auth.Select(&urs, "select * from userroles where uid=? order by role", u.UID); err != nil {
+		return nil, err
+	}
+	roles := make([]string, 0, len(urs)+1)
+	hasAdmin := false
+	for _, ur := range urs {
+		roles = append(roles, ur.Role)
+		hasAdmin = hasAdmin || ur.Role == RoleAdmin
+	}
+	if u.IsAdmin() && !hasAdmin {
+		roles = append(roles, RoleAdmin)
+	}
+	return roles, nil
+}
+
+// GrantRole 함수는 사용자에게 역할을 부여한다. 이미 부여된 역할이면 아무 처리도 하지 않는다.
+func GrantRole(uid int64, role string) error {
+	db := Database()
+	n, err := db.Auth.SelectInt("select count(*) from roles where name=?", role)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRoleNotFound
+	}
+	n, err = db.Auth.SelectInt("select count(*) from userroles where uid=? and role=?", uid, role)
+	if err != nil || n > 0 {
+		return err
+	}
+	return db.Auth.Insert(&UserRole{UID: uid, Role: role})
+}
+
+// RevokeRole 함수는 사용자에게 부여된 역할을 회수한다.
+func RevokeRole(uid int64, role string) error {
+	db := Database()
+	_, err := db.Auth.Exec("delete from userroles where uid=? and role=?", uid, role)
+	return err
+}
+
+func createRoleTables(dbmap *gorp.DbMap) {
+	gob.Register(&Role{})
+	gob.Register(&RolePermission{})
+	gob.Register(&UserRole{})
+
+	table := dbmap.AddTableWithName(Role{}, "roles").SetKeys(false, "Name")
+	table.ColMap("Name").SetMaxSize(RoleNameMaxSize)
+	table.ColMap("Description").SetMaxSize(RoleDescMaxSize)
+
+	table = dbmap.AddTableWithName(RolePermission{}, "rolepermissions").SetKeys(true, "RPID")
+	table.ColMap("Role").SetMaxSize(RoleNameMaxSize)
+	table.ColMap("Permission").SetMaxSize(PermissionNameMaxSize)
+	table.SetUniqueTogether("role", "permission")
+
+	table = dbmap.AddTableWithName(UserRole{}, "userroles").SetKeys(true, "URID")
+	table.ColMap("Role").SetMaxSize(RoleNameMaxSize)
+	table.SetUniqueTogether("uid", "role")
+}
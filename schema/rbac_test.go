@@ -0,0 +1,33 @@
+package schema
+
+import (
+	"testing"
+)
+
+func TestCoversPermissions(t *testing.T) {
+	admin := permissionSet([]string{PermAll})
+	support := permissionSet([]string{PermUserRead, PermUserUnblock, PermUserActivate})
+	reader := permissionSet([]string{PermUserRead})
+	none := permissionSet(nil)
+
+	cases := []struct {
+		name string
+		have map[string]bool
+		need map[string]bool
+		want bool
+	}{
+		{"admin over support", admin, support, true},
+		{"admin over admin", admin, admin, true},
+		{"support over admin", support, admin, false},
+		{"support over reader", support, reader, true},
+		{"reader over support", reader, support, false},
+		{"support over support", support, support, true},
+		{"none over none", none, none, true},
+		{"none over reader", none, reader, false},
+	}
+	for _, c := range cases {
+		if got := coversPermissions(c.have, c.need); got != c.want {
+			t.Errorf("%s: coversPermissions = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
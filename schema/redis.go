@@ -0,0 +1,47 @@
+package schema
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	redisMaxIdle     = 10
+	redisIdleTimeout = 240 * time.Second
+)
+
+var (
+	redisPool *redis.Pool
+)
+
+func mustInitRedis(conf *Configure) {
+	addr := fmt.Sprintf("%s:%d", conf.Redis.Host, conf.Redis.Port)
+	redisPool = &redis.Pool{
+		MaxIdle:     redisMaxIdle,
+		IdleTimeout: redisIdleTimeout,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr)
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+
+	// 서버 시작 시점에 redis에 접속이 가능한지 확인한다.
+	conn := redisPool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		log.Fatalf("redis connect error. addr=%s, err=%v", addr, err)
+	}
+	log.Info("redis initialized.")
+}
+
+// Redis 함수는 현재 생성된 redis 커넥션 풀을 반환한다.
+// 풀에서 얻어온 커넥션은 사용 후 반드시 Close 해야 한다.
+func Redis() *redis.Pool {
+	return redisPool
+}
@@ -0,0 +1,189 @@
+package schema
+
+import (
+	"database/sql"
+	"encoding/gob"
+	"errors"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/gorp.v1"
+	"jsproj.com/koo/gosari/utils"
+)
+
+// RefreshToken 객체는 사용자의 기기별로 발급된 리프레시 토큰 스키마 객체이다.
+// 여기에서 정의된 형태로 데이터베이스 테이블이 작성된다. 따라서 이 코드를 바꾸는 경우 반드시
+// DB 마이그레이션이 필요하다.
+//
+// 리프레시 토큰은 사용될 때마다 새 토큰으로 교체(rotation)되며, 교체된 토큰들은 같은 Family를
+// 가진다. Family는 로그인 시에 만들어진 세션의 SID와 같다. 이미 교체된 토큰이 다시 사용되면 탈취된
+// 것으로 보고 세션과 같은 Family의 토큰을 모두 폐기한다.
+type RefreshToken struct {
+	RTID    int64  `db:"rtid" json:"-"`
+	UID     int64  `db:"uid" json:"uid"`         // 토큰 소유자
+	Family  string `db:"family" json:"-"`        // 교체 후에도 유지되는 묶음 아이디(세션의 SID)
+	Device  string `db:"device" json:"device"`   // 클라이언트가 알려준 기기 이름
+	Hash    string `db:"hash" json:"-"`          // 토큰의 sha256 해시
+	Created int64  `db:"created" json:"created"` // 발급 시각
+	Expire  int64  `db:"expire" json:"expire"`   // 만료 시각
+	Status  int    `db:"status" json:"status"`   // 상태
+}
+
+// RefreshToken 스키마 상수 정의. 이곳에서 사용되는 상수는 데이터베이스에 반영되므로 값을 변경하면 안된다.
+// 불가피하게 값을 변경해야 할 경우는 기존 데이터베이스가 마이그레이션 되어야 한다.
+const (
+	RefreshTokenStatusActive  = 0 // 사용 가능
+	RefreshTokenStatusUsed    = 1 // 새 토큰으로 교체됨
+	RefreshTokenStatusRevoked = 2 // 폐기됨
+
+	FamilyMaxSize = 36  // 토큰 묶음 아이디(UUID) 길이
+	DeviceMaxSize = 100 // 기기 이름 최대 길이
+)
+
+// 리프레시 토큰 처리 중에 발생하는 오류
+var (
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// IssueRefreshToken 함수는 사용자의 세션에 새 리프레시 토큰을 발급한다.
+// 리턴되는 평문 토큰은 데이터베이스에 저장되지 않는다.
+func IssueRefreshToken(user *User, device string, sid string) (string, error) {
+	return issueRefreshToken(Database().Auth, user, device, sid)
+}
+
+// issueRefreshToken 함수는 IssueRefreshToken 함수를 exec(트랜잭션일 수 있음)로 실행한다.
+func issueRefreshToken(exec gorp.SqlExecutor, user *User, device string, sid string) (string, error) {
+	conf := Config()
+
+	token, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := utils.ServerTime()
+	rt := &RefreshToken{
+		UID:     user.UID,
+		Family:  sid,
+		Device:  truncate(device, DeviceMaxSize),
+		Hash:    hashSecretToken(token),
+		Created: now,
+		Expire:  now + conf.RefreshTokenExpire(),
+		Status:  RefreshTokenStatusActive,
+	}
+	if err := exec.Insert(rt); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// RotateRefreshToken 함수는 리프레시 토큰을 사용 처리하고 같은 세션의 새 리프레시 토큰을 발급한다.
+// 사용자, 세션 아이디, 새 리프레시 토큰을 리턴한다.
+// 이미 사용되었거나 폐기된 토큰이 들어오면 토큰이 탈취된 것으로 보고 세션 전체를 폐기한 뒤
+// ErrRefreshTokenReused를 리턴한다.
+func RotateRefreshToken(token string) (*User, string, string, error) {
+	db := Database()
+
+	var rt RefreshToken
+	err := db.Auth.SelectOne(&rt, "select * from refreshtokens where hash=?", hashSecretToken(token))
+	if err == sql.ErrNoRows {
+		return nil, "", "", ErrRefreshTokenInvalid
+	} else if err != nil {
+		return nil, "", "", err
+	}
+
+	if rt.Status != RefreshTokenStatusActive {
+		log.WithFields(log.Fields{
+			"uid":    rt.UID,
+			"family": rt.Family,
+			"device": rt.Device,
+		}).Warn("REFRESH_TOKEN_REUSED")
+		if err := revokeFamily(&rt); err != nil {
+			return nil, "", "", err
+		}
+		return nil, "", "", ErrRefreshTokenReused
+	}
+
+	if rt.Expire < utils.ServerTime() {
+		return nil, "", "", ErrRefreshTokenExpired
+	}
+
+	// 세션이 폐기 되었다면 이어서 사용할 수 없다.
+	session, err := LoadActiveSession(rt.UID, rt.Family)
+	if err == ErrSessionRevoked {
+		return nil, "", "", ErrRefreshTokenInvalid
+	} else if err != nil {
+		return nil, "", "", err
+	}
+
+	user, err := LoadUserFromUID(rt.UID)
+	if err == ErrNotFound {
+		return nil, "", "", ErrRefreshTokenInvalid
+	} else if err != nil {
+		return nil, "", "", err
+	}
+
+	// 이전 토큰의 사용 처리와 새 토큰 발급을 하나의 트랜잭션으로 처리한다. 발급에 실패했는데 이전 토큰만
+	// 사용 처리되면 클라이언트가 다시 시도할 때 재사용으로 판단되어 세션이 폐기된다.
+	tx, err := db.Auth.Begin()
+	if err != nil {
+		return nil, "", "", err
+	}
+	// 동시에 같은 토큰으로 두 번 요청이 들어오는 경우 하나만 성공하도록 상태를 조건으로 건다.
+	result, err := tx.Exec("update refreshtokens set status=? where rtid=? and status=?",
+		RefreshTokenStatusUsed, rt.RTID, RefreshTokenStatusActive)
+	if err != nil {
+		tx.Rollback()
+		return nil, "", "", err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		tx.Rollback()
+		return nil, "", "", err
+	} else if n != 1 {
+		tx.Rollback()
+		if err := revokeFamily(&rt); err != nil {
+			return nil, "", "", err
+		}
+		return nil, "", "", ErrRefreshTokenReused
+	}
+
+	newToken, err := issueRefreshToken(tx, user, rt.Device, rt.Family)
+	if err != nil {
+		tx.Rollback()
+		return nil, "", "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, "", "", err
+	}
+
+	if err := TouchSession(session); err != nil {
+		return nil, "", "", err
+	}
+
+	return user, rt.Family, newToken, nil
+}
+
+// revokeFamily 함수는 재사용된 리프레시 토큰의 세션과 묶음 전체를 폐기한다.
+func revokeFamily(rt *RefreshToken) error {
+	if err := RevokeSession(rt.UID, rt.Family); err != nil && err != ErrSessionRevoked {
+		return err
+	}
+	return RevokeRefreshTokenFamily(rt.Family)
+}
+
+// RevokeRefreshTokenFamily 함수는 같은 묶음의 리프레시 토큰을 모두 폐기한다.
+func RevokeRefreshTokenFamily(family string) error {
+	db := Database()
+	_, err := db.Auth.Exec("update refreshtokens set status=? where family=?", RefreshTokenStatusRevoked, family)
+	return err
+}
+
+func createRefreshTokenTable(dbmap *gorp.DbMap) {
+	gob.Register(&RefreshToken{})
+	table := dbmap.AddTableWithName(RefreshToken{}, "refreshtokens").SetKeys(true, "RTID")
+	table.ColMap("Family").SetMaxSize(FamilyMaxSize)
+	table.ColMap("Device").SetMaxSize(DeviceMaxSize)
+	table.ColMap("Hash").SetMaxSize(SecretHashSize)
+	table.ColMap("Hash").SetUnique(true)
+}
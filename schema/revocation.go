@@ -0,0 +1,200 @@
+package schema
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/garyburd/redigo/redis"
+	"jsproj.com/koo/gosari/utils"
+)
+
+// 토큰 폐기 목록은 [token] 섹션의 revocationstore 항목에 따라 redis나 메모리에 저장된다. 폐기 목록의 각
+// 항목은 해당 토큰이 만료되고 [jwt] 섹션의 leewaysecond가 지나는 시점에 자동으로 삭제되므로 목록이
+// 무한히 커지지 않는다.
+const (
+	revokedTokenKeyPrefix  = "jwt:revoked:"       // + jti, 개별 토큰 폐기
+	revokedBeforeKeyPrefix = "jwt:revokedbefore:" // + uid, 해당 시각(밀리초) 이전에 발급된 토큰 모두 폐기
+
+	memoryRevocationSweepSize = 10000 // 메모리 저장소의 항목이 이보다 많아지면 만료된 항목을 지운다.
+)
+
+// revocationStore 는 폐기 목록을 저장하는 인터페이스이다. 값은 ttl초 뒤에 지워진다.
+type revocationStore interface {
+	// set 함수는 키에 값을 저장한다. keepGreater가 true이고 이미 더 크거나 같은 값이 있으면 덮어쓰지 않는다.
+	set(key string, value int64, ttl int64, keepGreater bool) error
+	// get 함수는 키들의 값을 읽는다. 없는 키는 nil이다.
+	get(keys ...string) ([]*int64, error)
+}
+
+var (
+	revocation revocationStore
+)
+
+func mustInitRevocation(conf *Configure) {
+	if conf.IsMemoryRevocation() {
+		revocation = newMemoryRevocation()
+		log.Info("token revocation initialized. store=memory")
+		return
+	}
+	revocation = redisRevocation{}
+	log.Info("token revocation initialized. store=redis")
+}
+
+// ServerTimeMilli 함수는 현재 시각을 밀리초 단위로 리턴한다. 토큰의 발급 시각(iat)은 밀리초까지 기록되므로
+// 시각으로 토큰을 폐기할 때 사용한다.
+func ServerTimeMilli() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+func revokedBeforeKey(uid int64) string {
+	return fmt.Sprintf("%s%d", revokedBeforeKeyPrefix, uid)
+}
+
+// RevokeToken 함수는 토큰 하나를 폐기 목록에 추가한다.
+// 폐기된 토큰은 만료 시각 전이라도 ParseToken에서 거부된다.
+func RevokeToken(claims *TokenClaims) error {
+	// 만료된 토큰도 leeway 동안은 받아들여지므로 그 때까지 폐기 목록에 남겨 둔다.
+	ttl := claims.Expire + Config().JWTLeeway() - utils.ServerTime()
+	if ttl <= 0 {
+		// 이미 만료된 토큰은 폐기 목록에 넣을 필요가 없다.
+		return nil
+	}
+	return revocation.set(revokedTokenKeyPrefix+claims.JTI, claims.UID, ttl, false)
+}
+
+// RevokeTokensIssuedBefore 함수는 uid 사용자에게 before 시각(밀리초) 이전에 발급된 모든 토큰을
+// 폐기한다. before와 같은 시각에 발급된 토큰은 폐기되지 않으므로 폐기 직후에 다시 로그인하여 받은 토큰은
+// 사용할 수 있다. 모든 기기에서 로그아웃 할 때 사용하며 보통 before에는 ServerTimeMilli()를 넘긴다.
+func RevokeTokensIssuedBefore(uid int64, before int64) error {
+	// before 시각 이전에 발급된 토큰은 before + 액세스 토큰 유효 기간 + leeway 이후에는 모두 거부된다.
+	conf := Config()
+	ttl := before/1000 + 1 + conf.AccessTokenExpire() + conf.JWTLeeway() - utils.ServerTime()
+	if ttl <= 0 {
+		return nil
+	}
+	// 이미 더 나중 시각으로 폐기 되어 있다면 덮어쓰지 않는다.
+	return revocation.set(revokedBeforeKey(uid), before, ttl, true)
+}
+
+// IsTokenRevoked 함수는 해당 토큰이 폐기 되었는지 여부를 리턴한다.
+func IsTokenRevoked(claims *TokenClaims) (bool, error) {
+	values, err := revocation.get(revokedTokenKeyPrefix+claims.JTI, revokedBeforeKey(claims.UID))
+	if err != nil {
+		return false, err
+	}
+
+	// 개별 토큰 폐기 여부
+	if values[0] != nil {
+		return true, nil
+	}
+
+	// 전체 로그아웃 여부
+	if before := values[1]; before != nil && claims.IssuedAtMilli < *before {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// redisRevocation 은 redis에 폐기 목록을 저장한다. 여러 인증 서버가 목록을 공유할 수 있다.
+type redisRevocation struct{}
+
+func (redisRevocation) set(key string, value int64, ttl int64, keepGreater bool) error {
+	conn := Redis().Get()
+	defer conn.Close()
+
+	if keepGreater {
+		cur, err := redis.Int64(conn.Do("GET", key))
+		if err != nil && err != redis.ErrNil {
+			return err
+		}
+		if err == nil && cur >= value {
+			return nil
+		}
+	}
+	_, err := conn.Do("SETEX", key, ttl, value)
+	return err
+}
+
+func (redisRevocation) get(keys ...string) ([]*int64, error) {
+	conn := Redis().Get()
+	defer conn.Close()
+
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	values, err := redis.Values(conn.Do("MGET", args...))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*int64, len(values))
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		n, err := redis.Int64(v, nil)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = &n
+	}
+	return result, nil
+}
+
+// memoryRevocation 은 프로세스 메모리에 폐기 목록을 저장한다. 서버가 하나인 경우나 테스트 환경에서 사용한다.
+type memoryRevocation struct {
+	mutex   sync.Mutex
+	entries map[string]revocationEntry
+}
+
+type revocationEntry struct {
+	value  int64
+	expire time.Time
+}
+
+func newMemoryRevocation() *memoryRevocation {
+	return &memoryRevocation{entries: make(map[string]revocationEntry)}
+}
+
+func (m *memoryRevocation) set(key string, value int64, ttl int64, keepGreater bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	if len(m.entries) > memoryRevocationSweepSize {
+		m.sweep(now)
+	}
+	if e, ok := m.entries[key]; ok && keepGreater && now.Before(e.expire) && e.value >= value {
+		return nil
+	}
+	m.entries[key] = revocationEntry{value, now.Add(time.Duration(ttl) * time.Second)}
+	return nil
+}
+
+func (m *memoryRevocation) get(keys ...string) ([]*int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	result := make([]*int64, len(keys))
+	for i, key := range keys {
+		if e, ok := m.entries[key]; ok && now.Before(e.expire) {
+			value := e.value
+			result[i] = &value
+		}
+	}
+	return result, nil
+}
+
+// sweep 함수는 만료된 항목을 지운다.
+func (m *memoryRevocation) sweep(now time.Time) {
+	for key, e := range m.entries {
+		if !now.Before(e.expire) {
+			delete(m.entries, key)
+		}
+	}
+}
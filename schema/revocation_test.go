@@ -0,0 +1,64 @@
+package schema
+
+import (
+	"testing"
+
+	"jsproj.com/koo/gosari/utils"
+)
+
+func TestMemoryRevocation(t *testing.T) {
+	m := newMemoryRevocation()
+
+	if err := m.set("before", 2000, 60, true); err != nil {
+		t.Fatal(err)
+	}
+	// 더 이른 시각으로는 덮어쓰지 않는다.
+	if err := m.set("before", 1000, 60, true); err != nil {
+		t.Fatal(err)
+	}
+	values, err := m.get("before", "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[0] == nil || *values[0] != 2000 {
+		t.Errorf("before = %v, want 2000", values[0])
+	}
+	if values[1] != nil {
+		t.Errorf("missing = %v, want nil", *values[1])
+	}
+
+	// 만료된 항목은 없는 것으로 본다.
+	if err := m.set("expired", 1, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if values, _ := m.get("expired"); values[0] != nil {
+		t.Errorf("expired = %v, want nil", *values[0])
+	}
+}
+
+func TestRevokedTokenWithinLeeway(t *testing.T) {
+	conf := new(Configure)
+	conf.JWT.LeewaySecond = 60
+	current.Store(conf)
+	revocation = newMemoryRevocation()
+
+	now := utils.ServerTime()
+	// 만료되었지만 leeway 안이라 아직 받아들여지는 토큰
+	claims := &TokenClaims{UID: 1, JTI: "leeway", IssuedAtMilli: (now - 600) * 1000, Expire: now - 10}
+	if err := RevokeToken(claims); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, err := IsTokenRevoked(claims); err != nil || !revoked {
+		t.Errorf("RevokeToken within leeway = %v, %v, want revoked", revoked, err)
+	}
+
+	// 액세스 토큰 유효 기간이 지나 leeway 안에 있는 토큰도 전체 폐기 후에는 거부된다.
+	other := &TokenClaims{UID: 2, JTI: "before", IssuedAtMilli: (now - conf.AccessTokenExpire() - 30) * 1000,
+		Expire: now - 30}
+	if err := RevokeTokensIssuedBefore(2, (now-conf.AccessTokenExpire()-20)*1000); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, err := IsTokenRevoked(other); err != nil || !revoked {
+		t.Errorf("RevokeTokensIssuedBefore within leeway = %v, %v, want revoked", revoked, err)
+	}
+}
@@ -5,5 +5,13 @@ package schema
 func MustInit(configFileName string) {
 	mustInitConfig(configFileName)
 	mustInitDatabase(Config())
+	if Config().IsRedisUsed() {
+		mustInitRedis(Config())
+	}
+	mustInitThrottle(Config())
+	mustInitRevocation(Config())
 	mustInitJWT(Config())
+	startPurgeJob()
+	startMailWorkers()
+	startConfigWatcher()
 }
@@ -0,0 +1,29 @@
+package schema
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+const (
+	secretTokenBytes = 32 // 리프레시 토큰 등 불투명(opaque) 토큰의 난수 길이
+	SecretHashSize   = 64 // 불투명 토큰의 sha256 해시 문자열 길이
+)
+
+// newSecretToken 함수는 url에 그대로 사용할 수 있는 불투명 토큰을 만든다.
+func newSecretToken() (string, error) {
+	b := make([]byte, secretTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSecretToken 함수는 불투명 토큰을 데이터베이스에 저장하기 위한 해시 문자열로 바꾼다.
+// 데이터베이스가 유출되더라도 토큰을 사용할 수 없도록 평문 토큰은 저장하지 않는다.
+func hashSecretToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,179 @@
+package schema
+
+import (
+	"database/sql"
+	"encoding/gob"
+	"errors"
+	"unicode/utf8"
+
+	"gopkg.in/gorp.v1"
+	"jsproj.com/koo/gosari/crypto"
+	"jsproj.com/koo/gosari/utils"
+)
+
+// Session 객체는 로그인 한 번에 해당하는 세션(기기) 스키마 객체이다. 여기에서 정의된 형태로 데이터베이스
+// 테이블이 작성된다. 따라서 이 코드를 바꾸는 경우 반드시 DB 마이그레이션이 필요하다.
+//
+// 로그인 시에 발급된 액세스 토큰의 sid 클레임과 리프레시 토큰의 Family는 세션의 SID와 같다.
+// 세션이 폐기되면 해당 세션의 액세스 토큰과 리프레시 토큰은 더이상 사용할 수 없다.
+type Session struct {
+	SID       string `db:"sid" json:"sid"`
+	UID       int64  `db:"uid" json:"-"`
+	Device    string `db:"device" json:"device"`       // 클라이언트가 알려준 기기 이름
+	UserAgent string `db:"useragent" json:"useragent"` // 로그인 시의 User-Agent 헤더
+	IP        string `db:"ip" json:"ip"`               // 로그인 시의 클라이언트 IP
+	Created   int64  `db:"created" json:"created"`     // 로그인 시각
+	LastSeen  int64  `db:"lastseen" json:"lastseen"`   // 마지막으로 사용된 시각
+	Revoked   int64  `db:"revoked" json:"-"`           // 폐기된 시각. 0이면 사용 중
+}
+
+// Session 스키마 상수 정의. 이곳에서 사용되는 상수는 데이터베이스에 반영되므로 값을 변경하면 안된다.
+// 불가피하게 값을 변경해야 할 경우는 기존 데이터베이스가 마이그레이션 되어야 한다.
+const (
+	SessionIDMaxSize  = 36  // 세션 아이디(UUID) 길이
+	UserAgentMaxSize  = 255 // User-Agent 최대 길이
+	IPMaxSize         = 45  // IPv6 주소 최대 길이
+	sessionTouchDelay = 60  // LastSeen을 갱신하는 최소 간격(초). 매 요청마다 데이터베이스에 쓰지 않기 위함
+)
+
+// ErrSessionRevoked 는 세션이 없거나 폐기된 경우의 오류이다.
+var ErrSessionRevoked = errors.New("session revoked")
+
+// truncate 함수는 s를 size 바이트 이하로 자른다. UTF-8 문자 중간에서 자르지 않도록 잘리는 문자는
+// 통째로 버린다.
+func truncate(s string, size int) string {
+	if len(s) <= size {
+		return s
+	}
+	for size > 0 && !utf8.RuneStart(s[size]) {
+		size--
+	}
+	return s[:size]
+}
+
+// CreateSession 함수는 로그인한 사용자의 새 세션을 만든다.
+func CreateSession(user *User, device string, userAgent string, ip string) (*Session, error) {
+	db := Database()
+
+	sid, err := crypto.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := utils.ServerTime()
+	s := &Session{
+		SID:       sid,
+		UID:       user.UID,
+		Device:    truncate(device, DeviceMaxSize),
+		UserAgent: truncate(userAgent, UserAgentMaxSize),
+		IP:        truncate(ip, IPMaxSize),
+		Created:   now,
+		LastSeen:  now,
+	}
+	if err := db.Auth.Insert(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LoadActiveSession 함수는 uid 사용자의 폐기되지 않은 세션을 읽는다.
+// 세션이 없거나, 다른 사용자의 세션이거나, 폐기 되었다면 ErrSessionRevoked를 리턴한다.
+// 데이터베이스 오류는 그대로 리턴하므로 세션이 폐기된 것으로 다루면 안된다.
+func LoadActiveSession(uid int64, sid string) (*Session, error) {
+	db := Database()
+	var s Session
+	err := db.Auth.SelectOne(&s, "select * from sessions where sid=?", sid)
+	if err == sql.ErrNoRows {
+		return nil, ErrSessionRevoked
+	} else if err != nil {
+		return nil, err
+	}
+	if s.UID != uid || s.Revoked != 0 {
+		return nil, ErrSessionRevoked
+	}
+	return &s, nil
+}
+
+// TouchSession 함수는 세션의 마지막 사용 시각을 갱신한다.
+// 데이터베이스 쓰기를 줄이기 위해 sessionTouchDelay 보다 자주 갱신하지는 않는다.
+func TouchSession(s *Session) error {
+	db := Database()
+	now := utils.ServerTime()
+	if now-s.LastSeen < sessionTouchDelay {
+		return nil
+	}
+	s.LastSeen = now
+	_, err := db.Auth.Exec("update sessions set lastseen=? where sid=?", now, s.SID)
+	return err
+}
+
+// LoadSessionList 함수는 사용자의 사용 중인 세션 목록을 최근에 사용한 순서로 읽는다.
+// 리프레시 토큰 유효 기간 동안 사용되지 않은 세션은 더이상 이어서 사용할 수 없으므로 제외한다.
+func LoadSessionList(uid int64) ([]*Session, error) {
+	db := Database()
+	since := utils.ServerTime() - Config().RefreshTokenExpire()
+
+	var sl []*Session
+	_, err := db.Auth.Select(&sl,
+		"select * from sessions where uid=? and revoked=0 and lastseen>=? order by lastseen desc", uid, since)
+	if err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+// RevokeSession 함수는 uid 사용자의 세션 하나를 폐기한다. 세션의 리프레시 토큰도 함께 폐기된다.
+func RevokeSession(uid int64, sid string) error {
+	db := Database()
+	result, err := db.Auth.Exec("update sessions set revoked=? where sid=? and uid=? and revoked=0",
+		utils.ServerTime(), sid, uid)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n != 1 {
+		return ErrSessionRevoked
+	}
+	return RevokeRefreshTokenFamily(sid)
+}
+
+// RevokeSessionsOfUser 함수는 사용자의 세션 중 except를 제외한 모든 세션과 리프레시 토큰을 폐기한다.
+// except가 빈 문자열이면 모든 세션을 폐기한다.
+func RevokeSessionsOfUser(uid int64, except string) error {
+	db := Database()
+	_, err := db.Auth.Exec("update sessions set revoked=? where uid=? and sid<>? and revoked=0",
+		utils.ServerTime(), uid, except)
+	if err != nil {
+		return err
+	}
+	_, err = db.Auth.Exec("update refreshtokens set status=? where uid=? and family<>?",
+		RefreshTokenStatusRevoked, uid, except)
+	return err
+}
+
+// RevokeSessionsCreatedBefore 함수는 사용자의 세션 중 before 시각(밀리초) 이전에 만들어진 세션과 그 세션의
+// 리프레시 토큰을 폐기한다. 세션의 생성 시각은 초 단위이므로 before가 초 단위로 나누어 떨어지지 않으면
+// before가 속한 초에 만들어진 세션도 폐기된다.
+func RevokeSessionsCreatedBefore(uid int64, before int64) error {
+	db := Database()
+	limit := (before + 999) / 1000
+	_, err := db.Auth.Exec("update sessions set revoked=? where uid=? and created<? and revoked=0",
+		utils.ServerTime(), uid, limit)
+	if err != nil {
+		return err
+	}
+	_, err = db.Auth.Exec("update refreshtokens set status=? where uid=? and family in "+
+		"(select sid from sessions where uid=? and created<?)",
+		RefreshTokenStatusRevoked, uid, uid, limit)
+	return err
+}
+
+func createSessionTable(dbmap *gorp.DbMap) {
+	gob.Register(&Session{})
+	table := dbmap.AddTableWithName(Session{}, "sessions").SetKeys(false, "SID")
+	table.ColMap("SID").SetMaxSize(SessionIDMaxSize)
+	table.ColMap("Device").SetMaxSize(DeviceMaxSize)
+	table.ColMap("UserAgent").SetMaxSize(UserAgentMaxSize)
+	table.ColMap("IP").SetMaxSize(IPMaxSize)
+}
@@ -0,0 +1,29 @@
+package schema
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	cases := []struct {
+		s    string
+		size int
+		want string
+	}{
+		{"abc", 5, "abc"},
+		{"abcdef", 3, "abc"},
+		{"가나다", 9, "가나다"},
+		// 한글은 한 글자가 3바이트이므로 중간에서 자르지 않고 앞 글자까지만 남긴다.
+		{"가나다", 7, "가나"},
+		{"가나다", 5, "가"},
+		{"가나다", 2, ""},
+		{"a가", 2, "a"},
+	}
+	for _, c := range cases {
+		got := truncate(c.s, c.size)
+		if got != c.want || !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) = %q, want %q", c.s, c.size, got, c.want)
+		}
+	}
+}
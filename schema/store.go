@@ -0,0 +1,59 @@
+package schema
+
+import (
+	"errors"
+	"strings"
+
+	"gopkg.in/gorp.v1"
+)
+
+// StoreTxFunc 는 사용자를 저장하는 트랜잭션 안에서 Auth 데이터베이스의 다른 테이블을 함께 바꾸는 함수이다.
+// 저장소의 다른 함수를 불러서는 안된다.
+type StoreTxFunc func(tx gorp.SqlExecutor) error
+
+// UserStore 는 사용자 저장소의 인터페이스이다. 데이터베이스 종류마다 구현이 다르며 [database] 섹션의
+// driver 항목에 따라 선택된다. 읽기 함수는 사용자를 찾지 못하면 ErrNotFound를 리턴하고, 아이디가
+// 중복되면 ErrDuplicated를 리턴한다. 아이디는 데이터베이스의 collation과 관계 없이 대소문자를 구분하지
+// 않도록 normalizeID로 바꾸어 저장하고 찾는다.
+type UserStore interface {
+	InsertUser(u *User) error
+	UpdateUser(u *User) error
+	// InsertUserWith, UpdateUserWith 함수는 사용자를 저장하면서 f를 같은 트랜잭션에서 실행한다.
+	// f가 오류를 리턴하면 사용자도 저장되지 않고 그 오류를 리턴한다. InsertUserWith의 f는 u.UID가
+	// 정해진 뒤에 불린다.
+	InsertUserWith(u *User, f StoreTxFunc) error
+	UpdateUserWith(u *User, f StoreTxFunc) error
+	UserByID(id string) (*User, error)
+	UserByUID(uid int64) (*User, error)
+	UserByActivationKey(key string) (*User, error)
+
+	// SearchUsers 함수는 조건에 맞는 사용자를 uid 순서로 offset부터 limit명 읽고 전체 사용자 수를 리턴한다.
+	SearchUsers(filter UserFilter, offset int, limit int) ([]*User, int64, error)
+	// WithdrawnUsersBefore 함수는 before 이전에 탈퇴한 사용자 목록을 리턴한다.
+	WithdrawnUsersBefore(before int64) ([]*User, error)
+	// PurgeWithdrawnUser 함수는 before 이전에 탈퇴한 상태인 사용자와 userOwnedTables의 데이터를 함께
+	// 영구 삭제한다. 그 사이에 탈퇴가 취소되었거나 다시 탈퇴하여 지우지 않았다면 false를 리턴한다.
+	PurgeWithdrawnUser(uid int64, before int64) (bool, error)
+}
+
+// TodoStore 는 일정 저장소의 인터페이스이다. 읽기 함수는 일정을 찾지 못하면 ErrNotFound를 리턴한다.
+type TodoStore interface {
+	InsertTodo(t *Todo) error
+	UpdateTodo(t *Todo) error
+	TodoByTID(tid int64) (*Todo, error)
+	// TodosInRange 함수는 사용자의 일정 중 기한이 stime과 etime 사이인 일정을 읽는다.
+	TodosInRange(uid int64, stime int64, etime int64) ([]*Todo, error)
+	RemoveTodo(tid int64) error
+}
+
+// normalizeID 함수는 사용자 아이디를 저장하고 찾을 때 사용할 형태(소문자)로 바꾼다.
+func normalizeID(id string) string {
+	return strings.ToLower(id)
+}
+
+var (
+	// ErrNotFound 는 저장소에서 찾는 항목이 없는 경우의 오류이다.
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicated 는 unique 항목(사용자 아이디 등)이 중복된 경우의 오류이다.
+	ErrDuplicated = errors.New("duplicated")
+)
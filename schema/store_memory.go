@@ -0,0 +1,277 @@
+package schema
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/gorp.v1"
+)
+
+// memoryStore 는 메모리에 저장하는 UserStore, TodoStore 구현이다. 테스트와 개발용이며 서버를 재시작하면
+// 모든 데이터가 사라진다. 사용자 아이디는 다른 저장소와 같이 normalizeID로 바꾸어 저장한다.
+// 세션 등 다른 테이블은 메모리 SQLite에 저장되므로 사용자를 삭제할 때 그곳의 데이터도 함께 지운다.
+type memoryStore struct {
+	mutex   sync.RWMutex
+	users   map[int64]*User
+	todos   map[int64]*Todo
+	lastUID int64
+	lastTID int64
+	aux     *gorp.DbMap // 사용자 이외의 테이블
+}
+
+func newMemoryStore(aux *gorp.DbMap) *memoryStore {
+	return &memoryStore{
+		users: make(map[int64]*User),
+		todos: make(map[int64]*Todo),
+		aux:   aux,
+	}
+}
+
+// findUser 함수는 조건에 맞는 첫 사용자의 복사본을 리턴한다. mutex를 잠근 상태에서 불러야 한다.
+func (s *memoryStore) findUser(match func(*User) bool) (*User, error) {
+	for _, u := range s.users {
+		if match(u) {
+			user := *u
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// isDuplicatedID 함수는 uid가 아닌 다른 사용자가 같은 아이디를 사용 중인지 확인한다.
+func (s *memoryStore) isDuplicatedID(id string, uid int64) bool {
+	_, err := s.findUser(func(u *User) bool { return u.UID != uid && u.ID == id })
+	return err == nil
+}
+
+func (s *memoryStore) InsertUser(u *User) error {
+	u.ID = normalizeID(u.ID)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.isDuplicatedID(u.ID, 0) {
+		return ErrDuplicated
+	}
+	s.lastUID++
+	u.UID = s.lastUID
+	user := *u
+	s.users[u.UID] = &user
+	return nil
+}
+
+func (s *memoryStore) UpdateUser(u *User) error {
+	u.ID = normalizeID(u.ID)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.users[u.UID]; !ok {
+		return nil
+	}
+	if s.isDuplicatedID(u.ID, u.UID) {
+		return ErrDuplicated
+	}
+	user := *u
+	s.users[u.UID] = &user
+	return nil
+}
+
+// InsertUserWith 함수는 f를 메모리 SQLite의 트랜잭션으로 실행하고 성공한 경우에만 사용자를 추가한다.
+func (s *memoryStore) InsertUserWith(u *User, f StoreTxFunc) error {
+	u.ID = normalizeID(u.ID)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.isDuplicatedID(u.ID, 0) {
+		return ErrDuplicated
+	}
+	u.UID = s.lastUID + 1
+	if err := s.auxTx(f); err != nil {
+		u.UID = 0
+		return err
+	}
+	s.lastUID = u.UID
+	user := *u
+	s.users[u.UID] = &user
+	return nil
+}
+
+// UpdateUserWith 함수는 f를 메모리 SQLite의 트랜잭션으로 실행하고 성공한 경우에만 사용자를 바꾼다.
+func (s *memoryStore) UpdateUserWith(u *User, f StoreTxFunc) error {
+	u.ID = normalizeID(u.ID)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.isDuplicatedID(u.ID, u.UID) {
+		return ErrDuplicated
+	}
+	if err := s.auxTx(f); err != nil {
+		return err
+	}
+	if _, ok := s.users[u.UID]; ok {
+		user := *u
+		s.users[u.UID] = &user
+	}
+	return nil
+}
+
+// auxTx 함수는 f를 aux 데이터베이스의 트랜잭션으로 실행한다.
+func (s *memoryStore) auxTx(f StoreTxFunc) error {
+	tx, err := s.aux.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *memoryStore) UserByID(id string) (*User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	id = normalizeID(id)
+	return s.findUser(func(u *User) bool { return u.ID == id })
+}
+
+func (s *memoryStore) UserByUID(uid int64) (*User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.findUser(func(u *User) bool { return u.UID == uid })
+}
+
+func (s *memoryStore) UserByActivationKey(key string) (*User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.findUser(func(u *User) bool { return u.ActivationKey == key })
+}
+
+// sortedUsers 함수는 조건에 맞는 사용자의 복사본을 uid 순서로 리턴한다.
+func (s *memoryStore) sortedUsers(match func(*User) bool) []*User {
+	var users []*User
+	for _, u := range s.users {
+		if match(u) {
+			user := *u
+			users = append(users, &user)
+		}
+	}
+	sort.Sort(usersByUID(users))
+	return users
+}
+
+func (s *memoryStore) SearchUsers(filter UserFilter, offset int, limit int) ([]*User, int64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	query := normalizeID(filter.Query)
+	users := s.sortedUsers(func(u *User) bool {
+		return strings.Contains(u.ID, query) &&
+			(filter.Status == nil || u.Status == *filter.Status) &&
+			(filter.Type == nil || u.Type == *filter.Type)
+	})
+
+	total := int64(len(users))
+	if offset >= len(users) {
+		return nil, total, nil
+	}
+	users = users[offset:]
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, total, nil
+}
+
+func (s *memoryStore) WithdrawnUsersBefore(before int64) ([]*User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.sortedUsers(func(u *User) bool {
+		return u.Status == UserStatusWithdraw && u.Withdrawn <= before
+	}), nil
+}
+
+func (s *memoryStore) PurgeWithdrawnUser(uid int64, before int64) (bool, error) {
+	s.mutex.Lock()
+	u, ok := s.users[uid]
+	if !ok || u.Status != UserStatusWithdraw || u.Withdrawn > before {
+		s.mutex.Unlock()
+		return false, nil
+	}
+	delete(s.users, uid)
+	for tid, t := range s.todos {
+		if t.OwnerUID == uid {
+			delete(s.todos, tid)
+		}
+	}
+	s.mutex.Unlock()
+
+	tx, err := s.aux.Begin()
+	if err != nil {
+		return true, err
+	}
+	if err := deleteUserOwned(tx, uid); err != nil {
+		tx.Rollback()
+		return true, err
+	}
+	return true, tx.Commit()
+}
+
+func (s *memoryStore) InsertTodo(t *Todo) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastTID++
+	t.TID = s.lastTID
+	todo := *t
+	s.todos[t.TID] = &todo
+	return nil
+}
+
+func (s *memoryStore) UpdateTodo(t *Todo) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.todos[t.TID]; ok {
+		todo := *t
+		s.todos[t.TID] = &todo
+	}
+	return nil
+}
+
+func (s *memoryStore) TodoByTID(tid int64) (*Todo, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	t, ok := s.todos[tid]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	todo := *t
+	return &todo, nil
+}
+
+func (s *memoryStore) TodosInRange(uid int64, stime int64, etime int64) ([]*Todo, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var tl []*Todo
+	for _, t := range s.todos {
+		if t.OwnerUID == uid && t.LimitTime >= stime && t.LimitTime <= etime {
+			todo := *t
+			tl = append(tl, &todo)
+		}
+	}
+	sort.Sort(todosByTID(tl))
+	return tl, nil
+}
+
+func (s *memoryStore) RemoveTodo(tid int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.todos, tid)
+	return nil
+}
+
+type usersByUID []*User
+
+func (a usersByUID) Len() int           { return len(a) }
+func (a usersByUID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a usersByUID) Less(i, j int) bool { return a[i].UID < a[j].UID }
+
+type todosByTID []*Todo
+
+func (a todosByTID) Len() int           { return len(a) }
+func (a todosByTID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a todosByTID) Less(i, j int) bool { return a[i].TID < a[j].TID }
@@ -0,0 +1,183 @@
+package schema
+
+import (
+	"database/sql"
+
+	"gopkg.in/gorp.v1"
+)
+
+// sqlStore 는 gorp를 사용하는 UserStore, TodoStore 구현이다. MySQL, PostgreSQL, SQLite에서 사용된다.
+// 다른 테이블과 같은 데이터베이스를 사용하므로 사용자를 삭제할 때 하나의 트랜잭션으로 처리할 수 있다.
+type sqlStore struct {
+	dbmap      *gorp.DbMap
+	likeEscape string // like 검색에 덧붙일 escape 절(기본 이스케이프 문자가 없는 데이터베이스용)
+}
+
+// storeError 함수는 데이터베이스의 오류를 저장소의 오류로 바꾼다.
+func storeError(err error) error {
+	switch {
+	case err == sql.ErrNoRows:
+		return ErrNotFound
+	case isDuplicated(err):
+		return ErrDuplicated
+	}
+	return err
+}
+
+func (s *sqlStore) InsertUser(u *User) error {
+	u.ID = normalizeID(u.ID)
+	return storeError(s.dbmap.Insert(u))
+}
+
+func (s *sqlStore) UpdateUser(u *User) error {
+	u.ID = normalizeID(u.ID)
+	_, err := s.dbmap.Update(u)
+	return storeError(err)
+}
+
+func (s *sqlStore) InsertUserWith(u *User, f StoreTxFunc) error {
+	u.ID = normalizeID(u.ID)
+	err := s.inTx(func(tx *gorp.Transaction) error {
+		if err := tx.Insert(u); err != nil {
+			return storeError(err)
+		}
+		return f(tx)
+	})
+	if err != nil {
+		u.UID = 0
+	}
+	return err
+}
+
+func (s *sqlStore) UpdateUserWith(u *User, f StoreTxFunc) error {
+	u.ID = normalizeID(u.ID)
+	return s.inTx(func(tx *gorp.Transaction) error {
+		if _, err := tx.Update(u); err != nil {
+			return storeError(err)
+		}
+		return f(tx)
+	})
+}
+
+// inTx 함수는 f를 트랜잭션으로 실행한다. f가 오류를 리턴하면 롤백한다.
+func (s *sqlStore) inTx(f func(tx *gorp.Transaction) error) error {
+	tx, err := s.dbmap.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) selectUser(query string, arg interface{}) (*User, error) {
+	var user User
+	if err := s.dbmap.SelectOne(&user, query, arg); err != nil {
+		return nil, storeError(err)
+	}
+	return &user, nil
+}
+
+func (s *sqlStore) UserByID(id string) (*User, error) {
+	return s.selectUser("select * from users where id=?", normalizeID(id))
+}
+
+func (s *sqlStore) UserByUID(uid int64) (*User, error) {
+	return s.selectUser("select * from users where uid=?", uid)
+}
+
+func (s *sqlStore) UserByActivationKey(key string) (*User, error) {
+	return s.selectUser("select * from users where activationkey=?", key)
+}
+
+func (s *sqlStore) SearchUsers(filter UserFilter, offset int, limit int) ([]*User, int64, error) {
+	where, args := filter.where(s.likeEscape)
+	total, err := s.dbmap.SelectInt("select count(*) from users"+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var users []*User
+	args = append(args, limit, offset)
+	_, err = s.dbmap.Select(&users, "select * from users"+where+" order by uid limit ? offset ?", args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (s *sqlStore) WithdrawnUsersBefore(before int64) ([]*User, error) {
+	var users []*User
+	_, err := s.dbmap.Select(&users, "select * from users where status=? and withdrawn<=?",
+		UserStatusWithdraw, before)
+	return users, err
+}
+
+func (s *sqlStore) PurgeWithdrawnUser(uid int64, before int64) (bool, error) {
+	tx, err := s.dbmap.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	// 사용자 행을 먼저 지워 잠근다. 탈퇴가 취소되었거나 취소 후 다시 탈퇴한 사용자는 지워지지 않는다.
+	result, err := tx.Exec("delete from users where uid=? and status=? and withdrawn<=?", uid, UserStatusWithdraw, before)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		tx.Rollback()
+		return false, err
+	} else if n != 1 {
+		return false, tx.Rollback()
+	}
+
+	if err := deleteUserOwned(tx, uid); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// deleteUserOwned 함수는 userOwnedTables에서 사용자의 데이터를 지운다.
+func deleteUserOwned(exec gorp.SqlExecutor, uid int64) error {
+	for _, t := range userOwnedTables {
+		if _, err := exec.Exec("delete from "+t.table+" where "+t.column+"=?", uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) InsertTodo(t *Todo) error {
+	return storeError(s.dbmap.Insert(t))
+}
+
+func (s *sqlStore) UpdateTodo(t *Todo) error {
+	_, err := s.dbmap.Update(t)
+	return storeError(err)
+}
+
+func (s *sqlStore) TodoByTID(tid int64) (*Todo, error) {
+	var todo Todo
+	if err := s.dbmap.SelectOne(&todo, "select * from todo where tid=?", tid); err != nil {
+		return nil, storeError(err)
+	}
+	return &todo, nil
+}
+
+func (s *sqlStore) TodosInRange(uid int64, stime int64, etime int64) ([]*Todo, error) {
+	var tl []*Todo
+	_, err := s.dbmap.Select(&tl, "select * from todo where owneruid=? and limittime between ? and ?", uid, stime, etime)
+	if err != nil {
+		return nil, err
+	}
+	return tl, nil
+}
+
+func (s *sqlStore) RemoveTodo(tid int64) error {
+	_, err := s.dbmap.Exec("delete from todo where tid=?", tid)
+	return err
+}
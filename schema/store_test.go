@@ -0,0 +1,118 @@
+package schema
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/gorp.v1"
+)
+
+// forEachStore 함수는 같은 테스트를 memory, sqlite3 저장소에서 각각 실행한다.
+func forEachStore(t *testing.T, f func(t *testing.T, s UserStore)) {
+	dir, err := ioutil.TempDir("", "authstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	memoryAux := mustInitAuthDatabase(DriverSQLite, filepath.Join(dir, "memory.db"), true)
+	defer memoryAux.Db.Close()
+	sqliteMap := mustInitAuthDatabase(DriverSQLite, filepath.Join(dir, "sqlite.db"), true)
+	defer sqliteMap.Db.Close()
+
+	stores := map[string]UserStore{
+		DriverMemory: newMemoryStore(memoryAux),
+		DriverSQLite: &sqlStore{dbmap: sqliteMap, likeEscape: ` escape '\'`},
+	}
+	for name, s := range stores {
+		t.Logf("store=%s", name)
+		f(t, s)
+	}
+}
+
+func TestStoreUserID(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s UserStore) {
+		u := &User{ID: "Alice@Example.com"}
+		if err := s.InsertUser(u); err != nil {
+			t.Fatal(err)
+		}
+		if u.ID != "alice@example.com" {
+			t.Errorf("ID = %q, want normalized", u.ID)
+		}
+
+		found, err := s.UserByID("ALICE@example.COM")
+		if err != nil || found.UID != u.UID {
+			t.Errorf("UserByID = %v, %v, want uid %d", found, err, u.UID)
+		}
+		if err := s.InsertUser(&User{ID: "alice@EXAMPLE.com"}); err != ErrDuplicated {
+			t.Errorf("InsertUser duplicated = %v, want ErrDuplicated", err)
+		}
+
+		users, total, err := s.SearchUsers(UserFilter{Query: "ALICE"}, 0, 10)
+		if err != nil || total != 1 || len(users) != 1 {
+			t.Errorf("SearchUsers = %d, %d, %v, want 1", len(users), total, err)
+		}
+	})
+}
+
+func TestStoreNotFound(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s UserStore) {
+		if _, err := s.UserByID("nobody@example.com"); err != ErrNotFound {
+			t.Errorf("UserByID = %v, want ErrNotFound", err)
+		}
+		if _, err := s.UserByUID(12345); err != ErrNotFound {
+			t.Errorf("UserByUID = %v, want ErrNotFound", err)
+		}
+		if _, err := s.UserByActivationKey("missing"); err != ErrNotFound {
+			t.Errorf("UserByActivationKey = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestStoreWithRollback(t *testing.T) {
+	errFail := errors.New("fail")
+	forEachStore(t, func(t *testing.T, s UserStore) {
+		u := &User{ID: "rollback@example.com"}
+		err := s.InsertUserWith(u, func(tx gorp.SqlExecutor) error { return errFail })
+		if err != errFail || u.UID != 0 {
+			t.Errorf("InsertUserWith = %v, uid %d, want errFail, 0", err, u.UID)
+		}
+		if _, err := s.UserByID(u.ID); err != ErrNotFound {
+			t.Errorf("UserByID after rollback = %v, want ErrNotFound", err)
+		}
+
+		if err := s.InsertUserWith(u, func(tx gorp.SqlExecutor) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		changed := *u
+		changed.Info = "changed"
+		if err := s.UpdateUserWith(&changed, func(tx gorp.SqlExecutor) error { return errFail }); err != errFail {
+			t.Errorf("UpdateUserWith = %v, want errFail", err)
+		}
+		if found, _ := s.UserByUID(u.UID); found == nil || found.Info != "" {
+			t.Errorf("UpdateUserWith rollback = %v, want unchanged", found)
+		}
+	})
+}
+
+func TestStorePurgeWithdrawnUser(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s UserStore) {
+		u := &User{ID: "withdraw@example.com", Status: UserStatusWithdraw, Withdrawn: 200}
+		if err := s.InsertUser(u); err != nil {
+			t.Fatal(err)
+		}
+		// 기준 시각 이후에 탈퇴한 사용자는 지우지 않는다.
+		if purged, err := s.PurgeWithdrawnUser(u.UID, 100); err != nil || purged {
+			t.Errorf("PurgeWithdrawnUser(100) = %v, %v, want false", purged, err)
+		}
+		if purged, err := s.PurgeWithdrawnUser(u.UID, 300); err != nil || !purged {
+			t.Errorf("PurgeWithdrawnUser(300) = %v, %v, want true", purged, err)
+		}
+		if _, err := s.UserByUID(u.UID); err != ErrNotFound {
+			t.Errorf("UserByUID after purge = %v, want ErrNotFound", err)
+		}
+	})
+}
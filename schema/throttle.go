@@ -0,0 +1,188 @@
+package schema
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/garyburd/redigo/redis"
+)
+
+// ThrottleLimit 구조체는 실패 허용 횟수와 잠금 시간 정책이다.
+// Attempts번 실패한 이후부터는 실패할 때마다 BaseLock, BaseLock*2, BaseLock*4... 초 동안 잠기며
+// 잠금 시간은 MaxLock을 넘지 않는다.
+type ThrottleLimit struct {
+	Attempts int64 // 잠금 없이 허용하는 실패 횟수
+	BaseLock int64 // 처음 잠기는 시간(초)
+	MaxLock  int64 // 최대 잠금 시간(초)
+	Window   int64 // 마지막 실패 후 이 시간(초)이 지나면 실패 횟수가 초기화 된다.
+}
+
+// lockDuration 함수는 count번째 실패 후 잠글 시간(초)을 계산한다.
+func (l ThrottleLimit) lockDuration(count int64) int64 {
+	if count <= l.Attempts {
+		return 0
+	}
+	lock := l.BaseLock
+	for i := l.Attempts + 1; i < count && lock < l.MaxLock; i++ {
+		lock *= 2
+	}
+	if lock > l.MaxLock {
+		lock = l.MaxLock
+	}
+	return lock
+}
+
+// Throttler 는 키(아이디, IP 등)별 실패 횟수와 잠금 상태를 저장하는 인터페이스이다.
+// 여러 서버가 상태를 공유할 수 있도록 redis를 사용하며, 서버가 하나이거나 테스트 환경에서는 메모리를
+// 사용할 수 있다. 저장소는 [throttle] 섹션의 store 항목에서 설정한다.
+type Throttler interface {
+	// RetryAfter 함수는 키가 잠겨 있다면 다시 시도할 수 있을 때까지 남은 시간(초)을 리턴한다.
+	RetryAfter(key string) (int64, error)
+	// Fail 함수는 키의 실패 횟수를 늘리고 잠겼다면 잠금 시간(초)을 리턴한다.
+	Fail(key string, limit ThrottleLimit) (int64, error)
+	// Reset 함수는 키의 실패 기록과 잠금을 지운다.
+	Reset(key string) error
+}
+
+const (
+	throttleFailKeyPrefix = "throttle:fail:" // + key, 실패 횟수
+	throttleLockKeyPrefix = "throttle:lock:" // + key, 잠금
+
+	memoryThrottleSweepSize = 10000 // 메모리 저장소의 항목이 이보다 많아지면 만료된 항목을 지운다.
+)
+
+var (
+	throttler Throttler
+)
+
+func mustInitThrottle(conf *Configure) {
+	if conf.IsMemoryThrottle() {
+		throttler = newMemoryThrottler()
+		log.Info("throttle initialized. store=memory")
+		return
+	}
+	throttler = redisThrottler{}
+	log.Info("throttle initialized. store=redis")
+}
+
+// Throttle 함수는 현재 설정된 Throttler를 반환한다.
+func Throttle() Throttler {
+	return throttler
+}
+
+// redisThrottler 는 redis에 상태를 저장한다. 여러 인증 서버가 상태를 공유할 수 있다.
+type redisThrottler struct{}
+
+func (redisThrottler) RetryAfter(key string) (int64, error) {
+	conn := Redis().Get()
+	defer conn.Close()
+	ttl, err := redis.Int64(conn.Do("TTL", throttleLockKeyPrefix+key))
+	if err != nil || ttl < 0 {
+		return 0, err
+	}
+	return ttl, nil
+}
+
+func (redisThrottler) Fail(key string, limit ThrottleLimit) (int64, error) {
+	conn := Redis().Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("INCR", throttleFailKeyPrefix+key)
+	conn.Send("EXPIRE", throttleFailKeyPrefix+key, limit.Window)
+	values, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return 0, err
+	}
+	count, err := redis.Int64(values[0], nil)
+	if err != nil {
+		return 0, err
+	}
+
+	lock := limit.lockDuration(count)
+	if lock > 0 {
+		if _, err := conn.Do("SETEX", throttleLockKeyPrefix+key, lock, count); err != nil {
+			return 0, err
+		}
+	}
+	return lock, nil
+}
+
+func (redisThrottler) Reset(key string) error {
+	conn := Redis().Get()
+	defer conn.Close()
+	_, err := conn.Do("DEL", throttleFailKeyPrefix+key, throttleLockKeyPrefix+key)
+	return err
+}
+
+// memoryThrottler 는 프로세스 메모리에 상태를 저장한다. 서버가 하나인 경우나 테스트 환경에서 사용한다.
+type memoryThrottler struct {
+	mutex   sync.Mutex
+	entries map[string]*throttleEntry
+}
+
+type throttleEntry struct {
+	count       int64
+	expire      time.Time // 실패 횟수가 초기화 되는 시각
+	lockedUntil time.Time
+}
+
+func newMemoryThrottler() *memoryThrottler {
+	return &memoryThrottler{entries: make(map[string]*throttleEntry)}
+}
+
+func (t *memoryThrottler) RetryAfter(key string) (int64, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	e, ok := t.entries[key]
+	if !ok {
+		return 0, nil
+	}
+	remain := e.lockedUntil.Sub(time.Now())
+	if remain <= 0 {
+		return 0, nil
+	}
+	// redis의 TTL과 같이 초 단위로 올림 한다.
+	return int64((remain + time.Second - 1) / time.Second), nil
+}
+
+func (t *memoryThrottler) Fail(key string, limit ThrottleLimit) (int64, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	if len(t.entries) > memoryThrottleSweepSize {
+		t.sweep(now)
+	}
+
+	e, ok := t.entries[key]
+	if !ok || now.After(e.expire) {
+		e = &throttleEntry{}
+		t.entries[key] = e
+	}
+	e.count++
+	e.expire = now.Add(time.Duration(limit.Window) * time.Second)
+
+	lock := limit.lockDuration(e.count)
+	if lock > 0 {
+		e.lockedUntil = now.Add(time.Duration(lock) * time.Second)
+	}
+	return lock, nil
+}
+
+func (t *memoryThrottler) Reset(key string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.entries, key)
+	return nil
+}
+
+// sweep 함수는 실패 횟수가 초기화 되었고 잠겨 있지도 않은 항목을 지운다.
+func (t *memoryThrottler) sweep(now time.Time) {
+	for key, e := range t.entries {
+		if now.After(e.expire) && now.After(e.lockedUntil) {
+			delete(t.entries, key)
+		}
+	}
+}
@@ -29,32 +29,17 @@ const (
 
 // LoadTodoFromTID 함수는 tid(TODO ID)를 사용해 데이터베이스로부터 Todo 1개를 읽는다.
 func LoadTodoFromTID(tid int64) (*Todo, error) {
-	db := Database()
-	var todo Todo
-	err := db.Auth.SelectOne(&todo, "select * from todo where tid=?", tid)
-	if err != nil {
-		return nil, err
-	}
-	return &todo, nil
+	return Database().Todos.TodoByTID(tid)
 }
 
 // LoadTodoListFromTime 함수는 데이터베이스로부터 해당 기간동안의 Todo를 리스트로 읽는다.
 func LoadTodoListFromTime(uid int64, stime int64, etime int64) ([]*Todo, error) {
-	db := Database()
-
-	var tl []*Todo
-	_, err := db.Auth.Select(&tl, "select * from todo where owneruid=? and limittime between ? and ?", uid, stime, etime)
-	if err != nil {
-		return nil, err
-	}
-	return tl, nil
+	return Database().Todos.TodosInRange(uid, stime, etime)
 }
 
 // RemoveTodoFromTID 함수는 tid(TODO ID)를 사용해 데이터베이스로부터 Todo 1개를 삭제한다.
 func RemoveTodoFromTID(tid int64) error {
-	db := Database()
-	_, err := db.Auth.Exec("delete from todo where tid=?", tid)
-	return err
+	return Database().Todos.RemoveTodo(tid)
 }
 
 func createTodoListTable(dbmap *gorp.DbMap) {
@@ -0,0 +1,332 @@
+package schema
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gopkg.in/gorp.v1"
+	"jsproj.com/koo/gosari/utils"
+)
+
+// UserTOTP 객체는 사용자의 TOTP(RFC 6238) 2단계 인증 설정 스키마 객체이다. 여기에서 정의된 형태로
+// 데이터베이스 테이블이 작성된다. 따라서 이 코드를 바꾸는 경우 반드시 DB 마이그레이션이 필요하다.
+type UserTOTP struct {
+	UID      int64  `db:"uid" json:"uid"`
+	Secret   string `db:"secret" json:"-"`        // base32로 인코딩된 공유 비밀키
+	Enabled  bool   `db:"enabled" json:"enabled"` // 첫 코드로 확인을 마쳤는지 여부
+	Created  int64  `db:"created" json:"created"`
+	LastStep int64  `db:"laststep" json:"-"` // 마지막으로 사용된 코드의 시간 단계(같은 코드 재사용 방지)
+}
+
+// RecoveryCode 객체는 OTP 기기를 잃어버렸을 때 한 번 사용할 수 있는 복구 코드 스키마 객체이다.
+// 데이터베이스에는 해시만 저장된다.
+type RecoveryCode struct {
+	RCID int64  `db:"rcid" json:"-"`
+	UID  int64  `db:"uid" json:"uid"`
+	Hash string `db:"hash" json:"-"`
+	Used int64  `db:"used" json:"used"` // 사용한 시각. 0이면 아직 사용 전
+}
+
+// TOTP 상수 정의. 인증 앱과 맞춰야 하므로 값을 바꾸면 이미 등록된 사용자의 인증 앱이 동작하지 않는다.
+const (
+	totpSecretBytes = 20 // 공유 비밀키 길이(HMAC-SHA1 블록에 맞춤)
+	totpPeriod      = 30 // 코드가 바뀌는 주기(초)
+	totpDigits      = 6  // 코드 자리 수
+	totpSkew        = 1  // 앞뒤로 허용하는 시간 단계 수(기기와 서버의 시간 오차)
+
+	RecoveryCodeCount = 10 // 한 번에 발급하는 복구 코드 개수
+	recoveryCodeBytes = 5  // 복구 코드 하나의 난수 길이(base32로 8글자)
+
+	TOTPSecretMaxSize = 32
+)
+
+// 2단계 인증 처리 중에 발생하는 오류
+var (
+	ErrTOTPNotEnrolled     = errors.New("totp not enrolled")
+	ErrTOTPAlreadyEnabled  = errors.New("totp already enabled")
+	ErrTOTPInvalidCode     = errors.New("invalid totp code")
+	ErrRecoveryCodeInvalid = errors.New("invalid recovery code")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode 함수는 비밀키와 시간 단계로 RFC 6238(HOTP, RFC 4226) 코드를 계산한다.
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// matchTOTP 함수는 코드가 허용 오차 안의 시간 단계 중 after보다 나중 단계의 코드와 일치하는지 확인하고
+// 일치한 시간 단계를 리턴한다. 일치하지 않으면 0을 리턴한다.
+func matchTOTP(secret string, code string, after int64) int64 {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return 0
+	}
+	code = strings.Replace(code, " ", "", -1)
+	now := utils.ServerTime() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= after {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step
+		}
+	}
+	return 0
+}
+
+// LoadUserTOTP 함수는 사용자의 2단계 인증 설정을 읽는다. 설정이 없으면 ErrTOTPNotEnrolled를 리턴하며
+// 데이터베이스 오류는 그대로 리턴한다.
+func LoadUserTOTP(uid int64) (*UserTOTP, error) {
+	db := Database()
+	var t UserTOTP
+	err := db.Auth.SelectOne(&t, "select * from totp where uid=?", uid)
+	if err == sql.ErrNoRows {
+		return nil, ErrTOTPNotEnrolled
+	} else if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// IsTOTPEnabled 함수는 사용자가 2단계 인증을 사용하는지 여부를 리턴한다.
+// 설정을 읽지 못하면 2단계 인증을 건너뛰지 않도록 오류를 리턴한다.
+func IsTOTPEnabled(uid int64) (bool, error) {
+	t, err := LoadUserTOTP(uid)
+	if err == ErrTOTPNotEnrolled {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return t.Enabled, nil
+}
+
+// BeginTOTPEnrollment 함수는 새 공유 비밀키를 만들어 확인 전 상태로 저장한다.
+// 확인 전 상태로 남아 있던 이전 비밀키는 새 비밀키로 바뀐다.
+func BeginTOTPEnrollment(user *User) (*UserTOTP, error) {
+	db := Database()
+
+	old, err := LoadUserTOTP(user.UID)
+	if err != nil && err != ErrTOTPNotEnrolled {
+		return nil, err
+	}
+	if old != nil && old.Enabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	b := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	t := &UserTOTP{
+		UID:     user.UID,
+		Secret:  totpEncoding.EncodeToString(b),
+		Enabled: false,
+		Created: utils.ServerTime(),
+	}
+	if old != nil {
+		_, err = db.Auth.Update(t)
+	} else {
+		err = db.Auth.Insert(t)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// TOTPURI 함수는 인증 앱에 등록할 otpauth:// URI를 만든다.
+func TOTPURI(user *User, t *UserTOTP) string {
+	issuer := Config().TOTPIssuer()
+	v := url.Values{}
+	v.Set("secret", t.Secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	label := url.QueryEscape(issuer + ":" + user.ID)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// ConfirmTOTPEnrollment 함수는 인증 앱의 첫 코드로 등록을 확인하고 2단계 인증을 켠다.
+// 확인에 성공하면 새 복구 코드 목록을 리턴한다. 복구 코드는 이때 한 번만 사용자에게 보여줄 수 있다.
+// 2단계 인증을 켜는 것과 복구 코드 발급은 하나의 트랜잭션으로 처리되어 복구 코드 없이 켜지지 않는다.
+func ConfirmTOTPEnrollment(user *User, code string) ([]string, error) {
+	db := Database()
+
+	t, err := LoadUserTOTP(user.UID)
+	if err != nil {
+		return nil, err
+	}
+	if t.Enabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	step := matchTOTP(t.Secret, code, 0)
+	if step == 0 {
+		return nil, ErrTOTPInvalidCode
+	}
+
+	tx, err := db.Auth.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// 같은 등록을 동시에 확인하는 경우 하나만 성공하도록 확인 전 상태를 조건으로 건다.
+	result, err := tx.Exec("update totp set enabled=?, laststep=? where uid=? and enabled=?", true, step, t.UID, false)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		tx.Rollback()
+		return nil, err
+	} else if n != 1 {
+		tx.Rollback()
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	codes, err := newRecoveryCodes(tx, user)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// NewRecoveryCodes 함수는 사용자의 복구 코드를 새로 발급한다. 이전 복구 코드는 모두 삭제된다.
+func NewRecoveryCodes(user *User) ([]string, error) {
+	tx, err := Database().Auth.Begin()
+	if err != nil {
+		return nil, err
+	}
+	codes, err := newRecoveryCodes(tx, user)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// newRecoveryCodes 함수는 NewRecoveryCodes 함수를 exec(트랜잭션일 수 있음)로 실행한다.
+func newRecoveryCodes(exec gorp.SqlExecutor, user *User) ([]string, error) {
+	if _, err := exec.Exec("delete from recoverycodes where uid=?", user.UID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(totpEncoding.EncodeToString(b))
+		code := s[:4] + "-" + s[4:]
+		rc := &RecoveryCode{UID: user.UID, Hash: hashSecretToken(normalizeRecoveryCode(code))}
+		if err := exec.Insert(rc); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.Replace(code, "-", "", -1)
+	return strings.Replace(code, " ", "", -1)
+}
+
+// VerifyTOTP 함수는 2단계 인증 코드를 확인한다. 한 번 사용된 코드는 다시 사용할 수 없다.
+func VerifyTOTP(user *User, code string) error {
+	db := Database()
+
+	t, err := LoadUserTOTP(user.UID)
+	if err != nil {
+		return err
+	}
+	if !t.Enabled {
+		return ErrTOTPNotEnrolled
+	}
+
+	step := matchTOTP(t.Secret, code, t.LastStep)
+	if step == 0 {
+		return ErrTOTPInvalidCode
+	}
+
+	// 같은 코드로 동시에 요청이 들어오는 경우 하나만 성공하도록 이전 단계를 조건으로 건다.
+	result, err := db.Auth.Exec("update totp set laststep=? where uid=? and laststep=?", step, t.UID, t.LastStep)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n != 1 {
+		return ErrTOTPInvalidCode
+	}
+	return nil
+}
+
+// UseRecoveryCode 함수는 복구 코드를 확인하고 사용 처리한다.
+func UseRecoveryCode(user *User, code string) error {
+	db := Database()
+	result, err := db.Auth.Exec("update recoverycodes set used=? where uid=? and hash=? and used=0",
+		utils.ServerTime(), user.UID, hashSecretToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n != 1 {
+		return ErrRecoveryCodeInvalid
+	}
+	return nil
+}
+
+// DisableTOTP 함수는 사용자의 2단계 인증 설정과 복구 코드를 모두 삭제한다.
+func DisableTOTP(uid int64) error {
+	db := Database()
+	if _, err := db.Auth.Exec("delete from recoverycodes where uid=?", uid); err != nil {
+		return err
+	}
+	_, err := db.Auth.Exec("delete from totp where uid=?", uid)
+	return err
+}
+
+func createTOTPTable(dbmap *gorp.DbMap) {
+	gob.Register(&UserTOTP{})
+	table := dbmap.AddTableWithName(UserTOTP{}, "totp").SetKeys(false, "UID")
+	table.ColMap("Secret").SetMaxSize(TOTPSecretMaxSize)
+
+	gob.Register(&RecoveryCode{})
+	table = dbmap.AddTableWithName(RecoveryCode{}, "recoverycodes").SetKeys(true, "RCID")
+	table.ColMap("Hash").SetMaxSize(SecretHashSize)
+}
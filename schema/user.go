@@ -5,10 +5,12 @@ import (
 	"encoding/gob"
 	"net/mail"
 	"strings"
+	"unicode/utf8"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/asaskevich/govalidator"
 	"gopkg.in/gorp.v1"
+	"jsproj.com/koo/gosari/crypto"
 	"jsproj.com/koo/gosari/utils"
 )
 
@@ -20,11 +22,19 @@ type User struct {
 	Info          string `db:"info" json:"info"`     // User's profile.
 	Status        int    `db:"status" json:"status"` // User's status
 	Password      string `db:"password" json:"-"`
-	PasswordTmp   string `db:"passwordtmp" json:"-"`
+	PasswordTmp   string `db:"passwordtmp" json:"-"` // 더이상 사용하지 않는다(비밀번호 재설정 토큰으로 대체됨)
 	Created       int64  `db:"created" json:"created"`
 	LastLogin     int64  `db:"-" json:"lastlogin"`
 	ActivationKey string `db:"activationkey" json:"-"`
 	Type          int    `db:"type" json:"type"` // User's type
+	// 비밀번호 변경이 강제된 사용자. 비밀번호를 바꾸기 전까지는 비밀번호 변경만 가능한 토큰이 발급된다.
+	MustChangePass bool `db:"mustchangepass" json:"mustchangepass"`
+	// 이메일 인증키 만료 시각. 0이면 만료 시각이 도입되기 전에 가입한 사용자이며 가입 시각으로 계산한다.
+	ActivationExpire int64 `db:"activationexpire" json:"-"`
+	// 탈퇴 시각. 탈퇴 상태가 아니면 0이다.
+	Withdrawn int64 `db:"withdrawn" json:"-"`
+	// 블럭 사유. 블럭 상태가 아니면 빈 문자열이다.
+	BlockReason string `db:"blockreason" json:"blockreason"`
 }
 
 // IsValidIDFormat 함수는 입력된 아이디가 올바른 형식인지 검사한다.
@@ -44,15 +54,55 @@ func IsValidIDFormat(id string) bool {
 	return true
 }
 
-// IsValidPasswordFormat 함수는 입력된 비밀번호가 올바른 형식인지 검사한다.
+// IsValidPasswordFormat 함수는 입력된 비밀번호가 해시할 수 있는 형식인지 간단히 검사한다.
+// 로그인처럼 정책 검사가 필요 없는 곳에서 사용하며, 새 비밀번호는 ValidateNewPassword로 검사해야 한다.
 func IsValidPasswordFormat(password string) bool {
-	if len(password) > PasswordMaxSize {
+	if password == "" || utf8.RuneCountInString(password) > Config().PasswordMaxLength() {
 		return false
 	}
 
 	return true
 }
 
+// SetPassword 함수는 비밀번호를 현재 기본 방식으로 해시하여 설정한다. 데이터베이스에 저장하지는 않는다.
+func (u *User) SetPassword(password string) error {
+	hashed, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	u.Password = hashed
+	return nil
+}
+
+// TokenScope 함수는 사용자에게 발급할 토큰의 scope를 리턴한다.
+func (u User) TokenScope() string {
+	if u.MustChangePass {
+		return ScopeChangePassword
+	}
+	return ScopeAll
+}
+
+// SetActivationKey 함수는 새 이메일 인증키를 만들고 만료 시각을 설정한다. 데이터베이스에 저장하지는 않는다.
+// 이전 인증키는 더이상 사용할 수 없다.
+func (u *User) SetActivationKey() error {
+	key, err := crypto.NewUUID()
+	if err != nil {
+		return err
+	}
+	u.ActivationKey = key
+	u.ActivationExpire = utils.ServerTime() + Config().ActivationExpire()
+	return nil
+}
+
+// IsActivationExpired 함수는 이메일 인증키가 만료 되었는지 여부를 리턴한다.
+func (u User) IsActivationExpired() bool {
+	expire := u.ActivationExpire
+	if expire == 0 {
+		expire = u.Created + Config().ActivationExpire()
+	}
+	return expire < utils.ServerTime()
+}
+
 // Name 함수는 사용자의 이름을 불러온다. 사용자의 이름은 이메일의 @ 앞부분으로 한다.
 func (u User) Name() string {
 	return strings.Split(u.ID, "@")[0]
@@ -69,6 +119,7 @@ func (u User) IsBlocked() bool {
 }
 
 // IsWithdraw 함수는 사용자가 탈퇴 상태인지 여부를 리턴한다.
+// 탈퇴한 사용자도 유예 기간 동안은 데이터베이스에 남아 있다(IsRestorable 참고).
 func (u User) IsWithdraw() bool {
 	return u.Status == UserStatusWithdraw
 }
@@ -78,19 +129,22 @@ func (u User) IsDeactivated() bool {
 	return u.Status == UserStatusDeactivated
 }
 
-// IsAdmin 함수는 사용자가 운영자인지 여부를 리턴한다.
-// 운영자 계정을 발급하려면 가입 후 데이터베이스 관리자가 해당 유저의 Type을 UserTypeAdmin(-1)로
-// 수정해야 한다.
+// IsAdmin 함수는 사용자의 Type이 UserTypeAdmin인지 여부를 리턴한다.
+// UserTypeAdmin 사용자는 RoleAdmin 역할을 가진 것으로 취급된다. 운영 api의 권한은 HasPermission으로
+// 확인해야 하며, 처음 운영자 계정을 만들려면 가입 후 데이터베이스 관리자가 해당 유저의 Type을
+// UserTypeAdmin(-1)로 수정해야 한다.
 func (u User) IsAdmin() bool {
 	return u.Type == UserTypeAdmin
 }
 
 // Block 함수는 해당 사용자를 블럭 처리 시킨다.
 // 블럭 처리된 사용자는 로그인을 할 수 없으며, 로그인을 해야만 사용 가능한 API를 호출할 수 없다.
-func (u User) Block(reason string) error {
+// 사유는 BlockReasonMaxSize에 맞게 잘려 u에도 반영된다.
+func (u *User) Block(reason string) error {
 	db := Database()
 	u.Status = UserStatusBlock
-	if _, err := db.Auth.Update(&u); err != nil {
+	u.BlockReason = truncate(reason, BlockReasonMaxSize)
+	if err := db.Users.UpdateUser(u); err != nil {
 		log.Panicf("block failed. id=%v, reason=%v, err=%v", u.ID, reason, err)
 		return err
 	}
@@ -104,13 +158,14 @@ func (u User) Block(reason string) error {
 
 // UnBlock 함수는 블럭된 해당 사용자를 정상 유저로 변경한다.
 // 만일 해당 유저가 블럭된 계쩡이 아니라면 아무 처리도 하지 않는다.
-func (u User) UnBlock() error {
+func (u *User) UnBlock() error {
 	db := Database()
 	if u.Status != UserStatusBlock {
 		return nil
 	}
 	u.Status = UserStatusNormal
-	if _, err := db.Auth.Update(&u); err != nil {
+	u.BlockReason = ""
+	if err := db.Users.UpdateUser(u); err != nil {
 		log.Panicf("unblock failed. id=%v, err=%v", u.ID, err)
 	}
 	log.WithFields(log.Fields{"id": u.ID}).Info("UNBLOCK")
@@ -119,77 +174,94 @@ func (u User) UnBlock() error {
 
 // LoadUserFromID 함수는 사용자의 id를 사용해 데이터베이스로부터 사용자를 읽는다.
 func LoadUserFromID(id string) (*User, error) {
-	db := Database()
-	var user User
-	err := db.Auth.SelectOne(&user, "select * from users where id=?", id)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
+	return Database().Users.UserByID(id)
 }
 
 // LoadUserFromUID 함수는 사용자의 uid를 사용해 데이터베이스로부터 사용자를 읽는다.
 func LoadUserFromUID(uid int64) (*User, error) {
-	db := Database()
-	var user User
-	err := db.Auth.SelectOne(&user, "select * from users where uid=?", uid)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
+	return Database().Users.UserByUID(uid)
 }
 
 // LoadUserFromActivateionKey 함수는 사용자의 ActivationKey를 사용해 데이터베이스로부터
 // 사용자를 읽는다.
 func LoadUserFromActivateionKey(key string) (*User, error) {
-	db := Database()
-	var user User
-	err := db.Auth.SelectOne(&user, "select * from users where activationkey=?", key)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
+	return Database().Users.UserByActivationKey(key)
 }
 
 // SendMail 함수는 유저에게 titleTemplate 파일을 제목으로 하고 textTemplate를 내용으로 하는 메일을 보낸다.
 func SendMail(user *User, titleTemplate string, textTemplate string) error {
+	return SendMailWithData(user, titleTemplate, textTemplate, user)
+}
+
+// SendMailWithData 함수는 SendMail과 같지만 템플릿에 user 대신 data를 넘긴다.
+// 재설정 링크처럼 User 구조체에 없는 값을 메일에 넣어야 할 때 사용한다.
+func SendMailWithData(user *User, titleTemplate string, textTemplate string, data interface{}) error {
+	return EnqueueMail(NewMailWithData(user, titleTemplate, textTemplate, data))
+}
+
+// SendMailTo 함수는 사용자의 아이디가 아닌 주소로 메일을 보낸다.
+// 이메일 변경 확인처럼 아직 사용자의 아이디가 아닌 주소로 메일을 보내야 할 때 사용한다.
+// 메일은 템플릿으로 만들어 대기열에 넣기만 하므로 메일 서버가 느리거나 실패해도 요청이 지연되지 않는다.
+// 오류는 대기열에 넣지 못한 경우에만 리턴된다.
+func SendMailTo(to mail.Address, titleTemplate string, textTemplate string, data interface{}) error {
+	return EnqueueMail(NewMailTo(to, titleTemplate, textTemplate, data))
+}
+
+// NewMailWithData 함수는 SendMailWithData와 같이 메일을 만들지만 대기열에 넣지 않는다.
+// SignupUser처럼 다른 데이터와 함께 트랜잭션으로 대기열에 넣을 때 사용한다.
+func NewMailWithData(user *User, titleTemplate string, textTemplate string, data interface{}) *OutboxMail {
+	to := mail.Address{
+		Name:    user.Name(),
+		Address: user.ID,
+	}
+	return NewMailTo(to, titleTemplate, textTemplate, data)
+}
+
+// NewMailTo 함수는 titleTemplate, textTemplate 템플릿으로 to에게 보낼 메일을 만든다.
+func NewMailTo(to mail.Address, titleTemplate string, textTemplate string, data interface{}) *OutboxMail {
 	conf := Config()
 
 	var sbody bytes.Buffer
 	stmpl := conf.TemplatePath(titleTemplate)
-	utils.JoinTemplate(&sbody, stmpl, user)
+	utils.JoinTemplate(&sbody, stmpl, data)
 	// 이메일 제목에 \n이 있으면 안된다.
 	subject := strings.Replace(sbody.String(), "\n", "", -1)
 
-	smtp := &utils.SMTP{
-		Server:   conf.SMTP.Server,
-		Port:     conf.SMTP.Port,
-		User:     conf.SMTP.User,
-		Password: conf.SMTP.Password,
-	}
-
-	mail := &utils.Email{
-		From: mail.Address{
-			Name:    conf.SMTP.UserName,
-			Address: conf.SMTP.User,
-		},
-		To: mail.Address{
-			Name:    user.Name(),
-			Address: user.ID,
-		},
-		Subject: subject,
-	}
-
 	var body bytes.Buffer
 	tmpl := conf.TemplatePath(textTemplate)
-	utils.JoinTemplate(&body, tmpl, user)
-	mail.Body = body.String()
+	utils.JoinTemplate(&body, tmpl, data)
 
-	if err := smtp.Send(mail); err != nil {
-		return err
+	return &OutboxMail{
+		FromName:    conf.SMTP.UserName,
+		FromAddress: conf.SMTP.User,
+		ToName:      to.Name,
+		ToAddress:   to.Address,
+		Template:    textTemplate,
+		Subject:     subject,
+		Body:        body.String(),
 	}
+}
 
-	return nil
+// SignupUser 함수는 가입한 사용자를 추가하고 처음 설정한 비밀번호도 재사용 할 수 없도록 이력에 남긴다.
+// newMail이 nil이 아니면 newMail이 만든 메일(인증 메일 등)을 대기열에 넣는다. 모두 하나의 트랜잭션으로
+// 처리되므로 메일을 대기열에 넣지 못하면 사용자도 추가되지 않는다. newMail은 사용자의 UID가 정해진 뒤에
+// 불린다. 아이디가 중복되면 ErrDuplicated를 리턴한다.
+func SignupUser(user *User, newMail func(u *User) *OutboxMail) error {
+	queued := false
+	err := Database().Users.InsertUserWith(user, func(tx gorp.SqlExecutor) error {
+		if err := addPasswordHistory(tx, user); err != nil {
+			return err
+		}
+		if newMail == nil {
+			return nil
+		}
+		queued = true
+		return enqueueMail(tx, newMail(user))
+	})
+	if err == nil && queued {
+		wakeMailWorkers()
+	}
+	return err
 }
 
 // 사용자 스키마 상수 정의. 이곳에서 사용되는 상수는 데이터베이스에 반영되므로 값을 변경하면 안된다.
@@ -203,11 +275,12 @@ const (
 	UserTypeAdmin  = -1 // 관리자
 	UserTypeNormal = 0  // 일반 사용자
 
-	SaltMaxSize          = 16  // 사용자 비밀번호 필드 중에서 Salt가 차지하는 길이
+	SaltMaxSize          = 16  // 이전 방식(legacy)의 비밀번호 필드 중에서 Salt가 차지하는 길이
 	IDMaxSize            = 200 // 사용자 아이디 최대 길이
 	InfoMaxSize          = 500 // 사용자 정보 최대 길이
-	PasswordMaxSize      = 32  // 비밀번호 최대 길이
+	PasswordMaxSize      = 32  // 이전 버전의 비밀번호 최대 길이([passwordpolicy] 섹션의 maxlength로 대체됨)
 	ActivationKeyMaxSize = 36  // 이메일 인증키(UUID) 길이
+	BlockReasonMaxSize   = 255 // 블럭 사유 최대 길이
 )
 
 func createUserTable(dbmap *gorp.DbMap) {
@@ -215,8 +288,9 @@ func createUserTable(dbmap *gorp.DbMap) {
 	table := dbmap.AddTableWithName(User{}, "users").SetKeys(true, "UID")
 	table.ColMap("ID").SetMaxSize(IDMaxSize)
 	table.ColMap("Info").SetMaxSize(InfoMaxSize)
-	table.ColMap("Password").SetMaxSize(SaltMaxSize + PasswordMaxSize)
-	table.ColMap("PasswordTmp").SetMaxSize(SaltMaxSize + PasswordMaxSize)
+	table.ColMap("Password").SetMaxSize(PasswordHashMaxSize)
+	table.ColMap("PasswordTmp").SetMaxSize(PasswordHashMaxSize)
 	table.ColMap("ActivationKey").SetMaxSize(ActivationKeyMaxSize)
+	table.ColMap("BlockReason").SetMaxSize(BlockReasonMaxSize)
 	table.ColMap("ID").SetUnique(true)
 }
@@ -0,0 +1,110 @@
+package schema
+
+import (
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// UserFilter 구조체는 운영자가 사용자 목록을 검색할 때의 조건이다. nil이거나 빈 문자열인 조건은 사용하지 않는다.
+type UserFilter struct {
+	Query  string // 아이디(이메일)에 포함된 문자열
+	Status *int   // 사용자 상태(UserStatusXXX)
+	Type   *int   // 사용자 종류(UserTypeXXX)
+}
+
+// 사용자 목록 조회 상수
+const (
+	UserListDefaultSize = 20  // 한 페이지의 기본 사용자 수
+	UserListMaxSize     = 100 // 한 페이지의 최대 사용자 수
+)
+
+// escapeLike 함수는 like 검색어에 포함된 특수 문자를 이스케이프 한다.
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
+
+// where 함수는 검색 조건으로 where 절과 인자를 만든다. escape는 like 검색에 덧붙일 escape 절이다.
+func (f UserFilter) where(escape string) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if f.Query != "" {
+		conds = append(conds, "id like ?"+escape)
+		args = append(args, "%"+escapeLike(normalizeID(f.Query))+"%")
+	}
+	if f.Status != nil {
+		conds = append(conds, "status=?")
+		args = append(args, *f.Status)
+	}
+	if f.Type != nil {
+		conds = append(conds, "type=?")
+		args = append(args, *f.Type)
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " where " + strings.Join(conds, " and "), args
+}
+
+// SearchUsers 함수는 조건에 맞는 사용자 목록을 uid 순서로 읽는다.
+// page는 1부터 시작하며 조건에 맞는 전체 사용자 수를 함께 리턴한다.
+func SearchUsers(filter UserFilter, page int, size int) ([]*User, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if size <= 0 {
+		size = UserListDefaultSize
+	}
+	if size > UserListMaxSize {
+		size = UserListMaxSize
+	}
+
+	return Database().Users.SearchUsers(filter, (page-1)*size, size)
+}
+
+// SetType 함수는 사용자의 종류를 바꾼다. 운영자로 승격하거나 일반 사용자로 강등할 때 사용한다.
+func (u *User) SetType(userType int) error {
+	db := Database()
+	u.Type = userType
+	if err := db.Users.UpdateUser(u); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"id": u.ID, "type": userType}).Info("USER_TYPE")
+	return nil
+}
+
+// Activate 함수는 이메일 인증 전인 사용자를 정상 사용자로 만든다.
+// 운영자가 메일을 받지 못한 사용자를 직접 인증해 줄 때 사용한다.
+func (u *User) Activate() error {
+	db := Database()
+	if !u.IsDeactivated() {
+		return nil
+	}
+	u.Status = UserStatusNormal
+	u.ActivationKey = ""
+	if err := db.Users.UpdateUser(u); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"id": u.ID}).Info("ACTIVATE")
+	return nil
+}
+
+// ForcePasswordReset 함수는 사용자의 비밀번호 변경을 강제하고 모든 세션을 로그아웃 시킨다.
+// 비밀번호 재설정 토큰을 발급하여 리턴하며, 사용자는 재설정 링크나 기존 비밀번호로 로그인 한 뒤
+// /changepass로 비밀번호를 바꿔야 한다.
+func (u *User) ForcePasswordReset() (string, error) {
+	db := Database()
+	u.MustChangePass = true
+	if err := db.Users.UpdateUser(u); err != nil {
+		return "", err
+	}
+	if err := RevokeTokensIssuedBefore(u.UID, ServerTimeMilli()); err != nil {
+		return "", err
+	}
+	if err := RevokeSessionsOfUser(u.UID, ""); err != nil {
+		return "", err
+	}
+	log.WithFields(log.Fields{"id": u.ID}).Info("FORCE_PASSWORD_RESET")
+	return CreatePasswordReset(u)
+}
@@ -0,0 +1,112 @@
+package schema
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"jsproj.com/koo/gosari/utils"
+)
+
+// 탈퇴한 사용자의 데이터가 저장된 테이블과 사용자 uid 컬럼. 사용자가 소유한 데이터를 저장하는 테이블이
+// 추가되면 이 목록에도 추가해야 영구 삭제 시에 함께 지워진다.
+var userOwnedTables = []struct {
+	table  string
+	column string
+}{
+	{"todo", "owneruid"},
+	{"refreshtokens", "uid"},
+	{"sessions", "uid"},
+	{"passwordresets", "uid"},
+	{"passwordhistory", "uid"},
+	{"emailchanges", "uid"},
+	{"totp", "uid"},
+	{"recoverycodes", "uid"},
+	{"userroles", "uid"},
+}
+
+// Withdraw 함수는 사용자를 탈퇴 상태로 만들고 모든 세션을 로그아웃 시킨다.
+// 탈퇴한 사용자는 [withdraw] 섹션의 graceday 동안 다시 로그인하여 탈퇴를 취소할 수 있으며
+// 그 이후에는 purge 작업에 의해 사용자와 사용자의 데이터가 영구 삭제된다.
+func (u *User) Withdraw() error {
+	db := Database()
+	now := utils.ServerTime()
+
+	u.Status = UserStatusWithdraw
+	u.Withdrawn = now
+	if err := db.Users.UpdateUser(u); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"id": u.ID}).Info("WITHDRAW")
+
+	if err := RevokeTokensIssuedBefore(u.UID, ServerTimeMilli()); err != nil {
+		return err
+	}
+	return RevokeSessionsOfUser(u.UID, "")
+}
+
+// IsRestorable 함수는 탈퇴한 사용자가 아직 유예 기간 중이라 탈퇴를 취소할 수 있는지 여부를 리턴한다.
+func (u User) IsRestorable() bool {
+	return u.IsWithdraw() && u.Withdrawn+Config().WithdrawGrace() > utils.ServerTime()
+}
+
+// Restore 함수는 유예 기간 중인 탈퇴 사용자를 정상 사용자로 되돌린다.
+// 탈퇴를 취소할 수 없는 사용자라면 아무 처리도 하지 않는다.
+func (u *User) Restore() error {
+	db := Database()
+	if !u.IsRestorable() {
+		return nil
+	}
+	u.Status = UserStatusNormal
+	u.Withdrawn = 0
+	if err := db.Users.UpdateUser(u); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"id": u.ID}).Info("WITHDRAW_RESTORE")
+	return nil
+}
+
+// PurgeUser 함수는 before 이전에 탈퇴한 사용자와 사용자가 소유한 모든 데이터를 영구 삭제한다.
+// SQL 데이터베이스에서는 하나의 트랜잭션으로 처리된다. 그 사이에 탈퇴가 취소되었거나 취소 후 다시
+// 탈퇴하여 유예 기간이 새로 시작되었다면 아무 것도 지우지 않고 false를 리턴한다.
+func PurgeUser(uid int64, before int64) (bool, error) {
+	return Database().Users.PurgeWithdrawnUser(uid, before)
+}
+
+// PurgeWithdrawnUsers 함수는 유예 기간이 지난 탈퇴 사용자를 모두 영구 삭제하고 삭제한 사용자 수를 리턴한다.
+func PurgeWithdrawnUsers() (int, error) {
+	before := utils.ServerTime() - Config().WithdrawGrace()
+
+	users, err := Database().Users.WithdrawnUsersBefore(before)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, u := range users {
+		ok, err := PurgeUser(u.UID, before)
+		if err != nil {
+			return purged, err
+		}
+		if !ok {
+			continue
+		}
+		log.WithFields(log.Fields{"uid": u.UID, "id": u.ID}).Info("WITHDRAW_PURGE")
+		purged++
+	}
+	return purged, nil
+}
+
+// startPurgeJob 함수는 [withdraw] 섹션의 purgeintervalminute 마다 유예 기간이 지난 탈퇴 사용자를
+// 영구 삭제하는 작업을 시작한다. 설정이 다시 읽히면 다음 실행부터 반영된다.
+// 여러 서버에서 동시에 실행 되더라도 PurgeUser는 한 번만 지운다.
+func startPurgeJob() {
+	go func() {
+		for {
+			if n, err := PurgeWithdrawnUsers(); err != nil {
+				log.Errorf("withdrawn user purge failed. purged=%d, err=%v", n, err)
+			}
+			time.Sleep(time.Duration(Config().WithdrawPurgeInterval()) * time.Second)
+		}
+	}()
+	log.Info("withdrawn user purge job started.")
+}
@@ -2,7 +2,7 @@
 url="https://127.0.0.1:3334"
 
 echo "가입을 처리 합니다."
-curl -X POST -k -d '{"id":"koo@jsproj.com","password":"1111","name":"myname"}' $url/signup
+curl -X POST -k -d '{"id":"koo@jsproj.com","password":"Todo-test-2015","name":"myname"}' $url/signup
 echo .
 
 echo "이메일 인증을 처리 합니다."
@@ -11,7 +11,7 @@ curl -X GET -k https://127.0.0.1:3334/activation/$actkey
 read
 
 echo "로그인을 하여 토큰을 얻습니다."
-curl -X POST -k -d '{"id":"koo@jsproj.com", "password":"1111"}' $url/login > /tmp/jwttoken.tmp 2> /dev/null
+curl -X POST -k -d '{"id":"koo@jsproj.com", "password":"Todo-test-2015"}' $url/login > /tmp/jwttoken.tmp 2> /dev/null
 token=`cat /tmp/jwttoken.tmp | cut -d ":" -f 4 | cut -d "}" -f 1 | cut -d '"' -f 2`
 echo .
 
@@ -28,5 +28,5 @@ echo .
 #insert into todolist (owneruid, category, todo, detail, place, limittime, completetime) values (1, 'Personal', '엄마 심부름', '당근\n라면', '마트', 0, 0);
 
 echo "탈퇴 합니다."
-curl -X POST -k -H "Authorization: Bearer $token" $url/withdraw
+curl -X POST -k -H "Authorization: Bearer $token" -d '{"password":"Todo-test-2015"}' $url/withdraw
 echo .